@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aliyun/aliyun-log-jaeger/sls_store"
+	"google.golang.org/grpc/metadata"
+)
+
+// backfillState the progress of a backfill, with the source, target and range it belongs to, so that a state file
+// left by another backfill is never resumed.
+type backfillState struct {
+	SourceEndpoint string    `json:"sourceEndpoint"`
+	SourceProject  string    `json:"sourceProject"`
+	SourceInstance string    `json:"sourceInstance"`
+	Tenant         string    `json:"tenant,omitempty"`
+	TargetProject  string    `json:"targetProject"`
+	TargetInstance string    `json:"targetInstance"`
+	Start          string    `json:"start"`
+	End            string    `json:"end"`
+	Copied         time.Time `json:"copied"`
+}
+
+// sameBackfill whether the states belong to the same backfill, the progress is not compared.
+func (s backfillState) sameBackfill(other backfillState) bool {
+	s.Copied, other.Copied = time.Time{}, time.Time{}
+	return s == other
+}
+
+// runBackfill copies the spans of a time range from the old target of the migration, or the given source, to the
+// configured project and instance, or those of the tenant with multi-tenancy, window by window. The end of the last
+// copied window is saved to the state file, so that an interrupted backfill resumes from it.
+func runBackfill(args []string) (err error) {
+	var start, end, stateFile, tenant string
+	var window time.Duration
+	source := sls_store.MigrationTarget{}
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fs.StringVar(&configPath, "config", "", "Path to the alibaba log jaeger plugin's configuration file")
+	fs.StringVar(&start, "start", "", "Start of the time range to copy, in RFC3339 format")
+	fs.StringVar(&end, "end", "", "End of the time range to copy, in RFC3339 format, now if empty")
+	fs.DurationVar(&window, "window", 10*time.Minute, "Time range copied by one step")
+	fs.StringVar(&stateFile, "state", "backfill.state", "File keeping the progress, to resume an interrupted backfill")
+	fs.StringVar(&source.Project, "source-project", "", "Project to copy from, MIGRATION_OLD_PROJECT if empty")
+	fs.StringVar(&source.Instance, "source-instance", "", "Instance to copy from, MIGRATION_OLD_INSTANCE if empty")
+	fs.StringVar(&source.Endpoint, "source-endpoint", "", "Endpoint of the project to copy from")
+	fs.StringVar(&tenant, "tenant", "", "Tenant to copy to, required when tenancy is enabled")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	configuration, err := initialParameters(configPath, logger)
+	if err != nil {
+		return err
+	}
+
+	if source.Project == "" && configuration.Migration != nil {
+		source = *configuration.Migration
+	}
+	if source.Project == "" || source.Instance == "" {
+		return errors.New("the source project and instance can't be empty")
+	}
+
+	from, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+	to := time.Now()
+	if end != "" {
+		if to, err = time.Parse(time.RFC3339, end); err != nil {
+			return fmt.Errorf("invalid end: %w", err)
+		}
+	}
+	if window < time.Second {
+		return errors.New("the window can't be shorter than one second")
+	}
+
+	state := backfillState{
+		SourceEndpoint: source.Endpoint,
+		SourceProject:  source.Project,
+		SourceInstance: source.Instance,
+		TargetProject:  configuration.Project,
+		TargetInstance: configuration.Instance,
+		Start:          start,
+		End:            end,
+	}
+	ctx := context.Background()
+	if configuration.Tenancy.Enabled {
+		tenantConfig, ok := configuration.Tenancy.Tenants[tenant]
+		if !ok {
+			return fmt.Errorf("unknown tenant %q, set -tenant to one of the TENANTS", tenant)
+		}
+		header := configuration.Tenancy.Header
+		if header == "" {
+			header = sls_store.DefaultTenantHeader
+		}
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(header, tenant))
+		state.Tenant = tenant
+		state.TargetProject = tenantConfig.Project
+		state.TargetInstance = tenantConfig.Instance
+	} else if tenant != "" {
+		return errors.New("-tenant needs tenancy to be enabled")
+	}
+	saved, ok, err := readBackfillState(stateFile)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if !saved.sameBackfill(state) {
+			return fmt.Errorf("the state file %s belongs to the backfill of %s/%s to %s/%s from %q to %q, remove it or "+
+				"choose another file with -state", stateFile, saved.SourceProject, saved.SourceInstance, saved.TargetProject,
+				saved.TargetInstance, saved.Start, saved.End)
+		}
+		if saved.Copied.After(from) {
+			logger.Info("Resuming backfill", "From", saved.Copied, "State", stateFile)
+			from = saved.Copied
+		}
+	}
+
+	// the copied spans must not be mirrored back to the source
+	configuration.Migration = nil
+	plugin := newPlugin(configuration)
+	defer func() {
+		if e := plugin.Close(); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	total := to.Sub(from)
+	copied := 0
+	for current := from; current.Before(to); {
+		next := current.Add(window)
+		if next.After(to) {
+			next = to
+		}
+
+		n, err := plugin.CopySpans(ctx, source, current.Unix(), next.Unix())
+		copied += n
+		if err != nil {
+			return fmt.Errorf("failed to copy %s - %s, rerun to resume: %w", current.Format(time.RFC3339), next.Format(time.RFC3339), err)
+		}
+		state.Copied = next
+		if err := writeBackfillState(stateFile, state); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "%s - %s: %d spans, %.1f%% done, %d spans copied\n", current.Format(time.RFC3339),
+			next.Format(time.RFC3339), n, 100*float64(next.Sub(from))/float64(total), copied)
+		current = next
+	}
+
+	logger.Info("Backfill finished", "Spans", copied)
+	return nil
+}
+
+// readBackfillState reads the state file, a missing file is no state.
+func readBackfillState(path string) (backfillState, bool, error) {
+	var state backfillState
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, false, nil
+	}
+	if err != nil {
+		return state, false, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, false, fmt.Errorf("invalid backfill state %s, remove it to start over: %w", path, err)
+	}
+	return state, true, nil
+}
+
+// writeBackfillState replaces the state file atomically, so that an interrupted write never loses the progress.
+func writeBackfillState(path string, state backfillState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
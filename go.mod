@@ -7,8 +7,13 @@ require (
 	github.com/gogo/protobuf v1.3.2
 	github.com/hashicorp/go-hclog v0.16.2
 	github.com/jaegertracing/jaeger v1.24.0
+	github.com/klauspost/compress v1.12.2
+	github.com/pierrec/lz4 v2.6.0+incompatible
 	github.com/spf13/cast v1.3.1
 	github.com/spf13/viper v1.8.1
+	go.opentelemetry.io/proto/otlp v0.9.0
+	google.golang.org/grpc v1.39.0
+	google.golang.org/protobuf v1.27.1
 )
 
-replace github.com/aliyun/aliyun-log-jaeger  => ./
+replace github.com/aliyun/aliyun-log-jaeger => ./
@@ -8,14 +8,24 @@ import (
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc"
 	"github.com/jaegertracing/jaeger/plugin/storage/grpc/shared"
 	"github.com/spf13/viper"
+	"net/http"
+	"os"
+	"strconv"
 	"time"
 )
 
 const (
 	DefaultLookBack = 6
+	// DefaultGRPCHostPort the default listening address of the remote storage server
+	DefaultGRPCHostPort = ":17271"
+	// PluginMode run as a go-plugin child process of jaeger
+	PluginMode = "plugin"
+	// RemoteMode run as a standalone jaeger remote storage gRPC server
+	RemoteMode = "remote"
 )
 
 var configPath string
+var mode string
 
 type Configuration struct {
 	Endpoint     string        `yaml:"endpoint"`
@@ -23,7 +33,32 @@ type Configuration struct {
 	AccessSecret string        `yaml:"accessSecret"`
 	Project      string        `yaml:"project"`
 	Instance     string        `yaml:"instance"`
-	MaxLookBack  time.Duration `yaml:maxLookBack`
+	MaxLookBack  time.Duration `yaml:"maxLookBack"`
+	Remote       RemoteConfiguration
+	OTLP         OTLPConfiguration
+	Cache        sls_store.CacheConfig
+	MetricsAddr  string `yaml:"metricsHostPort"`
+	// OperationsLogStore the logstore of the materialized operations index
+	OperationsLogStore string `yaml:"operationsLogstore"`
+	TraceSearch        sls_store.TraceSearchOptions
+	Redactor           *sls_store.Redactor
+	Sampling           sls_store.SamplingConfig
+	SizeLimits         sls_store.SizeLimits
+	Spool              sls_store.SpoolConfig
+	ShardRouting       bool
+	LogGroupTemplate   sls_store.LogGroupTemplate
+	Tenancy            sls_store.TenancyConfig
+	Federation         []sls_store.FederationTarget
+	Migration          *sls_store.MigrationTarget
+	AccessMode         string
+	SelfCheck          bool
+	SelfCheckWrite     bool
+	SelfTracing        sls_store.SelfTracingConfig
+	SlowQuery          sls_store.SlowQueryConfig
+	IncompleteResults  sls_store.IncompleteResultConfig
+	RateLimits         sls_store.RateLimitConfig
+	Transport          sls_store.TransportConfig
+	LogTimestampUnit   string
 }
 
 var logger = hclog.New(&hclog.LoggerOptions{
@@ -33,7 +68,28 @@ var logger = hclog.New(&hclog.LoggerOptions{
 })
 
 func main() {
+	if len(os.Args) > 1 {
+		var command func([]string) error
+		switch os.Args[1] {
+		case "export":
+			command = runExport
+		case "import":
+			command = runImport
+		case "backfill":
+			command = runBackfill
+		}
+
+		if command != nil {
+			if err := command(os.Args[2:]); err != nil {
+				logger.Error("Failed to "+os.Args[1]+" traces", "Exception", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	flag.StringVar(&configPath, "config", "", "Path to the alibaba log jaeger plugin's configuration file")
+	flag.StringVar(&mode, "mode", PluginMode, "The running mode of the plugin, plugin or remote")
 	flag.Parse()
 
 	configuration, err := initialParameters(configPath, logger)
@@ -42,7 +98,60 @@ func main() {
 		return
 	}
 
-	var plugin = sls_store.NewSLSStorageForJaegerPlugin(
+	var plugin = newPlugin(configuration)
+
+	if configuration.MetricsAddr != "" {
+		go func() {
+			// expvar registers the metrics handler at /debug/vars of the default mux
+			if err := http.ListenAndServe(configuration.MetricsAddr, nil); err != nil {
+				logger.Error("Metrics server stopped", "Exception", err)
+			}
+		}()
+	}
+
+	if configuration.SelfCheck {
+		if err := plugin.SelfCheck(configuration.SelfCheckWrite); err != nil {
+			logger.Error("Self check failed", "Mode", configuration.AccessMode, "Exception", err)
+			os.Exit(1)
+		}
+	}
+
+	if configuration.AccessMode == sls_store.ReadOnlyMode {
+		if configuration.OTLP.GRPCHostPort != "" || configuration.OTLP.HTTPHostPort != "" {
+			logger.Warn("The OTLP receivers are not started in read-only mode")
+		}
+	} else if err := startOTLPReceivers(plugin.OTLPReceiver(), &configuration.OTLP, logger); err != nil {
+		logger.Error("Failed to start OTLP receivers", "Exception", err)
+		os.Exit(1)
+	}
+
+	var serveErr error
+	switch mode {
+	case PluginMode:
+		grpc.Serve(&shared.PluginServices{
+			Store: plugin,
+		})
+	case RemoteMode:
+		serveErr = serveRemote(plugin, &configuration.Remote, logger)
+	default:
+		logger.Error("Unknown mode", "mode", mode)
+		os.Exit(1)
+	}
+
+	// the server stopped, write the spans still buffered before exiting
+	if err := plugin.Close(); err != nil {
+		logger.Error("Failed to flush buffered spans", "Exception", err)
+	}
+	if serveErr != nil {
+		logger.Error("Failed to serve remote storage", "Exception", serveErr)
+		os.Exit(1)
+	}
+
+	logger.Info("SLS jaeger plugin initialized Successfully")
+}
+
+func newPlugin(configuration *Configuration) *sls_store.SlsJaegerStoragePlugin {
+	return sls_store.NewSLSStorageForJaegerPlugin(
 		configuration.Endpoint,
 		configuration.AccessKeyID,
 		configuration.AccessSecret,
@@ -50,13 +159,26 @@ func main() {
 		configuration.Instance,
 		configuration.MaxLookBack,
 		logger,
+		sls_store.WithQueryCache(configuration.Cache),
+		sls_store.WithOperationsLogStore(configuration.OperationsLogStore),
+		sls_store.WithTraceSearchOptions(configuration.TraceSearch),
+		sls_store.WithRedactor(configuration.Redactor),
+		sls_store.WithSampling(configuration.Sampling),
+		sls_store.WithSizeLimits(configuration.SizeLimits),
+		sls_store.WithSpool(configuration.Spool),
+		sls_store.WithShardRouting(configuration.ShardRouting),
+		sls_store.WithLogGroupTemplate(configuration.LogGroupTemplate),
+		sls_store.WithTenancy(configuration.Tenancy),
+		sls_store.WithFederation(configuration.Federation),
+		sls_store.WithMigration(configuration.Migration),
+		sls_store.WithAccessMode(configuration.AccessMode),
+		sls_store.WithSelfTracing(configuration.SelfTracing),
+		sls_store.WithSlowQueryLog(configuration.SlowQuery),
+		sls_store.WithIncompleteResults(configuration.IncompleteResults),
+		sls_store.WithRateLimits(configuration.RateLimits),
+		sls_store.WithTransport(configuration.Transport),
+		sls_store.WithLogTimestampUnit(configuration.LogTimestampUnit),
 	)
-
-	grpc.Serve(&shared.PluginServices{
-		Store: plugin,
-	})
-
-	logger.Info("SLS jaeger plugin initialized Successfully")
 }
 
 func initialParameters(configPath string, logger hclog.Logger) (*Configuration, error) {
@@ -120,6 +242,295 @@ func (c *Configuration) InitFromViper(v *viper.Viper) error {
 	}
 
 	c.MaxLookBack = time.Duration(lookBack) * time.Hour
+	if err := c.Remote.InitFromViper(v); err != nil {
+		return err
+	}
+	c.OTLP.InitFromViper(v)
+	c.Cache = sls_store.CacheConfig{
+		Enabled:         v.GetBool("CACHE_ENABLED"),
+		TTL:             durationOrDefault(v, "CACHE_TTL", sls_store.DefaultCacheTTL),
+		RefreshInterval: durationOrDefault(v, "CACHE_REFRESH_INTERVAL", sls_store.DefaultCacheRefreshInterval),
+		MaxOperations:   intIfSet(v, "CACHE_MAX_OPERATIONS", sls_store.DefaultCacheMaxOperations),
+		MaxTraces:       intIfSet(v, "CACHE_MAX_TRACES", sls_store.DefaultCacheMaxTraces),
+		TraceTTL:        durationOrDefault(v, "CACHE_TRACE_TTL", sls_store.DefaultCacheTraceTTL),
+		MinTraceAge:     durationOrDefault(v, "CACHE_MIN_TRACE_AGE", sls_store.DefaultCacheMinTraceAge),
+	}
+	if c.Cache.MaxOperations < 0 || c.Cache.MaxTraces < 0 {
+		logger.Error("The CACHE_MAX_OPERATIONS and CACHE_MAX_TRACES can't be negative")
+		return errors.New("The CACHE_MAX_OPERATIONS and CACHE_MAX_TRACES can't be negative")
+	}
+	c.MetricsAddr = v.GetString("METRICS_HOST_PORT")
+	c.OperationsLogStore = v.GetString("OPERATIONS_LOGSTORE")
+	var rules []sls_store.RedactionRule
+	if err := v.UnmarshalKey("REDACTION_RULES", &rules); err != nil {
+		logger.Error("Failed to parse REDACTION_RULES", "Exception", err)
+		return err
+	}
+	redactor, err := sls_store.NewRedactor(rules, v.GetString("REDACTION_HASH_SALT"))
+	if err != nil {
+		logger.Error("Invalid redaction rules", "Exception", err)
+		return err
+	}
+	c.Redactor = redactor
+
+	c.Sampling = sls_store.SamplingConfig{
+		Enabled:           v.GetBool("SAMPLING_ENABLED"),
+		BufferWindow:      durationOrDefault(v, "SAMPLING_BUFFER_WINDOW", sls_store.DefaultSamplingBufferWindow),
+		MaxBufferedTraces: intOrDefault(v, "SAMPLING_MAX_BUFFERED_TRACES", sls_store.DefaultSamplingMaxBufferedTraces),
+		ProbabilisticRate: 1,
+		DefaultRateLimit:  v.GetFloat64("SAMPLING_DEFAULT_RATE_LIMIT"),
+		ServiceRateLimits: make(map[string]float64),
+		KeepErrors:        !v.IsSet("SAMPLING_KEEP_ERRORS") || v.GetBool("SAMPLING_KEEP_ERRORS"),
+		LatencyThreshold:  v.GetDuration("SAMPLING_LATENCY_THRESHOLD"),
+		DecisionTTL:       durationOrDefault(v, "SAMPLING_DECISION_TTL", sls_store.DefaultSamplingDecisionTTL),
+	}
+	if v.IsSet("SAMPLING_RATE") {
+		c.Sampling.ProbabilisticRate = v.GetFloat64("SAMPLING_RATE")
+	}
+	if c.Sampling.ProbabilisticRate < 0 || c.Sampling.ProbabilisticRate > 1 {
+		logger.Error("The SAMPLING_RATE must be between 0 and 1", "SAMPLING_RATE", c.Sampling.ProbabilisticRate)
+		return errors.New("The SAMPLING_RATE must be between 0 and 1")
+	}
+	for service, limit := range v.GetStringMapString("SAMPLING_SERVICE_RATE_LIMITS") {
+		rate, err := strconv.ParseFloat(limit, 64)
+		if err != nil {
+			logger.Error("Invalid SAMPLING_SERVICE_RATE_LIMITS", "Service", service, "Exception", err)
+			return err
+		}
+		c.Sampling.ServiceRateLimits[service] = rate
+	}
+
+	c.SizeLimits = sls_store.SizeLimits{
+		MaxFieldSize: intOrDefault(v, "MAX_FIELD_SIZE", sls_store.DefaultMaxFieldSize),
+		MaxSpanSize:  intOrDefault(v, "MAX_SPAN_SIZE", sls_store.DefaultMaxSpanSize),
+	}
+
+	c.Spool = sls_store.SpoolConfig{
+		Dir:         v.GetString("SPOOL_DIR"),
+		MaxBytes:    int64(intOrDefault(v, "SPOOL_MAX_BYTES", sls_store.DefaultSpoolMaxBytes)),
+		MaxAge:      durationOrDefault(v, "SPOOL_MAX_AGE", sls_store.DefaultSpoolMaxAge),
+		SegmentSize: int64(intOrDefault(v, "SPOOL_SEGMENT_SIZE", sls_store.DefaultSpoolSegmentSize)),
+	}
+
+	c.ShardRouting = v.GetBool("SHARD_HASH_ROUTING")
+	c.LogGroupTemplate = sls_store.DefaultLogGroupTemplate
+	c.LogGroupTemplate.Topic = v.GetString("LOG_TOPIC_TEMPLATE")
+	if v.IsSet("LOG_SOURCE_TEMPLATE") {
+		c.LogGroupTemplate.Source = v.GetString("LOG_SOURCE_TEMPLATE")
+	}
+	c.LogGroupTemplate.Tags = v.GetStringMapString("LOG_TAGS_TEMPLATE")
+
+	c.Tenancy = sls_store.TenancyConfig{
+		Enabled: v.GetBool("TENANCY_ENABLED"),
+		Header:  v.GetString("TENANCY_HEADER"),
+	}
+	if err := v.UnmarshalKey("TENANTS", &c.Tenancy.Tenants); err != nil {
+		logger.Error("Failed to parse TENANTS", "Exception", err)
+		return err
+	}
+	for tenant, tenantConfig := range c.Tenancy.Tenants {
+		if tenantConfig.Project == "" || tenantConfig.Instance == "" {
+			logger.Error("The project and instance of tenant can't be empty", "Tenant", tenant)
+			return errors.New("The project and instance of tenant can't be empty")
+		}
+		if tenantConfig.LogTimestampUnit != "" {
+			if err := sls_store.ValidateTimestampUnit(tenantConfig.LogTimestampUnit); err != nil {
+				logger.Error("Invalid logTimestampUnit of tenant", "Tenant", tenant, "Exception", err)
+				return err
+			}
+		}
+	}
+	if c.Tenancy.Enabled && len(c.Tenancy.Tenants) == 0 {
+		logger.Error("The TENANTS can't be empty when tenancy is enabled")
+		return errors.New("The TENANTS can't be empty when tenancy is enabled")
+	}
+
+	if err := v.UnmarshalKey("FEDERATION_TARGETS", &c.Federation); err != nil {
+		logger.Error("Failed to parse FEDERATION_TARGETS", "Exception", err)
+		return err
+	}
+	for _, target := range c.Federation {
+		if target.Project == "" || target.Instance == "" {
+			logger.Error("The project and instance of federation target can't be empty", "Target", target.Name)
+			return errors.New("The project and instance of federation target can't be empty")
+		}
+		if target.LogTimestampUnit != "" {
+			if err := sls_store.ValidateTimestampUnit(target.LogTimestampUnit); err != nil {
+				logger.Error("Invalid logTimestampUnit of federation target", "Target", target.Name, "Exception", err)
+				return err
+			}
+		}
+	}
+
+	if oldProject := v.GetString("MIGRATION_OLD_PROJECT"); oldProject != "" {
+		c.Migration = &sls_store.MigrationTarget{
+			Name:            "old",
+			Endpoint:        v.GetString("MIGRATION_OLD_ENDPOINT"),
+			AccessKeyID:     v.GetString("MIGRATION_OLD_ACCESS_KEY_ID"),
+			AccessKeySecret: v.GetString("MIGRATION_OLD_ACCESS_KEY_SECRET"),
+			Project:         oldProject,
+			Instance:        v.GetString("MIGRATION_OLD_INSTANCE"),
+		}
+		if c.Migration.Instance == "" {
+			logger.Error("The MIGRATION_OLD_INSTANCE can't be empty when MIGRATION_OLD_PROJECT is set")
+			return errors.New("The MIGRATION_OLD_INSTANCE can't be empty when MIGRATION_OLD_PROJECT is set")
+		}
+	}
+
+	c.AccessMode = v.GetString("ACCESS_MODE")
+	switch c.AccessMode {
+	case "":
+		c.AccessMode = sls_store.ReadWriteMode
+	case sls_store.ReadWriteMode, sls_store.ReadOnlyMode, sls_store.WriteOnlyMode:
+	default:
+		logger.Error("Unknown ACCESS_MODE", "ACCESS_MODE", c.AccessMode)
+		return errors.New("The ACCESS_MODE must be read-write, read-only or write-only")
+	}
+	c.SelfCheck = v.GetBool("SELF_CHECK")
+	c.SelfCheckWrite = v.GetBool("SELF_CHECK_WRITE")
+
+	c.SelfTracing = sls_store.SelfTracingConfig{
+		Enabled:         v.GetBool("SELF_TRACING_ENABLED"),
+		OTLPEndpoint:    v.GetString("SELF_TRACING_OTLP_ENDPOINT"),
+		LogStore:        v.GetString("SELF_TRACING_LOGSTORE"),
+		ServiceName:     v.GetString("SELF_TRACING_SERVICE_NAME"),
+		SampleRate:      1,
+		WriteSampleRate: sls_store.DefaultSelfTracingWriteSampleRate,
+	}
+	if v.IsSet("SELF_TRACING_SAMPLE_RATE") {
+		c.SelfTracing.SampleRate = v.GetFloat64("SELF_TRACING_SAMPLE_RATE")
+	}
+	if v.IsSet("SELF_TRACING_WRITE_SAMPLE_RATE") {
+		c.SelfTracing.WriteSampleRate = v.GetFloat64("SELF_TRACING_WRITE_SAMPLE_RATE")
+	}
+	if c.SelfTracing.SampleRate < 0 || c.SelfTracing.SampleRate > 1 || c.SelfTracing.WriteSampleRate < 0 || c.SelfTracing.WriteSampleRate > 1 {
+		logger.Error("The SELF_TRACING_SAMPLE_RATE and SELF_TRACING_WRITE_SAMPLE_RATE must be between 0 and 1")
+		return errors.New("The SELF_TRACING_SAMPLE_RATE and SELF_TRACING_WRITE_SAMPLE_RATE must be between 0 and 1")
+	}
+	if c.SelfTracing.Enabled && c.SelfTracing.OTLPEndpoint == "" && c.SelfTracing.LogStore == "" {
+		logger.Error("The SELF_TRACING_OTLP_ENDPOINT or SELF_TRACING_LOGSTORE must be set when self tracing is enabled")
+		return errors.New("The SELF_TRACING_OTLP_ENDPOINT or SELF_TRACING_LOGSTORE must be set when self tracing is enabled")
+	}
+
+	if level := v.GetString("LOG_LEVEL"); level != "" {
+		logLevel := hclog.LevelFromString(level)
+		if logLevel == hclog.NoLevel {
+			logger.Error("Unknown LOG_LEVEL", "LOG_LEVEL", level)
+			return errors.New("The LOG_LEVEL must be trace, debug, info, warn or error")
+		}
+		logger.SetLevel(logLevel)
+		sls_store.SetLogLevel(logLevel)
+	}
+
+	c.SlowQuery = sls_store.DefaultSlowQueryConfig
+	if v.IsSet("SLOW_QUERY_THRESHOLD") {
+		c.SlowQuery.Threshold = v.GetDuration("SLOW_QUERY_THRESHOLD")
+	}
+	if v.IsSet("SLOW_QUERY_SAMPLE_RATE") {
+		c.SlowQuery.SampleRate = v.GetFloat64("SLOW_QUERY_SAMPLE_RATE")
+	}
+	if v.IsSet("QUERY_LOG_SAMPLE_RATE") {
+		c.SlowQuery.QuerySampleRate = v.GetFloat64("QUERY_LOG_SAMPLE_RATE")
+	}
+	if c.SlowQuery.SampleRate < 0 || c.SlowQuery.SampleRate > 1 || c.SlowQuery.QuerySampleRate < 0 || c.SlowQuery.QuerySampleRate > 1 {
+		logger.Error("The SLOW_QUERY_SAMPLE_RATE and QUERY_LOG_SAMPLE_RATE must be between 0 and 1")
+		return errors.New("The SLOW_QUERY_SAMPLE_RATE and QUERY_LOG_SAMPLE_RATE must be between 0 and 1")
+	}
+
+	c.IncompleteResults = sls_store.DefaultIncompleteResultConfig
+	if v.IsSet("INCOMPLETE_RETRY_DEADLINE") {
+		c.IncompleteResults.RetryDeadline = v.GetDuration("INCOMPLETE_RETRY_DEADLINE")
+	}
+	c.IncompleteResults.Fail = v.GetBool("INCOMPLETE_RESULT_ERROR")
+
+	c.RateLimits = sls_store.DefaultRateLimitConfig
+	c.RateLimits.Default = sls_store.RateLimit{
+		Reads:  v.GetFloat64("RATE_LIMIT_READS"),
+		Writes: v.GetFloat64("RATE_LIMIT_WRITES"),
+		Burst:  v.GetFloat64("RATE_LIMIT_BURST"),
+	}
+	if err := v.UnmarshalKey("RATE_LIMITS", &c.RateLimits.LogStores); err != nil {
+		logger.Error("Failed to parse RATE_LIMITS", "Exception", err)
+		return err
+	}
+	for logstore, limit := range c.RateLimits.LogStores {
+		if limit.Reads < 0 || limit.Writes < 0 || limit.Burst < 0 {
+			logger.Error("The rate limits can't be negative", "Logstore", logstore)
+			return errors.New("The rate limits can't be negative")
+		}
+	}
+	if c.RateLimits.Default.Reads < 0 || c.RateLimits.Default.Writes < 0 || c.RateLimits.Default.Burst < 0 {
+		logger.Error("The rate limits can't be negative")
+		return errors.New("The rate limits can't be negative")
+	}
+	if v.IsSet("THROTTLE_RETRIES") {
+		c.RateLimits.ThrottleRetries = v.GetInt("THROTTLE_RETRIES")
+	}
+
+	c.Transport = sls_store.TransportConfig{
+		Compression:         v.GetString("PUT_LOGS_COMPRESSION"),
+		MaxIdleConns:        v.GetInt("HTTP_MAX_IDLE_CONNS"),
+		MaxIdleConnsPerHost: v.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST"),
+		IdleConnTimeout:     v.GetDuration("HTTP_IDLE_CONN_TIMEOUT"),
+		KeepAlive:           v.GetDuration("HTTP_KEEPALIVE"),
+	}
+	if c.Transport.Compression == "" {
+		c.Transport.Compression = sls_store.CompressionLZ4
+	}
+	if err := sls_store.ValidateCompression(c.Transport.Compression); err != nil {
+		logger.Error("Invalid PUT_LOGS_COMPRESSION", "PUT_LOGS_COMPRESSION", c.Transport.Compression, "Exception", err)
+		return err
+	}
+
+	c.LogTimestampUnit = v.GetString("SPAN_LOG_TIMESTAMP_UNIT")
+	if c.LogTimestampUnit != "" {
+		if err := sls_store.ValidateTimestampUnit(c.LogTimestampUnit); err != nil {
+			logger.Error("Invalid SPAN_LOG_TIMESTAMP_UNIT", "SPAN_LOG_TIMESTAMP_UNIT", c.LogTimestampUnit, "Exception", err)
+			return err
+		}
+	}
+
+	c.TraceSearch = sls_store.TraceSearchOptions{
+		Order:        v.GetString("TRACE_ORDER"),
+		DurationMode: v.GetString("DURATION_FILTER_MODE"),
+		RootOnly:     v.GetBool("ROOT_SPAN_FILTER"),
+		LinkedTraces: v.GetInt("LINKED_TRACES"),
+	}
+	if c.TraceSearch.LinkedTraces < 0 {
+		logger.Error("The LINKED_TRACES can't be negative", "LINKED_TRACES", c.TraceSearch.LinkedTraces)
+		return errors.New("The LINKED_TRACES can't be negative")
+	}
+	if since := v.GetString("LOG_TOPIC_SERVICE_SINCE"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			logger.Error("The LOG_TOPIC_SERVICE_SINCE must be an RFC3339 time", "LOG_TOPIC_SERVICE_SINCE", since, "Exception", err)
+			return err
+		}
+		c.TraceSearch.ServiceTopicSince = t
+	}
+
 	logger.Info("Parameters", "AccessSecret", c.AccessSecret, "AccessKeyID", c.AccessKeyID, "Project", c.Project, "Instance", c.Instance, "Endpoint", c.Endpoint, "MaxLookBack", c.MaxLookBack)
 	return nil
 }
+
+func durationOrDefault(v *viper.Viper, key string, defaultValue time.Duration) time.Duration {
+	if d := v.GetDuration(key); d > 0 {
+		return d
+	}
+	return defaultValue
+}
+
+// intIfSet returns the value of the key when it is set, so that 0 can be set explicitly.
+func intIfSet(v *viper.Viper, key string, defaultValue int) int {
+	if v.IsSet(key) {
+		return v.GetInt(key)
+	}
+	return defaultValue
+}
+
+func intOrDefault(v *viper.Viper, key string, defaultValue int) int {
+	if i := v.GetInt(key); i > 0 {
+		return i
+	}
+	return defaultValue
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/aliyun/aliyun-log-jaeger/sls_store"
+	"github.com/hashicorp/go-hclog"
+	"github.com/spf13/viper"
+	collectorV1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// OTLPConfiguration the configuration of the native OTLP trace receivers, an empty address disables the receiver
+type OTLPConfiguration struct {
+	GRPCHostPort string `yaml:"otlpGrpcHostPort"`
+	HTTPHostPort string `yaml:"otlpHttpHostPort"`
+}
+
+func (c *OTLPConfiguration) InitFromViper(v *viper.Viper) {
+	c.GRPCHostPort = v.GetString("OTLP_GRPC_HOST_PORT")
+	c.HTTPHostPort = v.GetString("OTLP_HTTP_HOST_PORT")
+}
+
+// startOTLPReceivers starts the enabled OTLP receivers in background.
+func startOTLPReceivers(receiver *sls_store.OTLPReceiver, c *OTLPConfiguration, logger hclog.Logger) error {
+	if c.GRPCHostPort != "" {
+		listener, err := net.Listen("tcp", c.GRPCHostPort)
+		if err != nil {
+			return err
+		}
+
+		server := grpc.NewServer()
+		collectorV1.RegisterTraceServiceServer(server, receiver)
+		go func() {
+			logger.Info("Starting OTLP gRPC receiver", "HostPort", c.GRPCHostPort)
+			if err := server.Serve(listener); err != nil {
+				logger.Error("OTLP gRPC receiver stopped", "Exception", err)
+			}
+		}()
+	}
+
+	if c.HTTPHostPort != "" {
+		listener, err := net.Listen("tcp", c.HTTPHostPort)
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			logger.Info("Starting OTLP HTTP receiver", "HostPort", c.HTTPHostPort)
+			if err := http.Serve(listener, receiver); err != nil {
+				logger.Error("OTLP HTTP receiver stopped", "Exception", err)
+			}
+		}()
+	}
+
+	return nil
+}
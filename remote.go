@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/plugin/storage/grpc/shared"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// DefaultKeepAliveTime the default interval of server side keepalive pings
+	DefaultKeepAliveTime = 2 * time.Hour
+	// DefaultKeepAliveTimeout the default time to wait for a keepalive ack
+	DefaultKeepAliveTimeout = 20 * time.Second
+	// DefaultKeepAliveMinTime the default minimum interval clients may send keepalive pings
+	DefaultKeepAliveMinTime = 5 * time.Minute
+	// DefaultMaxRecvMsgSize the default max size of a received message
+	DefaultMaxRecvMsgSize = 4 * 1024 * 1024
+)
+
+// RemoteConfiguration the configuration of the standalone remote storage gRPC server
+type RemoteConfiguration struct {
+	HostPort          string        `yaml:"grpcHostPort"`
+	TLSEnabled        bool          `yaml:"tlsEnabled"`
+	TLSCert           string        `yaml:"tlsCert"`
+	TLSKey            string        `yaml:"tlsKey"`
+	TLSClientCA       string        `yaml:"tlsClientCA"`
+	KeepAliveTime     time.Duration `yaml:"keepAliveTime"`
+	KeepAliveTimeout  time.Duration `yaml:"keepAliveTimeout"`
+	KeepAliveMinTime  time.Duration `yaml:"keepAliveMinTime"`
+	MaxConnectionIdle time.Duration `yaml:"maxConnectionIdle"`
+	MaxConnectionAge  time.Duration `yaml:"maxConnectionAge"`
+	MaxRecvMsgSize    int           `yaml:"maxRecvMsgSize"`
+}
+
+func (c *RemoteConfiguration) InitFromViper(v *viper.Viper) error {
+	c.HostPort = v.GetString("GRPC_HOST_PORT")
+	if c.HostPort == "" {
+		c.HostPort = DefaultGRPCHostPort
+	}
+
+	c.TLSEnabled = v.GetBool("TLS_ENABLED")
+	c.TLSCert = v.GetString("TLS_CERT")
+	c.TLSKey = v.GetString("TLS_KEY")
+	c.TLSClientCA = v.GetString("TLS_CLIENT_CA")
+	if c.TLSEnabled && (c.TLSCert == "" || c.TLSKey == "") {
+		logger.Error("The TLS_CERT and TLS_KEY can't be empty when TLS is enabled")
+		return errors.New("The TLS_CERT and TLS_KEY can't be empty when TLS is enabled")
+	}
+
+	c.KeepAliveTime = durationOrDefault(v, "KEEP_ALIVE_TIME", DefaultKeepAliveTime)
+	c.KeepAliveTimeout = durationOrDefault(v, "KEEP_ALIVE_TIMEOUT", DefaultKeepAliveTimeout)
+	c.KeepAliveMinTime = durationOrDefault(v, "KEEP_ALIVE_MIN_TIME", DefaultKeepAliveMinTime)
+	c.MaxConnectionIdle = v.GetDuration("MAX_CONNECTION_IDLE")
+	c.MaxConnectionAge = v.GetDuration("MAX_CONNECTION_AGE")
+
+	c.MaxRecvMsgSize = v.GetInt("MAX_RECV_MSG_SIZE")
+	if c.MaxRecvMsgSize <= 0 {
+		c.MaxRecvMsgSize = DefaultMaxRecvMsgSize
+	}
+
+	return nil
+}
+
+// serveRemote serves the storage plugin over a normal TCP gRPC listener, so that several jaeger components
+// can share one storage deployment.
+func serveRemote(plugin shared.StoragePlugin, c *RemoteConfiguration, logger hclog.Logger) error {
+	opts, err := c.serverOptions()
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer(opts...)
+	storagePlugin := &shared.StorageGRPCPlugin{Impl: plugin}
+	if archive, ok := plugin.(shared.ArchiveStoragePlugin); ok {
+		storagePlugin.ArchiveImpl = archive
+	}
+	if err := storagePlugin.GRPCServer(nil, server); err != nil {
+		return err
+	}
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+
+	listener, err := net.Listen("tcp", c.HostPort)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Info("Shutting down remote storage server")
+		healthServer.Shutdown()
+		server.GracefulStop()
+	}()
+
+	logger.Info("Starting remote storage server", "HostPort", c.HostPort, "TLS", c.TLSEnabled)
+	return server.Serve(listener)
+}
+
+func (c *RemoteConfiguration) serverOptions() ([]grpc.ServerOption, error) {
+	opts := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(c.MaxRecvMsgSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:              c.KeepAliveTime,
+			Timeout:           c.KeepAliveTimeout,
+			MaxConnectionIdle: c.MaxConnectionIdle,
+			MaxConnectionAge:  c.MaxConnectionAge,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             c.KeepAliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	}
+
+	if !c.TLSEnabled {
+		return opts, nil
+	}
+
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, grpc.Creds(credentials.NewTLS(tlsConfig))), nil
+}
+
+func (c *RemoteConfiguration) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.TLSClientCA == "" {
+		return tlsConfig, nil
+	}
+
+	ca, err := ioutil.ReadFile(c.TLSClientCA)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("failed to parse client CA certificate")
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
@@ -0,0 +1,142 @@
+package sls_store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// access modes of the plugin
+const (
+	// ReadWriteMode serves both the reader and the writer side
+	ReadWriteMode = "read-write"
+	// ReadOnlyMode serves the reader side only, for jaeger query with read only keys
+	ReadOnlyMode = "read-only"
+	// WriteOnlyMode serves the writer side only, for jaeger collector with write only keys
+	WriteOnlyMode = "write-only"
+)
+
+func (s SlsJaegerStoragePlugin) canRead() bool {
+	return s.accessMode != WriteOnlyMode
+}
+
+func (s SlsJaegerStoragePlugin) canWrite() bool {
+	return s.accessMode != ReadOnlyMode
+}
+
+func disabledError(side string, mode string) error {
+	return status.Errorf(codes.Unimplemented, "the %s is disabled in %s mode", side, mode)
+}
+
+// disabledSpanReader the span reader of write only mode.
+type disabledSpanReader struct {
+	mode string
+}
+
+func (r disabledSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	return nil, disabledError("span reader", r.mode)
+}
+
+func (r disabledSpanReader) GetServices(ctx context.Context) ([]string, error) {
+	return nil, disabledError("span reader", r.mode)
+}
+
+func (r disabledSpanReader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
+	return nil, disabledError("span reader", r.mode)
+}
+
+func (r disabledSpanReader) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
+	return nil, disabledError("span reader", r.mode)
+}
+
+func (r disabledSpanReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	return nil, disabledError("span reader", r.mode)
+}
+
+// disabledDependencyReader the dependency reader of write only mode.
+type disabledDependencyReader struct {
+	mode string
+}
+
+func (r disabledDependencyReader) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
+	return nil, disabledError("dependency reader", r.mode)
+}
+
+// disabledSpanWriter the span writer of read only mode.
+type disabledSpanWriter struct {
+	mode string
+}
+
+func (w disabledSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	return disabledError("span writer", w.mode)
+}
+
+// SelfCheck verifies the permissions the access mode needs, for the configured target or for every tenant, and for
+// the federated targets and the old target of the migration. The reader side reads one log of the trace and
+// dependency logstores. The writer side is only checked with writeProbe, which posts an empty log group to the trace
+// logstore.
+func (s SlsJaegerStoragePlugin) SelfCheck(writeProbe bool) error {
+	if s.tenancy != nil {
+		for tenant, config := range s.tenancy.tenants {
+			plugin := s.tenancy.base.retarget(FederationTarget{
+				Endpoint:         config.Endpoint,
+				AccessKeyID:      config.AccessKeyID,
+				AccessKeySecret:  config.AccessKeySecret,
+				Project:          config.Project,
+				Instance:         config.Instance,
+				LogTimestampUnit: config.LogTimestampUnit,
+			})
+			if err := plugin.checkTarget(s.canRead(), s.canWrite() && writeProbe); err != nil {
+				return fmt.Errorf("tenant %s: %w", tenant, err)
+			}
+		}
+		return nil
+	}
+
+	if err := s.checkTarget(s.canRead(), s.canWrite() && writeProbe); err != nil {
+		return err
+	}
+	// the federated targets are only read
+	for i, target := range s.federationTargets {
+		if !s.canRead() {
+			break
+		}
+		if err := s.retarget(target).checkTarget(true, false); err != nil {
+			return fmt.Errorf("federated target %d: %w", i, err)
+		}
+	}
+	// the old target of the migration is read for the traces not found in the new one, and written by the mirror
+	if s.migrationTarget != nil {
+		if err := s.retarget(*s.migrationTarget).checkTarget(s.canRead(), s.canWrite() && writeProbe); err != nil {
+			return fmt.Errorf("migration target: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s SlsJaegerStoragePlugin) checkTarget(read, write bool) error {
+	if read {
+		client := buildSLSSdkClient(s)
+		now := time.Now().Unix()
+		for _, logstore := range []string{s.instance.traceLogStore(), s.instance.serviceDependencyLogStore()} {
+			if _, err := client.GetLogs(s.instance.project(), logstore, DefaultTopicName, now-60, now, "*", 1,
+				DefaultOffset, false); err != nil {
+				return fmt.Errorf("failed to read %s/%s: %w", s.instance.project(), logstore, err)
+			}
+		}
+	}
+
+	if write {
+		if err := newLogGroupSender(s).probe(s.instance.traceLogStore()); err != nil {
+			return fmt.Errorf("failed to write %s/%s: %w", s.instance.project(), s.instance.traceLogStore(), err)
+		}
+	}
+
+	s.logger.Info("Self check passed", "Mode", s.accessMode, "Project", s.instance.project(), "Logstore", s.instance.traceLogStore())
+	return nil
+}
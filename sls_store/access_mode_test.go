@@ -0,0 +1,90 @@
+package sls_store
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestSelfCheckTargets(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		writeProbe bool
+		wantRead   string
+		wantProbed string
+	}{
+		{"read-write", ReadWriteMode, true,
+			"[east-traces east-traces-deps instance-traces instance-traces-deps old-traces old-traces-deps]",
+			"[instance-traces old-traces]"},
+		{"without write probe", ReadWriteMode, false,
+			"[east-traces east-traces-deps instance-traces instance-traces-deps old-traces old-traces-deps]", "[]"},
+		{"read-only", ReadOnlyMode, true,
+			"[east-traces east-traces-deps instance-traces instance-traces-deps old-traces old-traces-deps]", "[]"},
+		{"write-only", WriteOnlyMode, true, "[]", "[instance-traces old-traces]"},
+	}
+
+	for _, test := range tests {
+		fake := newFakeSLS(t)
+		var lock sync.Mutex
+		read := []string{}
+		fake.getLogs = func(logstore string, query url.Values) ([]map[string]string, string, error) {
+			lock.Lock()
+			read = append(read, logstore)
+			lock.Unlock()
+			return nil, "", nil
+		}
+		plugin := fake.plugin(WithAccessMode(test.mode),
+			WithFederation([]FederationTarget{{Project: "project", Instance: "east"}}),
+			WithMigration(&MigrationTarget{Project: "project", Instance: "old"}))
+
+		if err := plugin.SelfCheck(test.writeProbe); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		plugin.Close()
+
+		sort.Strings(read)
+		if fmt.Sprint(read) != test.wantRead {
+			t.Errorf("%s: read %v, want %s", test.name, read, test.wantRead)
+		}
+		probed := []string{}
+		for logstore, groups := range fake.written {
+			for _, lg := range groups {
+				if len(lg.Logs) != 0 {
+					t.Errorf("%s: the probe wrote %d logs to %s", test.name, len(lg.Logs), logstore)
+				}
+			}
+			probed = append(probed, logstore)
+		}
+		sort.Strings(probed)
+		if fmt.Sprint(probed) != test.wantProbed {
+			t.Errorf("%s: probed %v, want %s", test.name, probed, test.wantProbed)
+		}
+	}
+}
+
+func TestSelfCheckWriteProbeErrors(t *testing.T) {
+	tests := []struct {
+		status  int
+		wantErr bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, true},
+		{http.StatusForbidden, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	for _, test := range tests {
+		fake := newFakeSLS(t)
+		fake.putStatus = test.status
+		plugin := fake.plugin(WithAccessMode(WriteOnlyMode))
+		err := plugin.SelfCheck(true)
+		plugin.Close()
+		if (err != nil) != test.wantErr {
+			t.Errorf("status %d: error %v, want error %v", test.status, err, test.wantErr)
+		}
+	}
+}
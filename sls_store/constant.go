@@ -60,6 +60,29 @@ const (
 	GetServiceQueryString = "* | select DISTINCT service"
 )
 
+// reserved tags of FindTraces
+const (
+	// OrderTagKey the reserved tag key which asks the order of FindTraces results
+	OrderTagKey = "__order"
+	// TraceOrderRecency the newest traces come first
+	TraceOrderRecency = "recency"
+	// TraceOrderDuration the longest traces come first
+	TraceOrderDuration = "duration"
+	// DurationModeTagKey the reserved tag key which asks which duration the duration filter compares
+	DurationModeTagKey = "__duration"
+	// DurationModeSpan the duration filter matches traces having any span in the range
+	DurationModeSpan = "span"
+	// DurationModeRoot the duration filter compares the root span duration
+	DurationModeRoot = "root"
+	// DurationModeTrace the duration filter compares the end to end duration, max(end) - min(start)
+	DurationModeTrace = "trace"
+	// RootOnlyTagKey the reserved tag key which applies the other filters to the root span only
+	RootOnlyTagKey = "__root"
+	// LinkedTracesTagKey the reserved tag key which asks for the traces the found traces link to, at most the value
+	// per found trace
+	LinkedTracesTagKey = "__links"
+)
+
 // query operation values
 const (
 	// DefaultFetchNumber the max fetching number of span
@@ -72,4 +95,76 @@ const (
 	DefaultRetryTimeOut = 2 * time.Minute
 	// DefaultRequestTimeOut the default value of request timeout
 	DefaultRequestTimeOut = 2 * time.Minute
+	// DefaultCacheTTL the default expiration of cached services and operations
+	DefaultCacheTTL = 5 * time.Minute
+	// DefaultCacheRefreshInterval the default interval of refreshing cached services and operations
+	DefaultCacheRefreshInterval = time.Minute
+	// DefaultCacheMaxOperations the default max number of cached operation lists
+	DefaultCacheMaxOperations = 1000
+	// DefaultCacheMaxTraces the default max number of cached traces
+	DefaultCacheMaxTraces = 500
+	// DefaultCacheTraceTTL the default expiration of cached traces
+	DefaultCacheTraceTTL = 30 * time.Minute
+	// DefaultCacheMinTraceAge the default age a trace must reach before it can be cached
+	DefaultCacheMinTraceAge = 5 * time.Minute
+	// DefaultSamplingBufferWindow the default time spans of a trace are buffered before the sampling decision
+	DefaultSamplingBufferWindow = 10 * time.Second
+	// DefaultSamplingMaxBufferedTraces the default max number of traces buffered by the sampler
+	DefaultSamplingMaxBufferedTraces = 10000
+	// DefaultSamplingDecisionTTL the default time the decision of a trace is applied to its late spans
+	DefaultSamplingDecisionTTL = 5 * time.Minute
+	// DefaultMaxFieldSize the default max bytes of one tag or log field value
+	DefaultMaxFieldSize = 64 * 1024
+	// DefaultMaxSpanSize the default max bytes of one span
+	DefaultMaxSpanSize = 1024 * 1024
+	// DefaultSpoolMaxBytes the default max total size of the spool
+	DefaultSpoolMaxBytes = 1024 * 1024 * 1024
+	// DefaultSpoolMaxAge the default max age of spooled logs
+	DefaultSpoolMaxAge = 24 * time.Hour
+	// DefaultSpoolSegmentSize the default size of one spool segment
+	DefaultSpoolSegmentSize = 16 * 1024 * 1024
+	// SpoolMinBackoff the first wait after a failed replay
+	SpoolMinBackoff = time.Second
+	// SpoolMaxBackoff the max wait between failed replays
+	SpoolMaxBackoff = time.Minute
+	// SpoolReplayInterval the interval of checking the spool for logs to replay
+	SpoolReplayInterval = time.Second
+	// ShardRefreshInterval the interval of listing the shards of the trace logstore for hash routing
+	ShardRefreshInterval = time.Minute
+	// DefaultSlowQueryThreshold the default elapsed time from which a SLS query is logged as slow
+	DefaultSlowQueryThreshold = 3 * time.Second
+	// DefaultIncompleteRetryDeadline the default time an incomplete SLS query is retried
+	DefaultIncompleteRetryDeadline = 10 * time.Second
+	// IncompleteMinBackoff the first wait before retrying an incomplete SLS query
+	IncompleteMinBackoff = 200 * time.Millisecond
+	// IncompleteMaxBackoff the max wait between retries of an incomplete SLS query
+	IncompleteMaxBackoff = 2 * time.Second
+	// DefaultThrottleRetries the default max retries of a request SLS throttled
+	DefaultThrottleRetries = 5
+	// ThrottleMinBackoff the max wait before the first retry of a throttled request
+	ThrottleMinBackoff = 100 * time.Millisecond
+	// ThrottleMaxBackoff the max wait before any retry of a throttled request
+	ThrottleMaxBackoff = 10 * time.Second
+	// SelfTracingQueueSize the max number of self tracing spans waiting for export
+	SelfTracingQueueSize = 10000
+	// SelfTracingBatchSize the max number of self tracing spans exported by one request
+	SelfTracingBatchSize = 500
+	// DefaultSelfTracingWriteSampleRate the default fraction of writer calls traced
+	DefaultSelfTracingWriteSampleRate = 0.01
+	// SelfTracingFlushInterval the max wait before the self tracing spans are exported
+	SelfTracingFlushInterval = 5 * time.Second
+	// BackfillPageSize the number of spans copied by one request of backfill
+	BackfillPageSize = 100
+	// MirrorQueueSize the max log groups waiting to be written to the old target of a migration
+	MirrorQueueSize = 1000
+	// DefaultOperationsPageSize the number of operations fetched by one query
+	DefaultOperationsPageSize = 1000
+	// MaxOperations the max number of operations returned for one service
+	MaxOperations = 100000
+	// DefaultNumTraces the number of traces returned when the query has no limit
+	DefaultNumTraces = 20
+	// MaxTraceIDsPages the max number of pages fetched to fill the asked number of trace ids
+	MaxTraceIDsPages = 10
+	// MaxLogGroupSize the max number of logs sent in one log group
+	MaxLogGroupSize = 1024
 )
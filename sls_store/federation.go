@@ -0,0 +1,325 @@
+package sls_store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/dependencystore"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// PrimaryTargetName the name of the project and instance the plugin is configured with
+const PrimaryTargetName = "primary"
+
+// FederationTarget one more project and instance read together with the primary one. Empty endpoint and
+// credentials fall back to the primary ones.
+type FederationTarget struct {
+	Name            string `mapstructure:"name"`
+	Endpoint        string `mapstructure:"endpoint"`
+	AccessKeyID     string `mapstructure:"accessKeyId"`
+	AccessKeySecret string `mapstructure:"accessKeySecret"`
+	Project         string `mapstructure:"project"`
+	Instance        string `mapstructure:"instance"`
+	// LogTimestampUnit the unit of span log timestamps in the project of the target, empty uses the one of the plugin
+	LogTimestampUnit string `mapstructure:"logTimestampUnit"`
+}
+
+type federatedTarget struct {
+	name             string
+	reader           spanstore.Reader
+	dependencyReader dependencystore.Reader
+}
+
+// federation reads the primary target and the federated targets in parallel and merges their results. A failed
+// target is skipped with a warning, the call only fails when every target fails.
+type federation struct {
+	targets []federatedTarget
+	base    SlsJaegerStoragePlugin
+}
+
+func newFederation(targets []FederationTarget, base SlsJaegerStoragePlugin) *federation {
+	f := &federation{base: base}
+	f.add(PrimaryTargetName, base)
+	for i, target := range targets {
+		name := target.Name
+		if name == "" {
+			name = fmt.Sprintf("target_%d", i)
+		}
+
+		f.add(name, base.retarget(target))
+	}
+	return f
+}
+
+func (f *federation) add(name string, plugin SlsJaegerStoragePlugin) {
+	plugin.federation = nil
+	plugin.migrationTarget = nil
+	plugin.logger = plugin.logger.With("Target", name)
+	f.targets = append(f.targets, federatedTarget{
+		name:             name,
+		reader:           plugin.buildSpanReader(),
+		dependencyReader: plugin.DependencyReader(),
+	})
+}
+
+// fanOut calls every target in parallel, returning the warnings of the failed targets and an error when all failed.
+// Every failed target is logged and counted, also for the calls whose results can not carry the warnings.
+func (f *federation) fanOut(name string, call func(i int, target federatedTarget) error) ([]string, error) {
+	errs := make([]error, len(f.targets))
+	var wg sync.WaitGroup
+	for i, target := range f.targets {
+		wg.Add(1)
+		go func(i int, target federatedTarget) {
+			defer wg.Done()
+			errs[i] = call(i, target)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var warnings []string
+	var lastErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		lastErr = err
+		incCounter("federation_errors_"+f.targets[i].name, 1)
+		f.base.logger.Warn("Failed to read federated target", "Call", name, "Target", f.targets[i].name, "Exception", err)
+		warnings = append(warnings, fmt.Sprintf("%s failed in %s: %v", name, f.targets[i].name, err))
+	}
+
+	if len(warnings) == len(f.targets) {
+		return warnings, lastErr
+	}
+	return warnings, nil
+}
+
+// federatedSpanReader the span reader over every target.
+type federatedSpanReader struct {
+	federation *federation
+}
+
+// GetTrace merges the spans of the trace found in every target, the warnings of failed targets are added to the
+// first span.
+func (r federatedSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	return r.getTrace(ctx, traceID, 0, 0)
+}
+
+// getTrace merges the trace over every target, read within the time range from and to in seconds when it is set,
+// and within the max look back of the targets otherwise.
+func (r federatedSpanReader) getTrace(ctx context.Context, traceID model.TraceID, from, to int64) (*model.Trace, error) {
+	traces := make([]*model.Trace, len(r.federation.targets))
+	warnings, err := r.federation.fanOut("GetTrace", func(i int, target federatedTarget) error {
+		var trace *model.Trace
+		var err error
+		if ranged, ok := target.reader.(rangedTraceReader); ok && to > 0 {
+			trace, err = ranged.getTraceInRange(ctx, traceID, from, to)
+		} else {
+			trace, err = target.reader.GetTrace(ctx, traceID)
+		}
+		traces[i] = trace
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	merged := &model.Trace{}
+	seenSpans := make(map[model.SpanID]bool)
+	seenProcesses := make(map[string]bool)
+	for _, trace := range traces {
+		if trace == nil {
+			continue
+		}
+
+		for _, span := range trace.Spans {
+			if seenSpans[span.SpanID] {
+				continue
+			}
+			seenSpans[span.SpanID] = true
+			merged.Spans = append(merged.Spans, span)
+		}
+		for _, mapping := range trace.ProcessMap {
+			if seenProcesses[mapping.ProcessID] {
+				continue
+			}
+			seenProcesses[mapping.ProcessID] = true
+			merged.ProcessMap = append(merged.ProcessMap, mapping)
+		}
+		// the spans of the target already carry its warnings
+		merged.Warnings = append(merged.Warnings, trace.Warnings...)
+	}
+
+	addTraceWarnings(merged, warnings...)
+	return merged, nil
+}
+
+// GetServices merges the services of every target, the failed targets are only logged and counted by fanOut.
+func (r federatedSpanReader) GetServices(ctx context.Context) ([]string, error) {
+	results := make([][]string, len(r.federation.targets))
+	_, err := r.federation.fanOut("GetServices", func(i int, target federatedTarget) error {
+		services, err := target.reader.GetServices(ctx)
+		results[i] = services
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	services := make([]string, 0)
+	for _, result := range results {
+		for _, service := range result {
+			if !seen[service] {
+				seen[service] = true
+				services = append(services, service)
+			}
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// GetOperations merges the operations of every target, the failed targets are only logged and counted by fanOut.
+func (r federatedSpanReader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
+	results := make([][]spanstore.Operation, len(r.federation.targets))
+	_, err := r.federation.fanOut("GetOperations", func(i int, target federatedTarget) error {
+		operations, err := target.reader.GetOperations(ctx, query)
+		results[i] = operations
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[spanstore.Operation]bool)
+	operations := make([]spanstore.Operation, 0)
+	for _, result := range results {
+		for _, operation := range result {
+			if !seen[operation] {
+				seen[operation] = true
+				operations = append(operations, operation)
+			}
+		}
+	}
+	return operations, nil
+}
+
+// FindTraces finds the trace ids in every target, and then merges every trace over all targets within the time
+// range of the query, so that the spans of a trace crossing regions are all returned. The warnings of the targets
+// failed to search are added to every trace, as their traces may be missing.
+func (r federatedSpanReader) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
+	traceIDs, warnings, err := r.findTraceIDs(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*model.Trace
+	for _, traceID := range traceIDs {
+		trace, err := r.getTrace(ctx, traceID, query.StartTimeMin.Unix(), query.StartTimeMax.Unix())
+		if err != nil {
+			logger.Warn("Failed to get trace data.", "TID", traceID, "Exception", err)
+			continue
+		}
+		addTraceWarnings(trace, warnings...)
+		result = append(result, trace)
+	}
+	return result, nil
+}
+
+// FindTraceIDs merges the trace ids of every target, the failed targets are only logged and counted by fanOut.
+func (r federatedSpanReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	traceIDs, _, err := r.findTraceIDs(ctx, query)
+	return traceIDs, err
+}
+
+// findTraceIDs merges the trace ids of every target by their start time or duration, the order the targets use,
+// so that the result is the same as if the targets were one. The warnings of the failed targets are returned.
+func (r federatedSpanReader) findTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, []string, error) {
+	results := make([][]rankedTraceID, len(r.federation.targets))
+	warnings, err := r.federation.fanOut("FindTraceIDs", func(i int, target federatedTarget) error {
+		if finder, ok := target.reader.(rankedTraceIDFinder); ok {
+			traceIDs, err := finder.findRankedTraceIDs(ctx, query)
+			results[i] = traceIDs
+			return err
+		}
+
+		traceIDs, err := target.reader.FindTraceIDs(ctx, query)
+		for _, id := range traceIDs {
+			results[i] = append(results[i], rankedTraceID{id: id})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	numTraces := query.NumTraces
+	if numTraces <= 0 {
+		numTraces = DefaultNumTraces
+	}
+
+	index := make(map[model.TraceID]int)
+	var merged []rankedTraceID
+	for _, result := range results {
+		for _, ranked := range result {
+			if i, ok := index[ranked.id]; ok {
+				if ranked.rank > merged[i].rank {
+					merged[i].rank = ranked.rank
+				}
+				continue
+			}
+			index[ranked.id] = len(merged)
+			merged = append(merged, ranked)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].rank > merged[j].rank
+	})
+
+	if len(merged) > numTraces {
+		merged = merged[:numTraces]
+	}
+	return traceIDsOf(merged), warnings, nil
+}
+
+// federatedDependencyReader sums the call counts of the same link over every target.
+type federatedDependencyReader struct {
+	federation *federation
+}
+
+// GetDependencies merges the links of every target, the failed targets are only logged and counted by fanOut.
+func (r federatedDependencyReader) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
+	results := make([][]model.DependencyLink, len(r.federation.targets))
+	_, err := r.federation.fanOut("GetDependencies", func(i int, target federatedTarget) error {
+		links, err := target.dependencyReader.GetDependencies(ctx, endTs, lookback)
+		results[i] = links
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	type edge struct{ parent, child string }
+	var order []edge
+	counts := make(map[edge]uint64)
+	for _, result := range results {
+		for _, link := range result {
+			key := edge{parent: link.Parent, child: link.Child}
+			if _, ok := counts[key]; !ok {
+				order = append(order, key)
+			}
+			counts[key] += link.CallCount
+		}
+	}
+
+	links := make([]model.DependencyLink, len(order))
+	for i, key := range order {
+		links[i] = model.DependencyLink{Parent: key.parent, Child: key.child, CallCount: counts[key]}
+	}
+	return links, nil
+}
@@ -0,0 +1,229 @@
+package sls_store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// stubTargetReader the span and dependency reader of one federated target, failing every call with err when set.
+type stubTargetReader struct {
+	spanstore.Reader
+	err      error
+	ranked   []rankedTraceID
+	spans    []*model.Span
+	services []string
+	links    []model.DependencyLink
+
+	lock sync.Mutex
+	// ranges the time ranges the traces were read in, 0 for the max look back
+	ranges [][2]int64
+}
+
+func (r *stubTargetReader) GetServices(ctx context.Context) ([]string, error) {
+	return r.services, r.err
+}
+
+func (r *stubTargetReader) findRankedTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]rankedTraceID, error) {
+	return r.ranked, r.err
+}
+
+func (r *stubTargetReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	return traceIDsOf(r.ranked), r.err
+}
+
+func (r *stubTargetReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	return r.getTraceInRange(ctx, traceID, 0, 0)
+}
+
+func (r *stubTargetReader) getTraceInRange(ctx context.Context, traceID model.TraceID, from, to int64) (*model.Trace, error) {
+	r.lock.Lock()
+	r.ranges = append(r.ranges, [2]int64{from, to})
+	r.lock.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	trace := &model.Trace{}
+	for _, span := range r.spans {
+		if span.TraceID == traceID {
+			trace.Spans = append(trace.Spans, span)
+		}
+	}
+	return trace, nil
+}
+
+func (r *stubTargetReader) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
+	return r.links, r.err
+}
+
+func newTestFederation(readers ...*stubTargetReader) *federation {
+	f := &federation{base: SlsJaegerStoragePlugin{logger: logger}}
+	for i, reader := range readers {
+		f.targets = append(f.targets, federatedTarget{name: fmt.Sprintf("target_%d", i), reader: reader, dependencyReader: reader})
+	}
+	return f
+}
+
+func federationTestSpan(trace, span uint64) *model.Span {
+	return &model.Span{TraceID: model.NewTraceID(0, trace), SpanID: model.NewSpanID(span)}
+}
+
+func TestFederationFanOut(t *testing.T) {
+	failed := errors.New("unavailable")
+	tests := []struct {
+		name         string
+		errs         []error
+		wantWarnings int
+		wantErr      bool
+	}{
+		{"all succeed", []error{nil, nil}, 0, false},
+		{"one fails", []error{nil, failed}, 1, false},
+		{"all fail", []error{failed, failed}, 2, true},
+	}
+
+	for _, test := range tests {
+		var readers []*stubTargetReader
+		for range test.errs {
+			readers = append(readers, &stubTargetReader{})
+		}
+		f := newTestFederation(readers...)
+		warnings, err := f.fanOut("Call", func(i int, target federatedTarget) error {
+			return test.errs[i]
+		})
+		if len(warnings) != test.wantWarnings || (err != nil) != test.wantErr {
+			t.Errorf("%s: %d warnings and error %v", test.name, len(warnings), err)
+		}
+		for _, warning := range warnings {
+			if !strings.HasPrefix(warning, "Call failed in target_") {
+				t.Errorf("%s: unexpected warning %s", test.name, warning)
+			}
+		}
+	}
+}
+
+func TestFederatedFindTraceIDsMergesByRank(t *testing.T) {
+	reader := federatedSpanReader{federation: newTestFederation(
+		&stubTargetReader{ranked: []rankedTraceID{{model.NewTraceID(0, 1), 50}, {model.NewTraceID(0, 2), 30}}},
+		&stubTargetReader{ranked: []rankedTraceID{{model.NewTraceID(0, 3), 40}, {model.NewTraceID(0, 1), 60}}},
+		&stubTargetReader{err: errors.New("unavailable")},
+	)}
+
+	tests := []struct {
+		numTraces int
+		want      string
+	}{
+		{0, "[0000000000000001 0000000000000003 0000000000000002]"},
+		{2, "[0000000000000001 0000000000000003]"},
+	}
+	for _, test := range tests {
+		traceIDs, err := reader.FindTraceIDs(context.Background(), &spanstore.TraceQueryParameters{NumTraces: test.numTraces})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(traceIDs); got != test.want {
+			t.Errorf("FindTraceIDs(%d) = %s, want %s", test.numTraces, got, test.want)
+		}
+	}
+}
+
+func TestFederatedFindTraces(t *testing.T) {
+	primary := &stubTargetReader{
+		ranked: []rankedTraceID{{model.NewTraceID(0, 1), 2}},
+		spans:  []*model.Span{federationTestSpan(1, 1)},
+	}
+	// the other region has more spans of the trace, its search fails while reading its spans succeeds
+	other := &stubTargetReader{spans: []*model.Span{federationTestSpan(1, 2), federationTestSpan(1, 1)}}
+	reader := federatedSpanReader{federation: newTestFederation(primary, other)}
+	reader.federation.targets[1].reader = &failingSearchReader{stubTargetReader: other}
+
+	query := &spanstore.TraceQueryParameters{
+		StartTimeMin: time.Unix(1000, 0),
+		StartTimeMax: time.Unix(2000, 0),
+	}
+	traces, err := reader.FindTraces(context.Background(), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 || len(traces[0].Spans) != 2 {
+		t.Fatalf("found %d traces, want the trace with the spans of both targets", len(traces))
+	}
+	if warnings := traces[0].Spans[0].Warnings; len(warnings) != 1 || !strings.HasPrefix(warnings[0], "FindTraceIDs failed in target_1") {
+		t.Errorf("the search warning should be on the first span, got %v", warnings)
+	}
+	for _, target := range []*stubTargetReader{primary, other} {
+		if len(target.ranges) != 1 {
+			t.Errorf("the trace was read %d times from a target, want 1", len(target.ranges))
+		}
+		for _, r := range target.ranges {
+			if r != [2]int64{1000, 2000} {
+				t.Errorf("the trace was read in %v, want the time range of the query", r)
+			}
+		}
+	}
+}
+
+// failingSearchReader a target whose searches fail while its traces can be read.
+type failingSearchReader struct {
+	*stubTargetReader
+}
+
+func (r *failingSearchReader) findRankedTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]rankedTraceID, error) {
+	return nil, errors.New("search timed out")
+}
+
+func TestFederatedGetTraceMergesSpans(t *testing.T) {
+	reader := federatedSpanReader{federation: newTestFederation(
+		&stubTargetReader{spans: []*model.Span{federationTestSpan(1, 1), federationTestSpan(1, 2)}},
+		&stubTargetReader{spans: []*model.Span{federationTestSpan(1, 2), federationTestSpan(1, 3)}},
+		&stubTargetReader{err: errors.New("unavailable")},
+	)}
+
+	trace, err := reader.GetTrace(context.Background(), model.NewTraceID(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 3 {
+		t.Errorf("merged %d spans, want 3 distinct spans", len(trace.Spans))
+	}
+	if len(trace.Warnings) != 1 || !strings.HasPrefix(trace.Spans[0].Warnings[0], "GetTrace failed in target_2") {
+		t.Errorf("the warning of the failed target should be on the trace and its first span, got %v", trace.Warnings)
+	}
+}
+
+func TestFederatedGetServicesAndDependencies(t *testing.T) {
+	f := newTestFederation(
+		&stubTargetReader{services: []string{"b", "a"}, links: []model.DependencyLink{{Parent: "a", Child: "b", CallCount: 1}}},
+		&stubTargetReader{services: []string{"c", "a"}, links: []model.DependencyLink{{Parent: "a", Child: "b", CallCount: 2},
+			{Parent: "b", Child: "c", CallCount: 1}}},
+		&stubTargetReader{err: errors.New("unavailable")},
+	)
+
+	services, err := federatedSpanReader{federation: f}.GetServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(services) != "[a b c]" {
+		t.Errorf("services = %v, want the sorted services of the targets", services)
+	}
+
+	links, err := federatedDependencyReader{federation: f}.GetDependencies(context.Background(), time.Now(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].CallCount != 3 || links[1].CallCount != 1 {
+		t.Errorf("links = %v, want the call counts summed per link", links)
+	}
+
+	all := newTestFederation(&stubTargetReader{err: errors.New("unavailable")})
+	if _, err := (federatedSpanReader{federation: all}).GetServices(context.Background()); err == nil {
+		t.Error("the call should fail when every target fails")
+	}
+}
@@ -0,0 +1,97 @@
+package sls_store
+
+import (
+	"context"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/hashicorp/go-hclog"
+)
+
+// queryOptions the query log and the handling of incomplete results of the SLS queries made by getLogs, and the
+// converter of the spans they return, it is carried by the context of the reader call.
+type queryOptions struct {
+	slowQuery  SlowQueryConfig
+	incomplete IncompleteResultConfig
+	limiter    *rateLimiter
+	logger     hclog.Logger
+	converter  *dataConverterImpl
+}
+
+type queryOptionsKey struct{}
+
+// attach returns the context carrying the options, getLogs falls back to the default ones without them.
+func (o *queryOptions) attach(ctx context.Context) context.Context {
+	if o == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, queryOptionsKey{}, o)
+}
+
+func queryOptionsFromContext(ctx context.Context) *queryOptions {
+	if o, ok := ctx.Value(queryOptionsKey{}).(*queryOptions); ok {
+		return o
+	}
+	return &queryOptions{slowQuery: DefaultSlowQueryConfig, incomplete: DefaultIncompleteResultConfig, logger: logger,
+		converter: dataConvert}
+}
+
+// getLogs calls GetLogs of SLS in a span carrying the query, the row count and the progress of the request, and
+// records the call in the query log of the context. Requests wait for the read rate limit of the logstore, and
+// are retried with backoff when SLS throttles them. Incomplete results are retried with backoff until the retry
+// deadline, a result still incomplete is recorded in the completeness of the context.
+func getLogs(ctx context.Context, client slsSdk.ClientInterface, project, logstore, topic string, from, to int64,
+	query string, lines, offset int64) (*slsSdk.GetLogsResponse, error) {
+	_, span := startSelfSpan(ctx, "sls.GetLogs")
+	defer span.finish()
+	span.setTag("sls.logstore", logstore)
+	span.setTag("sls.topic", topic)
+	span.setTag("sls.query", query)
+	span.setTag("sls.from", time.Unix(from, 0))
+	span.setTag("sls.to", time.Unix(to, 0))
+
+	options := queryOptionsFromContext(ctx)
+	start := time.Now()
+	deadline := start.Add(options.incomplete.RetryDeadline)
+	backoff := IncompleteMinBackoff
+	attempts := 0
+	for {
+		attempts++
+		var response *slsSdk.GetLogsResponse
+		err := retryThrottled(ctx, options.limiter.throttleRetries(), "reads", func() error {
+			if err := options.limiter.waitRead(ctx, project, logstore); err != nil {
+				return err
+			}
+
+			var err error
+			response, err = client.GetLogs(project, logstore, topic, from, to, query, lines, offset, false)
+			return err
+		})
+		if err != nil || response.Progress != IncompleteProgress || !time.Now().Add(backoff).Before(deadline) {
+			options.record(project, logstore, query, from, to, time.Since(start), response, err)
+			span.setTag("sls.attempts", attempts)
+			if err != nil {
+				span.setError(err)
+				return nil, err
+			}
+
+			span.setTag("sls.rows", int64(response.Count))
+			span.setTag("sls.progress", response.Progress)
+			if response.Progress == IncompleteProgress {
+				markIncomplete(ctx, logstore, query)
+			}
+			return response, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			options.record(project, logstore, query, from, to, time.Since(start), response, ctx.Err())
+			span.setError(ctx.Err())
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > IncompleteMaxBackoff {
+			backoff = IncompleteMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package sls_store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// IncompleteProgress the Progress of a SLS result which does not cover all the data of the query yet
+const IncompleteProgress = "Incomplete"
+
+// ErrIncompleteResult returned by the reader calls whose result has no warnings, when SLS still returns an
+// incomplete result after the retry deadline and IncompleteResultConfig.Fail is set
+var ErrIncompleteResult = errors.New("the result of SLS is incomplete")
+
+// IncompleteResultConfig the handling of SLS results whose Progress is not Complete
+type IncompleteResultConfig struct {
+	// RetryDeadline how long an incomplete query is retried, 0 disables retrying
+	RetryDeadline time.Duration
+	// Fail returns ErrIncompleteResult instead of the partial result of the calls whose result has no warnings
+	Fail bool
+}
+
+// DefaultIncompleteResultConfig retries incomplete queries for DefaultIncompleteRetryDeadline and returns the
+// partial result
+var DefaultIncompleteResultConfig = IncompleteResultConfig{
+	RetryDeadline: DefaultIncompleteRetryDeadline,
+}
+
+// completeness records the queries of a reader call whose result was still incomplete after retrying.
+type completeness struct {
+	lock    sync.Mutex
+	queries []string
+}
+
+type completenessKey struct{}
+
+// trackCompleteness returns the context recording the incomplete queries made with it.
+func trackCompleteness(ctx context.Context) (context.Context, *completeness) {
+	c := &completeness{}
+	return context.WithValue(ctx, completenessKey{}, c), c
+}
+
+func markIncomplete(ctx context.Context, logstore, query string) {
+	incCounter("incomplete_results", 1)
+	if c, ok := ctx.Value(completenessKey{}).(*completeness); ok {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		c.queries = append(c.queries, logstore+": "+query)
+	}
+}
+
+func (c *completeness) incomplete() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.queries) > 0
+}
+
+// warning the warning added to the first span of the traces read by incomplete queries.
+func (c *completeness) warning(what string) string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return fmt.Sprintf("SLS returned incomplete results for %d queries, %s may be missing", len(c.queries), what)
+}
+
+// check returns ErrIncompleteResult for incomplete results when the query options of the context ask to fail, and
+// logs them otherwise.
+func (c *completeness) check(ctx context.Context, call string) error {
+	if !c.incomplete() {
+		return nil
+	}
+
+	options := queryOptionsFromContext(ctx)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if options.incomplete.Fail {
+		return fmt.Errorf("%w: %d queries of %s", ErrIncompleteResult, len(c.queries), call)
+	}
+	options.logger.Warn("Returning incomplete result", "Call", call, "Queries", c.queries)
+	return nil
+}
@@ -0,0 +1,136 @@
+package sls_store
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// incompleteFakeSLS answers the searches with trace 1 and the reads of the trace with one span, returning
+// progress for the first incomplete requests and Complete after them.
+func incompleteFakeSLS(t *testing.T, incomplete int) *fakeSLS {
+	fake := newFakeSLS(t)
+	var lock sync.Mutex
+	fake.getLogs = func(logstore string, query url.Values) ([]map[string]string, string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		progress := "Complete"
+		if incomplete != 0 {
+			incomplete--
+			progress = IncompleteProgress
+		}
+		if strings.Contains(query.Get("query"), "select") {
+			return traceIDRows(1), progress, nil
+		}
+		return []map[string]string{storedSpanRow(t, 1, nil)}, progress, nil
+	}
+	return fake
+}
+
+func searchQuery() *spanstore.TraceQueryParameters {
+	return &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: time.Now().Add(-time.Hour),
+		StartTimeMax: time.Now(),
+		NumTraces:    20,
+	}
+}
+
+func hasWarning(warnings []string, what string) bool {
+	for _, warning := range warnings {
+		if strings.Contains(warning, what) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIncompleteResultsAreRetried(t *testing.T) {
+	fake := incompleteFakeSLS(t, 1)
+	plugin := fake.plugin(WithIncompleteResults(IncompleteResultConfig{RetryDeadline: time.Second}))
+	defer plugin.Close()
+
+	trace, err := plugin.SpanReader().GetTrace(context.Background(), model.NewTraceID(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 1 || len(trace.Spans[0].Warnings) != 0 || len(trace.Warnings) != 0 {
+		t.Errorf("the trace complete after a retry should have no warnings, got %v", trace.Warnings)
+	}
+	if queries := fake.queryCount(); queries != 2 {
+		t.Errorf("sent %d queries, want the incomplete one retried once", queries)
+	}
+}
+
+func TestIncompleteResultWarnings(t *testing.T) {
+	fake := incompleteFakeSLS(t, -1)
+	plugin := fake.plugin(WithIncompleteResults(IncompleteResultConfig{}))
+	defer plugin.Close()
+	reader := plugin.SpanReader()
+
+	trace, err := reader.GetTrace(context.Background(), model.NewTraceID(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Spans) != 1 || !hasWarning(trace.Spans[0].Warnings, "spans of the trace may be missing") ||
+		!hasWarning(trace.Warnings, "spans of the trace may be missing") {
+		t.Errorf("the incomplete trace should warn on the trace and its first span, got %v", trace.Warnings)
+	}
+
+	traces, err := reader.FindTraces(context.Background(), searchQuery())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 {
+		t.Fatalf("found %d traces, want 1", len(traces))
+	}
+	if !hasWarning(traces[0].Spans[0].Warnings, "traces matching the search may be missing") {
+		t.Errorf("the traces of an incomplete search should warn, got %v", traces[0].Spans[0].Warnings)
+	}
+	if queries := fake.queryCount(); queries != 3 {
+		t.Errorf("sent %d queries, want the incomplete ones not retried without a retry deadline", queries)
+	}
+}
+
+func TestIncompleteResultFail(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  IncompleteResultConfig
+		wantErr bool
+	}{
+		{"partial result", IncompleteResultConfig{}, false},
+		{"fail", IncompleteResultConfig{Fail: true}, true},
+	}
+
+	for _, test := range tests {
+		fake := incompleteFakeSLS(t, -1)
+		plugin := fake.plugin(WithIncompleteResults(test.config))
+		reader := plugin.SpanReader()
+
+		traceIDs, err := reader.FindTraceIDs(context.Background(), searchQuery())
+		if test.wantErr {
+			if !errors.Is(err, ErrIncompleteResult) {
+				t.Errorf("%s: FindTraceIDs returned %v, want ErrIncompleteResult", test.name, err)
+			}
+		} else if err != nil || len(traceIDs) != 1 {
+			t.Errorf("%s: FindTraceIDs returned %v and %v, want the partial result", test.name, traceIDs, err)
+		}
+
+		if _, err := reader.GetServices(context.Background()); errors.Is(err, ErrIncompleteResult) != test.wantErr {
+			t.Errorf("%s: GetServices returned %v", test.name, err)
+		}
+
+		// the warnings of the traces carry the incomplete results instead of failing
+		if _, err := reader.GetTrace(context.Background(), model.NewTraceID(0, 1)); err != nil {
+			t.Errorf("%s: GetTrace returned %v, want the trace with a warning", test.name, err)
+		}
+		plugin.Close()
+	}
+}
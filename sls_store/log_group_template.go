@@ -0,0 +1,112 @@
+package sls_store
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+// ServiceTopicTemplate the topic template which writes the spans of every service under its own topic
+const ServiceTopicTemplate = "{service}"
+
+var templatePlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_.:-]+)\}`)
+
+// LogGroupTemplate the templates of the topic, source and tags of the log groups written to SLS. A template may
+// contain the {service}, {hostname}, {ip} and {process.<key>} placeholders, other text is kept as is.
+type LogGroupTemplate struct {
+	Topic  string
+	Source string
+	Tags   map[string]string
+}
+
+// DefaultLogGroupTemplate the empty topic and the 0.0.0.0 source used when nothing is configured
+var DefaultLogGroupTemplate = LogGroupTemplate{Source: "0.0.0.0"}
+
+type logGroupDimensions struct {
+	topic  string
+	source string
+	tags   []*slsSdk.LogTag
+}
+
+// key identifies the log group the log belongs to.
+func (d logGroupDimensions) key() string {
+	parts := []string{d.topic, d.source}
+	for _, tag := range d.tags {
+		parts = append(parts, tag.GetKey()+"="+tag.GetValue())
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// render fills the templates with the service and the process tags of the log in the SLS layout.
+func (t LogGroupTemplate) render(log *slsSdk.Log) logGroupDimensions {
+	var values map[string]string
+	lookup := func(name string) string {
+		if values == nil {
+			values = logTemplateValues(log)
+		}
+		return values[name]
+	}
+	fill := func(template string) string {
+		if !strings.Contains(template, "{") {
+			return template
+		}
+		return templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+			return lookup(strings.Trim(placeholder, "{}"))
+		})
+	}
+
+	d := logGroupDimensions{
+		topic:  fill(t.Topic),
+		source: fill(t.Source),
+	}
+
+	keys := make([]string, 0, len(t.Tags))
+	for key := range t.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		d.tags = append(d.tags, &slsSdk.LogTag{
+			Key:   proto.String(key),
+			Value: proto.String(fill(t.Tags[key])),
+		})
+	}
+
+	return d
+}
+
+// logTemplateValues the placeholder values of the log, hostname and ip fall back to the OpenTelemetry keys.
+func logTemplateValues(log *slsSdk.Log) map[string]string {
+	values := make(map[string]string)
+	process := make(map[string]string)
+	for _, content := range log.Contents {
+		switch content.GetKey() {
+		case ServiceName:
+			values["service"] = content.GetValue()
+		case Resource:
+			if err := json.Unmarshal([]byte(content.GetValue()), &process); err != nil {
+				process = make(map[string]string)
+			}
+		}
+	}
+
+	for key, value := range process {
+		values["process."+key] = value
+	}
+	values["hostname"] = firstNonEmpty(process["hostname"], process["host.name"])
+	values["ip"] = firstNonEmpty(process["ip"], process["host.ip"])
+	return values
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,23 @@
+package sls_store
+
+import (
+	"expvar"
+)
+
+// pluginMetrics the counters of the plugin, exported by expvar under the sls_jaeger_plugin key
+var pluginMetrics = expvar.NewMap("sls_jaeger_plugin")
+
+func incCounter(name string, delta int64) {
+	pluginMetrics.Add(name, delta)
+}
+
+func setGauge(name string, value int64) {
+	if gauge, ok := pluginMetrics.Get(name).(*expvar.Int); ok {
+		gauge.Set(value)
+		return
+	}
+
+	gauge := new(expvar.Int)
+	gauge.Set(value)
+	pluginMetrics.Set(name, gauge)
+}
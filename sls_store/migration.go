@@ -0,0 +1,166 @@
+package sls_store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// MigrationTarget the old project and instance of a migration, empty endpoint and credentials fall back to the
+// configured ones
+type MigrationTarget = FederationTarget
+
+// migrationSpanReader reads the new target, and falls back to the old target for traces which are not found in
+// the new one yet.
+type migrationSpanReader struct {
+	spanstore.Reader
+	old spanstore.Reader
+}
+
+func (r migrationSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	trace, err := r.Reader.GetTrace(ctx, traceID)
+	if err == nil && trace != nil && len(trace.Spans) > 0 {
+		return trace, nil
+	}
+
+	oldTrace, oldErr := r.old.GetTrace(ctx, traceID)
+	if oldErr != nil {
+		if err != nil {
+			return nil, err
+		}
+		return trace, nil
+	}
+
+	incCounter("migration_read_fallbacks", 1)
+	return oldTrace, nil
+}
+
+// logGroupMirror writes the log groups of the new target to the old target of a migration in the background, so
+// that the old target never slows down or fails the writes. Log groups are dropped when the queue is full.
+type logGroupMirror struct {
+	lock   sync.RWMutex
+	closed bool
+	writer *slsSpanWriter
+	queue  chan *slsSdk.LogGroup
+	done   chan struct{}
+	logger hclog.Logger
+}
+
+func newLogGroupMirror(writer *slsSpanWriter, logger hclog.Logger) *logGroupMirror {
+	m := &logGroupMirror{
+		writer: writer,
+		queue:  make(chan *slsSdk.LogGroup, MirrorQueueSize),
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+
+	go m.run()
+	return m
+}
+
+func (m *logGroupMirror) add(lg *slsSdk.LogGroup) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.closed {
+		incCounter("migration_old_write_dropped", 1)
+		return
+	}
+
+	select {
+	case m.queue <- lg:
+	default:
+		incCounter("migration_old_write_dropped", 1)
+	}
+}
+
+func (m *logGroupMirror) run() {
+	defer close(m.done)
+	for lg := range m.queue {
+		// the write is detached from the call which queued it
+		if err := m.writer.sendLogGroup(context.Background(), lg); err != nil {
+			incCounter("migration_old_write_errors", 1)
+			m.logger.Warn("Failed to send log to the old target.", "exception", err)
+		}
+	}
+}
+
+// close writes the queued log groups and stops the mirror.
+func (m *logGroupMirror) close() {
+	m.lock.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.lock.Unlock()
+	<-m.done
+}
+
+// CopySpans copies the spans of the time range from the trace logstore of the source to the trace logstore of the
+// plugin, returning the number of copied spans. The spans are read with the converter of the source and written
+// through the converter of the plugin, so that redaction, size limits and the timestamp unit of the plugin apply
+// like to any other write; spans failed to convert are skipped. Writes are not spooled, a failed page fails the
+// copy so that it is retried. With multi-tenancy the spans are copied to the tenant of the context.
+func (s SlsJaegerStoragePlugin) CopySpans(ctx context.Context, source MigrationTarget, from, to int64) (int, error) {
+	if s.tenancy != nil {
+		plugin, err := s.tenancy.resolve(ctx)
+		if err != nil {
+			return 0, err
+		}
+		return plugin.CopySpans(ctx, source, from, to)
+	}
+	if !s.canWrite() {
+		return 0, disabledError("span writer", s.accessMode)
+	}
+
+	src := s.retarget(source)
+	client := buildSLSSdkClient(src)
+	sourceConverter := src.buildQueryOptions().converter
+	writer := s.buildSpanWriter()
+	writer.spool = nil
+	ctx, span := s.tracer.startSpan(s.buildQueryOptions().attach(ctx), "CopySpans")
+	defer span.finish()
+
+	copied := 0
+	for offset := int64(0); ; offset += BackfillPageSize {
+		response, err := getLogs(ctx, client, src.instance.project(), src.instance.traceLogStore(), DefaultTopicName, from, to,
+			"*", BackfillPageSize, offset)
+		if err == nil && response.Progress == IncompleteProgress {
+			// skipping the missing spans of the page would lose them silently
+			err = fmt.Errorf("%w: page at offset %d", ErrIncompleteResult, offset)
+		}
+		if err != nil {
+			span.setError(err)
+			return copied, err
+		}
+
+		spans := make([]*model.Span, 0, len(response.Logs))
+		for _, data := range response.Logs {
+			stored, err := sourceConverter.ToJaegerSpan(data)
+			if err != nil {
+				s.logger.Warn("Failed to convert stored span, skipping it", "TID", data[TraceID], "spanID", data[SpanID],
+					"Exception", err)
+				incCounter("copy_skipped_spans", 1)
+				continue
+			}
+			writer.redactor.RedactSpan(stored)
+			spans = append(spans, stored)
+		}
+
+		logs, _ := convertSpans(ctx, writer.converter, spans, s.logger)
+		if err := writer.writeLogs(ctx, logs); err != nil {
+			span.setError(err)
+			return copied, err
+		}
+		copied += len(logs)
+
+		if int64(len(response.Logs)) < BackfillPageSize {
+			span.setTag("rows", copied)
+			return copied, nil
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package sls_store
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// storedSpanRow a span as another SLS Trace producer stores it, with span log timestamps in microseconds and a
+// field jaeger spans don't have.
+func storedSpanRow(t *testing.T, spanID uint64, tags map[string]string) map[string]string {
+	logs, err := json.Marshal([]SpanLog{{Time: toTimestamp(eventTime, TimestampUnitMicroseconds),
+		Attribute: map[string]string{"event": "retry"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attribute, err := json.Marshal(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]string{
+		TraceID:       "0000000000000001",
+		SpanID:        model.NewSpanID(spanID).String(),
+		ParentSpanID:  "0",
+		OperationName: "op",
+		StartTime:     "1700000000000000",
+		Duration:      "1000",
+		ServiceName:   "frontend",
+		Attribute:     string(attribute),
+		Resource:      `{"host.name":"host"}`,
+		Logs:          string(logs),
+		"__time__":    "1700000000",
+		"custom":      "value",
+	}
+}
+
+func TestCopySpansThroughConverter(t *testing.T) {
+	redactor, err := NewRedactor([]RedactionRule{{Name: "password", Key: "password", Action: RedactionMask}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeSLS(t)
+	var read []string
+	fake.getLogs = func(logstore string, query url.Values) ([]map[string]string, string, error) {
+		read = append(read, logstore)
+		return []map[string]string{
+			storedSpanRow(t, 1, map[string]string{"password": "secret"}),
+			storedSpanRow(t, 2, map[string]string{"payload": strings.Repeat("a", 100)}),
+			{TraceID: "invalid", SpanID: "3"},
+		}, "", nil
+	}
+	plugin := fake.plugin(WithRedactor(redactor), WithSizeLimits(SizeLimits{MaxFieldSize: 50}))
+	defer plugin.Close()
+
+	source := MigrationTarget{Project: "project", Instance: "old", LogTimestampUnit: TimestampUnitMicroseconds}
+	copied, err := plugin.CopySpans(context.Background(), source, 1699999000, 1700001000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 {
+		t.Errorf("copied %d spans, want the 2 spans which can be converted", copied)
+	}
+	if len(read) != 1 || read[0] != "old-traces" {
+		t.Errorf("read %v, want one page of the source logstore", read)
+	}
+
+	logs := fake.logs("instance-traces")
+	if len(logs) != 2 {
+		t.Fatalf("wrote %d spans, want 2", len(logs))
+	}
+	for _, log := range logs {
+		span, err := dataConvert.ToJaegerSpan(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := log["custom"]; ok {
+			t.Error("the fields jaeger spans don't have should not be copied")
+		}
+		if len(span.Logs) != 1 || !span.Logs[0].Timestamp.Equal(time.Unix(1700000000, 123456000)) {
+			t.Errorf("the span log should be read in microseconds and written in nanoseconds, got %v", span.Logs)
+		}
+		for _, tag := range span.Tags {
+			switch tag.Key {
+			case "password":
+				if tag.AsString() != RedactionMaskValue {
+					t.Errorf("the password was not redacted: %s", tag.AsString())
+				}
+			case "payload":
+				if len(tag.AsString()) > 50 || !strings.Contains(log[StatusMessage], "attribute.payload") {
+					t.Errorf("the payload was not cut by the size limit: %d bytes", len(tag.AsString()))
+				}
+			}
+		}
+	}
+}
+
+func TestCopySpansToTenant(t *testing.T) {
+	fake := newFakeSLS(t)
+	fake.getLogs = func(logstore string, query url.Values) ([]map[string]string, string, error) {
+		return []map[string]string{storedSpanRow(t, 1, nil)}, "", nil
+	}
+	plugin := fake.plugin(WithTenancy(TenancyConfig{Enabled: true, Tenants: map[string]TenantConfig{
+		"a": {Project: "project", Instance: "instance-a"},
+	}}))
+	defer plugin.Close()
+
+	source := MigrationTarget{Project: "project", Instance: "old"}
+	if _, err := plugin.CopySpans(context.Background(), source, 0, 1); err == nil {
+		t.Error("the copy without a tenant should fail")
+	}
+	if _, err := plugin.CopySpans(tenantContext("a"), source, 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if logs := fake.logs("instance-a-traces"); len(logs) != 1 {
+		t.Errorf("wrote %d spans to the tenant, want 1", len(logs))
+	}
+}
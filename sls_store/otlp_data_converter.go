@@ -0,0 +1,494 @@
+package sls_store
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/spf13/cast"
+	commonV1 "go.opentelemetry.io/proto/otlp/common/v1"
+	resourceV1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	traceV1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+const (
+	// OTLPServiceNameKey the resource attribute key of service name
+	OTLPServiceNameKey = "service.name"
+	// OTLPLibraryNameKey the span attribute key of instrumentation library name
+	OTLPLibraryNameKey = "otel.library.name"
+	// OTLPLibraryVersionKey the span attribute key of instrumentation library version
+	OTLPLibraryVersionKey = "otel.library.version"
+	// OTLPEventNameKey the span log attribute key of event name
+	OTLPEventNameKey = "event"
+	// OTLPUnknownService the service name used when the resource has no service.name
+	OTLPUnknownService = "unknown_service"
+)
+
+// OTLPToSLSLogs converts OTLP resource spans straight into the SLS span layout used by ToSLSSpan, within the size
+// limits. Spans which can
+// not be converted are skipped and counted in rejected, err is the error of the first of them.
+func OTLPToSLSLogs(resourceSpans []*traceV1.ResourceSpans, limits SizeLimits) (logs []*slsSdk.Log, rejected int, err error) {
+	spans, rejected, err := convertOTLPSpans(resourceSpans, limits)
+	logs = make([]*slsSdk.Log, len(spans))
+	for i, span := range spans {
+		logs[i] = span.log
+	}
+	return logs, rejected, err
+}
+
+// convertOTLPSpans converts the OTLP spans like OTLPToSLSLogs, together with the fields the sampler decides by.
+func convertOTLPSpans(resourceSpans []*traceV1.ResourceSpans, limits SizeLimits) (spans []sampledSpan, rejected int, err error) {
+	spans = make([]sampledSpan, 0)
+	for _, rs := range resourceSpans {
+		resource := make(map[string]string)
+		if rs.GetResource() != nil {
+			resource = otlpAttributesToMap(rs.GetResource().GetAttributes())
+		}
+
+		service := resource[OTLPServiceNameKey]
+		if service == "" {
+			service = OTLPUnknownService
+		}
+		resource["ProcessID"] = ""
+		resourceCut := make(map[string]bool)
+		limits.truncateMap(resource, tagScopeResource, resourceCut)
+		resourceStr, resourceErr := json.Marshal(resource)
+		for _, ils := range rs.GetInstrumentationLibrarySpans() {
+			for _, span := range ils.GetSpans() {
+				contents, e := []*slsSdk.LogContent(nil), resourceErr
+				if e == nil {
+					cut := make(map[string]bool, len(resourceCut))
+					for field := range resourceCut {
+						cut[field] = true
+					}
+					contents, e = otlpSpanToSLSSpan(span, ils.GetInstrumentationLibrary(), service, string(resourceStr), limits, cut)
+				}
+				if e != nil {
+					logger.Warn("Failed to convert OTLP span", "spanID", hex.EncodeToString(span.GetSpanId()), "exception", e)
+					if rejected++; err == nil {
+						err = e
+					}
+					continue
+				}
+
+				spans = append(spans, sampledSpan{
+					span: otlpSamplingSpan(span, service),
+					log: &slsSdk.Log{
+						Time:     proto.Uint32(uint32(span.GetStartTimeUnixNano() / 1e9)),
+						Contents: contents,
+					},
+				})
+			}
+		}
+	}
+
+	return spans, rejected, err
+}
+
+// otlpSamplingSpan the trace id, parent, duration, status and service of a converted OTLP span, as a jaeger span
+// the sampler can decide by.
+func otlpSamplingSpan(span *traceV1.Span, service string) *model.Span {
+	traceID, _ := otlpTraceID(span.GetTraceId())
+	spanID, _ := otlpSpanID(span.GetSpanId())
+	result := &model.Span{
+		TraceID:   traceID,
+		SpanID:    spanID,
+		StartTime: time.Unix(0, int64(span.GetStartTimeUnixNano())),
+		Tags:      []model.KeyValue{model.String("otel.status_code", otlpStatusCode(span.GetStatus()))},
+		Process:   &model.Process{ServiceName: service},
+	}
+	if end, start := span.GetEndTimeUnixNano(), span.GetStartTimeUnixNano(); end > start {
+		result.Duration = time.Duration(end - start)
+	}
+	if parent, err := otlpSpanID(span.GetParentSpanId()); err == nil && parent != model.NewSpanID(0) {
+		result.References = []model.SpanRef{model.NewChildOfRef(traceID, parent)}
+	}
+	for _, kv := range span.GetAttributes() {
+		if kv.GetKey() == "error" {
+			result.Tags = append(result.Tags, model.String("error", otlpValueToString(kv.GetValue())))
+		}
+	}
+	return result
+}
+
+func otlpSpanToSLSSpan(span *traceV1.Span, library *commonV1.InstrumentationLibrary, service, resource string,
+	limits SizeLimits, cut map[string]bool) ([]*slsSdk.LogContent, error) {
+	traceID, err := otlpTraceID(span.GetTraceId())
+	if err != nil {
+		return nil, err
+	}
+
+	spanID, err := otlpSpanID(span.GetSpanId())
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := otlpAttributesToMap(span.GetAttributes())
+	if library != nil && library.GetName() != "" {
+		attributes[OTLPLibraryNameKey] = library.GetName()
+		if library.GetVersion() != "" {
+			attributes[OTLPLibraryVersionKey] = library.GetVersion()
+		}
+	}
+	linkAttributes := make([]map[string]string, len(span.GetLinks()))
+	for i, link := range span.GetLinks() {
+		linkAttributes[i] = otlpAttributesToMap(link.GetAttributes())
+	}
+	spanLogs, warnings := limits.applyOTLP(attributes, otlpEventsToSpanLogs(span.GetEvents()), linkAttributes, len(resource), cut)
+
+	attributeStr, err := json.Marshal(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := marshalOTLPLinks(span, linkAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := json.Marshal(spanLogs)
+	if err != nil {
+		return nil, err
+	}
+
+	start := span.GetStartTimeUnixNano() / 1000
+	end := span.GetEndTimeUnixNano() / 1000
+	duration := uint64(0)
+	if end > start {
+		duration = end - start
+	}
+
+	parentSpanID := model.NewSpanID(0)
+	if len(span.GetParentSpanId()) > 0 {
+		if parentSpanID, err = otlpSpanID(span.GetParentSpanId()); err != nil {
+			return nil, err
+		}
+	}
+
+	contents := make([]*slsSdk.LogContent, 0)
+	contents = appendAttributeToLogContent(contents, TraceID, traceID.String())
+	contents = appendAttributeToLogContent(contents, SpanID, spanID.String())
+	contents = appendAttributeToLogContent(contents, ParentSpanID, parentSpanID.String())
+	contents = appendAttributeToLogContent(contents, OperationName, span.GetName())
+	contents = appendAttributeToLogContent(contents, Flags, fmt.Sprintf("%d", model.SampledFlag))
+	contents = appendAttributeToLogContent(contents, StartTime, cast.ToString(start))
+	contents = appendAttributeToLogContent(contents, Duration, cast.ToString(duration))
+	contents = appendAttributeToLogContent(contents, EndTime, cast.ToString(end))
+	contents = appendAttributeToLogContent(contents, ServiceName, service)
+	contents = appendAttributeToLogContent(contents, StatusCode, otlpStatusCode(span.GetStatus()))
+	contents = appendAttributeToLogContent(contents, Attribute, string(attributeStr))
+	contents = appendAttributeToLogContent(contents, Resource, resource)
+	contents = appendAttributeToLogContent(contents, SpanKind, otlpSpanKind(span.GetKind()))
+	contents = appendAttributeToLogContent(contents, Links, links)
+	contents = appendAttributeToLogContent(contents, Logs, string(events))
+	if msg := span.GetStatus().GetMessage(); msg != "" {
+		warnings = append([]string{msg}, warnings...)
+	}
+	return appendWarnings(contents, warnings)
+}
+
+// marshalOTLPLinks stores the parent span as a CHILD_OF reference and every span link as a FOLLOWS_FROM reference,
+// in the same layout as marshalReferences. The attributes of the links are passed in the order of the links.
+func marshalOTLPLinks(span *traceV1.Span, linkAttributes []map[string]string) (string, error) {
+	rs := make([]map[string]string, 0)
+	if len(span.GetParentSpanId()) > 0 {
+		traceID, err := otlpTraceID(span.GetTraceId())
+		if err != nil {
+			return "", err
+		}
+		parentSpanID, err := otlpSpanID(span.GetParentSpanId())
+		if err != nil {
+			return "", err
+		}
+
+		rs = append(rs, map[string]string{
+			"TraceID": traceID.String(),
+			"SpanID":  parentSpanID.String(),
+			"RefType": model.SpanRefType_CHILD_OF.String(),
+		})
+	}
+
+	for i, link := range span.GetLinks() {
+		traceID, err := otlpTraceID(link.GetTraceId())
+		if err != nil {
+			return "", err
+		}
+		spanID, err := otlpSpanID(link.GetSpanId())
+		if err != nil {
+			return "", err
+		}
+
+		r := spanLink{
+			TraceID:    traceID.String(),
+			SpanID:     spanID.String(),
+			RefType:    model.SpanRefType_FOLLOWS_FROM.String(),
+			TraceState: link.GetTraceState(),
+		}
+		if len(linkAttributes[i]) > 0 {
+			attributes, err := json.Marshal(linkAttributes[i])
+			if err != nil {
+				return "", err
+			}
+			r.Attributes = string(attributes)
+		}
+		rs = append(rs, r.toMap())
+	}
+
+	r, err := json.Marshal(rs)
+	if err != nil {
+		return "", err
+	}
+
+	return string(r), nil
+}
+
+func otlpEventsToSpanLogs(events []*traceV1.Span_Event) []SpanLog {
+	slsLogs := make([]SpanLog, len(events))
+	for i, event := range events {
+		attributes := otlpAttributesToMap(event.GetAttributes())
+		if event.GetName() != "" {
+			attributes[OTLPEventNameKey] = event.GetName()
+		}
+
+		slsLogs[i] = SpanLog{
+			Time:      int64(event.GetTimeUnixNano()),
+			Attribute: attributes,
+		}
+	}
+	return slsLogs
+}
+
+func otlpStatusCode(status *traceV1.Status) string {
+	switch status.GetCode() {
+	case traceV1.Status_STATUS_CODE_OK:
+		return "OK"
+	case traceV1.Status_STATUS_CODE_ERROR:
+		return "ERROR"
+	default:
+		return "UNSET"
+	}
+}
+
+func otlpSpanKind(kind traceV1.Span_SpanKind) string {
+	switch kind {
+	case traceV1.Span_SPAN_KIND_INTERNAL:
+		return "internal"
+	case traceV1.Span_SPAN_KIND_SERVER:
+		return "server"
+	case traceV1.Span_SPAN_KIND_CLIENT:
+		return "client"
+	case traceV1.Span_SPAN_KIND_PRODUCER:
+		return "producer"
+	case traceV1.Span_SPAN_KIND_CONSUMER:
+		return "consumer"
+	default:
+		return ""
+	}
+}
+
+func otlpTraceID(id []byte) (model.TraceID, error) {
+	if len(id) != 16 {
+		return model.TraceID{}, fmt.Errorf("invalid OTLP trace id %q", hex.EncodeToString(id))
+	}
+
+	return model.TraceIDFromBytes(id)
+}
+
+func otlpSpanID(id []byte) (model.SpanID, error) {
+	if len(id) != 8 {
+		return model.SpanID(0), fmt.Errorf("invalid OTLP span id %q", hex.EncodeToString(id))
+	}
+
+	return model.SpanIDFromBytes(id)
+}
+
+func otlpAttributesToMap(attributes []*commonV1.KeyValue) map[string]string {
+	m := make(map[string]string)
+	for _, kv := range attributes {
+		m[kv.GetKey()] = otlpValueToString(kv.GetValue())
+	}
+	return m
+}
+
+func otlpValueToString(v *commonV1.AnyValue) string {
+	switch value := v.GetValue().(type) {
+	case *commonV1.AnyValue_StringValue:
+		return value.StringValue
+	case *commonV1.AnyValue_BoolValue:
+		return cast.ToString(value.BoolValue)
+	case *commonV1.AnyValue_IntValue:
+		return cast.ToString(value.IntValue)
+	case *commonV1.AnyValue_DoubleValue:
+		return cast.ToString(value.DoubleValue)
+	case *commonV1.AnyValue_BytesValue:
+		return hex.EncodeToString(value.BytesValue)
+	case *commonV1.AnyValue_ArrayValue:
+		values := make([]string, len(value.ArrayValue.GetValues()))
+		for i, item := range value.ArrayValue.GetValues() {
+			values[i] = otlpValueToString(item)
+		}
+		r, _ := json.Marshal(values)
+		return string(r)
+	case *commonV1.AnyValue_KvlistValue:
+		r, _ := json.Marshal(otlpAttributesToMap(value.KvlistValue.GetValues()))
+		return string(r)
+	default:
+		return ""
+	}
+}
+
+// JaegerTraceToOTLP converts a jaeger trace to OTLP resource spans, spans are grouped by their process.
+func JaegerTraceToOTLP(trace *model.Trace) []*traceV1.ResourceSpans {
+	resourceSpans := make([]*traceV1.ResourceSpans, 0)
+	byProcess := make(map[*model.Process]*traceV1.InstrumentationLibrarySpans)
+	for _, span := range trace.Spans {
+		ils, ok := byProcess[span.Process]
+		if !ok {
+			ils = &traceV1.InstrumentationLibrarySpans{}
+			byProcess[span.Process] = ils
+			resourceSpans = append(resourceSpans, &traceV1.ResourceSpans{
+				Resource:                    jaegerProcessToOTLP(span.Process),
+				InstrumentationLibrarySpans: []*traceV1.InstrumentationLibrarySpans{ils},
+			})
+		}
+		ils.Spans = append(ils.Spans, jaegerSpanToOTLP(span))
+	}
+
+	return resourceSpans
+}
+
+func jaegerProcessToOTLP(process *model.Process) *resourceV1.Resource {
+	if process == nil {
+		return &resourceV1.Resource{}
+	}
+
+	attributes := []*commonV1.KeyValue{otlpStringKeyValue(OTLPServiceNameKey, process.ServiceName)}
+	return &resourceV1.Resource{
+		Attributes: append(attributes, jaegerTagsToOTLP(process.Tags)...),
+	}
+}
+
+func jaegerSpanToOTLP(span *model.Span) *traceV1.Span {
+	result := &traceV1.Span{
+		TraceId:           jaegerTraceIDToBytes(span.TraceID),
+		SpanId:            jaegerSpanIDToBytes(span.SpanID),
+		Name:              span.OperationName,
+		StartTimeUnixNano: uint64(span.StartTime.UnixNano()),
+		EndTimeUnixNano:   uint64(span.StartTime.Add(span.Duration).UnixNano()),
+		Kind:              traceV1.Span_SPAN_KIND_UNSPECIFIED,
+		Status:            &traceV1.Status{},
+	}
+
+	tags, links := splitLinkTags(span.Tags, span.References)
+	for _, tag := range tags {
+		switch tag.Key {
+		case "span.kind":
+			result.Kind = jaegerSpanKindToOTLP(tag.AsString())
+		case "error":
+			if tag.AsString() == "true" {
+				result.Status.Code = traceV1.Status_STATUS_CODE_ERROR
+			}
+		default:
+			result.Attributes = append(result.Attributes, jaegerTagToOTLP(tag))
+		}
+	}
+
+	for _, ref := range span.References {
+		if ref.RefType == model.SpanRefType_CHILD_OF && ref.TraceID == span.TraceID && len(result.ParentSpanId) == 0 {
+			result.ParentSpanId = jaegerSpanIDToBytes(ref.SpanID)
+			continue
+		}
+		link := &traceV1.Span_Link{
+			TraceId: jaegerTraceIDToBytes(ref.TraceID),
+			SpanId:  jaegerSpanIDToBytes(ref.SpanID),
+		}
+		if l, ok := links[linkKey(ref.TraceID, ref.SpanID)]; ok {
+			link.TraceState = l.TraceState
+			if l.Attributes != "" {
+				for k, v := range linkAttributes(l.Attributes) {
+					link.Attributes = append(link.Attributes, otlpStringKeyValue(k, v))
+				}
+			}
+		}
+		result.Links = append(result.Links, link)
+	}
+
+	for _, log := range span.Logs {
+		event := &traceV1.Span_Event{TimeUnixNano: uint64(log.Timestamp.UnixNano())}
+		for _, field := range log.Fields {
+			if field.Key == OTLPEventNameKey {
+				event.Name = field.AsString()
+				continue
+			}
+			event.Attributes = append(event.Attributes, jaegerTagToOTLP(field))
+		}
+		result.Events = append(result.Events, event)
+	}
+
+	return result
+}
+
+func jaegerSpanKindToOTLP(kind string) traceV1.Span_SpanKind {
+	switch kind {
+	case "internal":
+		return traceV1.Span_SPAN_KIND_INTERNAL
+	case "server":
+		return traceV1.Span_SPAN_KIND_SERVER
+	case "client":
+		return traceV1.Span_SPAN_KIND_CLIENT
+	case "producer":
+		return traceV1.Span_SPAN_KIND_PRODUCER
+	case "consumer":
+		return traceV1.Span_SPAN_KIND_CONSUMER
+	default:
+		return traceV1.Span_SPAN_KIND_UNSPECIFIED
+	}
+}
+
+func jaegerTagsToOTLP(tags []model.KeyValue) []*commonV1.KeyValue {
+	result := make([]*commonV1.KeyValue, len(tags))
+	for i, tag := range tags {
+		result[i] = jaegerTagToOTLP(tag)
+	}
+	return result
+}
+
+func jaegerTagToOTLP(tag model.KeyValue) *commonV1.KeyValue {
+	value := &commonV1.AnyValue{}
+	switch tag.VType {
+	case model.BoolType:
+		value.Value = &commonV1.AnyValue_BoolValue{BoolValue: tag.Bool()}
+	case model.Int64Type:
+		value.Value = &commonV1.AnyValue_IntValue{IntValue: tag.Int64()}
+	case model.Float64Type:
+		value.Value = &commonV1.AnyValue_DoubleValue{DoubleValue: tag.Float64()}
+	case model.BinaryType:
+		value.Value = &commonV1.AnyValue_BytesValue{BytesValue: tag.Binary()}
+	default:
+		value.Value = &commonV1.AnyValue_StringValue{StringValue: tag.AsString()}
+	}
+	return &commonV1.KeyValue{Key: tag.Key, Value: value}
+}
+
+func otlpStringKeyValue(key, value string) *commonV1.KeyValue {
+	return &commonV1.KeyValue{
+		Key:   key,
+		Value: &commonV1.AnyValue{Value: &commonV1.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+func jaegerTraceIDToBytes(id model.TraceID) []byte {
+	data := make([]byte, 16)
+	_, _ = id.MarshalTo(data)
+	return data
+}
+
+func jaegerSpanIDToBytes(id model.SpanID) []byte {
+	data := make([]byte, 8)
+	_, _ = id.MarshalTo(data)
+	return data
+}
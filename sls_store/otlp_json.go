@@ -0,0 +1,113 @@
+package sls_store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+
+	collectorV1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// otlpIDSizes the hex length of the ids of OTLP/JSON spans and links, keyed by their camel case and proto names.
+var otlpIDSizes = map[string]int{
+	"traceId":        32,
+	"trace_id":       32,
+	"spanId":         16,
+	"span_id":        16,
+	"parentSpanId":   16,
+	"parent_span_id": 16,
+}
+
+// UnmarshalOTLPJSON decodes an OTLP/JSON request. The OTLP specification encodes trace and span ids as hex, while
+// protojson expects base64, so hex ids are converted first. Base64 ids are still accepted. Requests of newer
+// senders with scopeSpans are read as instrumentationLibrarySpans.
+func UnmarshalOTLPJSON(data []byte, request *collectorV1.ExportTraceServiceRequest) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(convertOTLPJSON(value, hexToBase64))
+	if err != nil {
+		return err
+	}
+	return protojson.Unmarshal(data, request)
+}
+
+// MarshalOTLPJSON encodes an OTLP/JSON request with hex trace and span ids, as the OTLP specification says.
+func MarshalOTLPJSON(request *collectorV1.ExportTraceServiceRequest) ([]byte, error) {
+	data, err := protojson.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(convertOTLPJSON(value, base64ToHex))
+}
+
+func convertOTLPJSON(value interface{}, convertID func(id string, size int) string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if scopeSpans, ok := v["scopeSpans"]; ok {
+			delete(v, "scopeSpans")
+			v["instrumentationLibrarySpans"] = renameOTLPScope(scopeSpans)
+		}
+		for key, item := range v {
+			if size, ok := otlpIDSizes[key]; ok {
+				if id, ok := item.(string); ok {
+					v[key] = convertID(id, size)
+					continue
+				}
+			}
+			v[key] = convertOTLPJSON(item, convertID)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertOTLPJSON(item, convertID)
+		}
+	}
+	return value
+}
+
+// renameOTLPScope renames the scope of scopeSpans to the instrumentationLibrary of the OTLP version the plugin reads.
+func renameOTLPScope(value interface{}) interface{} {
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok {
+				if scope, ok := m["scope"]; ok {
+					delete(m, "scope")
+					m["instrumentationLibrary"] = scope
+				}
+			}
+		}
+	}
+	return value
+}
+
+func hexToBase64(id string, size int) string {
+	if len(id) != size {
+		return id
+	}
+	data, err := hex.DecodeString(id)
+	if err != nil {
+		return id
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func base64ToHex(id string, size int) string {
+	data, err := base64.StdEncoding.DecodeString(id)
+	if err != nil || len(data)*2 != size {
+		return id
+	}
+	return hex.EncodeToString(data)
+}
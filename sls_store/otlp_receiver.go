@@ -0,0 +1,185 @@
+package sls_store
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	collectorV1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	protoV2 "google.golang.org/protobuf/proto"
+)
+
+const (
+	// OTLPTracesPath the url path of OTLP/HTTP trace receiver
+	OTLPTracesPath = "/v1/traces"
+	// OTLPMaxRequestSize the max body size of an OTLP/HTTP request
+	OTLPMaxRequestSize = 32 * 1024 * 1024
+)
+
+// OTLPReceiver receives OTLP trace data over gRPC and HTTP and writes it to the SLS trace logstore.
+type OTLPReceiver struct {
+	collectorV1.UnimplementedTraceServiceServer
+
+	writer  *slsSpanWriter
+	tenancy *tenancy
+	logger  hclog.Logger
+}
+
+// otlpPartialSuccess the spans of an export request which were rejected, while the others were written.
+type otlpPartialSuccess struct {
+	RejectedSpans int64  `json:"rejectedSpans,omitempty"`
+	ErrorMessage  string `json:"errorMessage,omitempty"`
+}
+
+// encode returns the partial_success field of ExportTraceServiceResponse. The OTLP version the plugin is built
+// with predates the field, so it is added as unknown field, which has the same wire format.
+func (p otlpPartialSuccess) encode() []byte {
+	var inner []byte
+	inner = protowire.AppendTag(inner, 1, protowire.VarintType)
+	inner = protowire.AppendVarint(inner, uint64(p.RejectedSpans))
+	inner = protowire.AppendTag(inner, 2, protowire.BytesType)
+	inner = protowire.AppendString(inner, p.ErrorMessage)
+
+	field := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(field, inner)
+}
+
+// Export implements the OTLP/gRPC trace service. Spans which can not be converted are rejected, the others are
+// written and the response reports the rejected ones as partial success.
+func (r *OTLPReceiver) Export(ctx context.Context, request *collectorV1.ExportTraceServiceRequest) (*collectorV1.ExportTraceServiceResponse, error) {
+	response, _, err := r.export(ctx, request)
+	return response, err
+}
+
+func (r *OTLPReceiver) export(ctx context.Context, request *collectorV1.ExportTraceServiceRequest) (*collectorV1.ExportTraceServiceResponse,
+	*otlpPartialSuccess, error) {
+	writer := r.writer
+	if r.tenancy != nil {
+		plugin, err := r.tenancy.resolve(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		writer = plugin.buildSpanWriter()
+	}
+
+	ctx, span := writer.tracer.startWriteSpan(ctx, "Export")
+	defer span.finish()
+
+	writer.redactor.RedactOTLP(request.GetResourceSpans())
+	_, step := startSelfSpan(ctx, "convert.OTLPToSLSLogs")
+	spans, rejected, err := convertOTLPSpans(request.GetResourceSpans(), writer.limits)
+	step.setTag("rows", len(spans))
+	step.setTag("rejected", rejected)
+	step.setError(err)
+	step.finish()
+	if len(spans) == 0 && err != nil {
+		span.setError(err)
+		return nil, nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := writer.writeOTLPSpans(ctx, spans); err != nil {
+		span.setError(err)
+		return nil, nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	response := &collectorV1.ExportTraceServiceResponse{}
+	if rejected == 0 {
+		return response, nil, nil
+	}
+	incCounter("otlp_rejected_spans", int64(rejected))
+	partial := &otlpPartialSuccess{RejectedSpans: int64(rejected), ErrorMessage: err.Error()}
+	response.ProtoReflect().SetUnknown(partial.encode())
+	return response, partial, nil
+}
+
+// ServeHTTP implements the OTLP/HTTP trace receiver, both binary protobuf and JSON encoding are accepted.
+func (r *OTLPReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != OTLPTracesPath {
+		http.NotFound(w, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := readOTLPBody(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	isProtobuf := !strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+	request := &collectorV1.ExportTraceServiceRequest{}
+	if isProtobuf {
+		err = protoV2.Unmarshal(body, request)
+	} else {
+		err = UnmarshalOTLPJSON(body, request)
+	}
+	if err != nil {
+		r.logger.Warn("Failed to decode OTLP request", "exception", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := req.Context()
+	if r.tenancy != nil {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(r.tenancy.header, req.Header.Get(r.tenancy.header)))
+	}
+	response, partial, err := r.export(ctx, request)
+	if err != nil {
+		code := http.StatusServiceUnavailable
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		case codes.PermissionDenied:
+			code = http.StatusForbidden
+		}
+		http.Error(w, status.Convert(err).Message(), code)
+		return
+	}
+
+	var data []byte
+	if isProtobuf {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		data, err = protoV2.Marshal(response)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		data, err = protojson.Marshal(response)
+		if partial != nil {
+			data, err = json.Marshal(map[string]*otlpPartialSuccess{"partialSuccess": partial})
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func readOTLPBody(req *http.Request) ([]byte, error) {
+	var reader io.Reader = http.MaxBytesReader(nil, req.Body, OTLPMaxRequestSize)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = io.LimitReader(gzipReader, OTLPMaxRequestSize)
+	}
+
+	return ioutil.ReadAll(reader)
+}
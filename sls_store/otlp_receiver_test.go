@@ -0,0 +1,79 @@
+package sls_store
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	collectorV1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonV1 "go.opentelemetry.io/proto/otlp/common/v1"
+	resourceV1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	traceV1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func otlpTestSpan(traceID byte, status traceV1.Status_StatusCode, attributes ...*commonV1.KeyValue) *traceV1.Span {
+	return &traceV1.Span{
+		TraceId:           []byte{15: traceID},
+		SpanId:            []byte{7: traceID},
+		Name:              "op",
+		StartTimeUnixNano: uint64(time.Now().UnixNano()),
+		EndTimeUnixNano:   uint64(time.Now().UnixNano()),
+		Attributes:        attributes,
+		Status:            &traceV1.Status{Code: status},
+	}
+}
+
+func otlpTestRequest(spans ...*traceV1.Span) *collectorV1.ExportTraceServiceRequest {
+	return &collectorV1.ExportTraceServiceRequest{ResourceSpans: []*traceV1.ResourceSpans{{
+		Resource:                    &resourceV1.Resource{Attributes: []*commonV1.KeyValue{otlpStringKeyValue(OTLPServiceNameKey, "frontend")}},
+		InstrumentationLibrarySpans: []*traceV1.InstrumentationLibrarySpans{{Spans: spans}},
+	}}}
+}
+
+func TestOTLPReceiverRedactsAndSamples(t *testing.T) {
+	redactor, err := NewRedactor([]RedactionRule{{Name: "password", Key: "password", Action: RedactionMask}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeSLS(t)
+	plugin := fake.plugin(WithRedactor(redactor),
+		WithSampling(SamplingConfig{Enabled: true, BufferWindow: time.Hour, KeepErrors: true}))
+
+	request := otlpTestRequest(
+		otlpTestSpan(1, traceV1.Status_STATUS_CODE_ERROR, otlpStringKeyValue("password", "secret")),
+		otlpTestSpan(2, traceV1.Status_STATUS_CODE_OK),
+		otlpTestSpan(3, traceV1.Status_STATUS_CODE_UNSET, otlpStringKeyValue("error", "true")),
+	)
+	if _, err := plugin.OTLPReceiver().Export(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if logs := fake.logs(plugin.instance.traceLogStore()); len(logs) != 0 {
+		t.Fatalf("wrote %d spans before the sampling decision", len(logs))
+	}
+	if err := plugin.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	logs := fake.logs(plugin.instance.traceLogStore())
+	kept := map[string]bool{}
+	for _, log := range logs {
+		kept[log[TraceID]] = true
+		if strings.Contains(log[Attribute], "secret") {
+			t.Errorf("the password was not redacted: %s", log[Attribute])
+		}
+	}
+	tests := []struct {
+		traceID string
+		want    bool
+	}{
+		{"0000000000000001", true},
+		{"0000000000000002", false},
+		{"0000000000000003", true},
+	}
+	for _, test := range tests {
+		if kept[test.traceID] != test.want {
+			t.Errorf("trace %s kept = %v, want %v", test.traceID, kept[test.traceID], test.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package sls_store
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+const (
+	// CompressionLZ4 compresses PutLogs requests with lz4, the default of the SDK
+	CompressionLZ4 = "lz4"
+	// CompressionDeflate compresses PutLogs requests with deflate in the zlib format
+	CompressionDeflate = "deflate"
+	// CompressionNone sends PutLogs requests uncompressed
+	CompressionNone = "none"
+	// CompressionZstd compresses PutLogs requests with zstd, smaller than lz4 for little more CPU
+	CompressionZstd = "zstd"
+)
+
+// TransportConfig the compression of PutLogs requests and the HTTP connection pool of SLS requests. The zero
+// values of the pool settings keep the defaults of net/http.
+type TransportConfig struct {
+	Compression         string
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	KeepAlive           time.Duration
+}
+
+// DefaultTransportConfig lz4 compression and the default HTTP connection pool
+var DefaultTransportConfig = TransportConfig{Compression: CompressionLZ4}
+
+// ValidateCompression returns an error for the compressions the plugin can not send.
+func ValidateCompression(compression string) error {
+	switch compression {
+	case CompressionLZ4, CompressionDeflate, CompressionZstd, CompressionNone:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q, use lz4, deflate, zstd or none", compression)
+}
+
+var configureTransportOnce sync.Once
+
+// configureHTTPTransport applies the keepalive and connection pool settings of the config to the default HTTP
+// transport, once per process. The SLS SDK sends every request through http.DefaultTransport and has no way to set
+// the HTTP client of its clients, so the pool is shared by every plugin, tenant and target of the process, and the
+// first plugin built with pool settings configures it.
+func configureHTTPTransport(config TransportConfig) {
+	if config.MaxIdleConns == 0 && config.MaxIdleConnsPerHost == 0 && config.IdleConnTimeout == 0 && config.KeepAlive == 0 {
+		return
+	}
+	configureTransportOnce.Do(func() {
+		http.DefaultTransport = newHTTPTransport(config)
+	})
+}
+
+func newHTTPTransport(config TransportConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.KeepAlive > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: config.KeepAlive,
+		}).DialContext
+	}
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	return transport
+}
+
+// logGroupSender sends log groups through the raw request API of the SDK, so that the compression is configurable
+// and the sizes before and after compression are known.
+type logGroupSender struct {
+	project     *slsSdk.LogProject
+	compression string
+	// err the error of creating the project, returned by every send
+	err error
+}
+
+func newLogGroupSender(s SlsJaegerStoragePlugin) *logGroupSender {
+	compression := s.transportConfig.Compression
+	if compression == "" {
+		compression = CompressionLZ4
+	}
+
+	project, err := slsSdk.NewLogProject(s.instance.project(), s.endpoint, s.accessKeyID, s.accessSecret)
+	if err != nil {
+		s.logger.Error("Failed to create the SLS project client, writes will fail", "Project", s.instance.project(), "Exception", err)
+		return &logGroupSender{compression: compression, err: err}
+	}
+
+	return &logGroupSender{
+		project:     project.WithRequestTimeout(DefaultRequestTimeOut),
+		compression: compression,
+	}
+}
+
+// send puts the log group into the logstore, into the shard of the hash key when it is not nil. Server errors are
+// retried until DefaultRetryTimeOut, as the SDK does for PutLogs, or until the context is done.
+func (s *logGroupSender) send(ctx context.Context, logstore string, lg *slsSdk.LogGroup, hashKey *string) error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(lg.Logs) == 0 {
+		return nil
+	}
+
+	raw, err := lg.Marshal()
+	if err != nil {
+		return err
+	}
+	body, err := compress(s.compression, raw)
+	if err != nil {
+		return err
+	}
+
+	uri := fmt.Sprintf("/logstores/%v", logstore)
+	if hashKey != nil && *hashKey != "" {
+		uri = fmt.Sprintf("/logstores/%v/shards/route?key=%v", logstore, *hashKey)
+	}
+
+	deadline := time.Now().Add(DefaultRetryTimeOut)
+	for attempt := 0; ; attempt++ {
+		headers := map[string]string{
+			"x-log-bodyrawsize": strconv.Itoa(len(raw)),
+			"Content-Type":      "application/x-protobuf",
+		}
+		if s.compression != CompressionNone {
+			headers["x-log-compresstype"] = s.compression
+		}
+
+		response, err := s.project.RawRequest(http.MethodPost, uri, headers, body)
+		if err == nil {
+			response.Body.Close()
+			incCounter("put_logs_raw_bytes", int64(len(raw)))
+			incCounter("put_logs_compressed_bytes", int64(len(body)))
+			return nil
+		}
+
+		backoff := throttleBackoff(attempt)
+		if !isServerError(err) || time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// probe posts an empty log group to the logstore, which writes nothing but is authorized like a write. Only the
+// errors of the authorization mean the write permission is missing, SLS may reject the empty body itself.
+func (s *logGroupSender) probe(logstore string) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	headers := map[string]string{
+		"x-log-bodyrawsize": "0",
+		"Content-Type":      "application/x-protobuf",
+	}
+	response, err := s.project.RawRequest(http.MethodPost, fmt.Sprintf("/logstores/%v", logstore), headers, nil)
+	if err == nil {
+		response.Body.Close()
+		return nil
+	}
+
+	var slsErr *slsSdk.Error
+	if errors.As(err, &slsErr) && slsErr.HTTPCode >= http.StatusBadRequest && slsErr.HTTPCode < http.StatusInternalServerError &&
+		slsErr.HTTPCode != http.StatusUnauthorized && slsErr.HTTPCode != http.StatusForbidden {
+		return nil
+	}
+	return err
+}
+
+func isServerError(err error) bool {
+	var slsErr *slsSdk.Error
+	if !errors.As(err, &slsErr) {
+		return false
+	}
+	return slsErr.HTTPCode == http.StatusInternalServerError || slsErr.HTTPCode == http.StatusBadGateway ||
+		slsErr.HTTPCode == http.StatusServiceUnavailable
+}
+
+func compress(compression string, raw []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return raw, nil
+	case CompressionDeflate:
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		if _, err := w.Write(raw); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionLZ4:
+		out := make([]byte, lz4.CompressBlockBound(len(raw)))
+		var hashTable [1 << 16]int
+		n, err := lz4.CompressBlock(raw, out, hashTable[:])
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return literalLZ4Block(raw), nil
+		}
+		return out[:n], nil
+	case CompressionZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(raw, make([]byte, 0, len(raw)/2)), nil
+	}
+	return nil, ValidateCompression(compression)
+}
+
+var (
+	zstdEncoderOnce sync.Once
+	sharedZstd      *zstd.Encoder
+	zstdErr         error
+)
+
+// zstdEncoder the encoder shared by every sender, EncodeAll is safe for concurrent use.
+func zstdEncoder() (*zstd.Encoder, error) {
+	zstdEncoderOnce.Do(func() {
+		sharedZstd, zstdErr = zstd.NewWriter(nil)
+	})
+	return sharedZstd, zstdErr
+}
+
+// literalLZ4Block encodes incompressible data as a lz4 block of one literal sequence.
+func literalLZ4Block(src []byte) []byte {
+	out := make([]byte, 0, len(src)+len(src)/255+16)
+	length := len(src)
+	if length < 0xF {
+		out = append(out, byte(length<<4))
+	} else {
+		out = append(out, 0xF0)
+		for length -= 0xF; length >= 0xFF; length -= 0xFF {
+			out = append(out, 0xFF)
+		}
+		out = append(out, byte(length))
+	}
+	return append(out, src...)
+}
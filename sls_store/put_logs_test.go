@@ -0,0 +1,201 @@
+package sls_store
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// lengths around the 15 byte literal length nibble and the 255 byte length extension
+var roundTripLengths = []int{1, 14, 15, 16, 269, 270, 271, 1000, 64 << 10}
+
+func roundTripInputs(length int) map[string][]byte {
+	random := make([]byte, length)
+	rand.New(rand.NewSource(int64(length))).Read(random)
+	return map[string][]byte{
+		"random":       random,
+		"compressible": bytes.Repeat([]byte("a"), length),
+	}
+}
+
+func TestCompressLZ4RoundTrip(t *testing.T) {
+	for _, length := range roundTripLengths {
+		for name, raw := range roundTripInputs(length) {
+			compressed, err := compress(CompressionLZ4, raw)
+			if err != nil {
+				t.Fatalf("%s %d: compress: %v", name, length, err)
+			}
+			decoded := make([]byte, length)
+			n, err := lz4.UncompressBlock(compressed, decoded)
+			if err != nil {
+				t.Fatalf("%s %d: uncompress: %v", name, length, err)
+			}
+			if !bytes.Equal(decoded[:n], raw) {
+				t.Errorf("%s %d: round trip mismatch", name, length)
+			}
+		}
+	}
+}
+
+func TestLiteralLZ4BlockRoundTrip(t *testing.T) {
+	for _, length := range roundTripLengths {
+		for name, raw := range roundTripInputs(length) {
+			decoded := make([]byte, length)
+			n, err := lz4.UncompressBlock(literalLZ4Block(raw), decoded)
+			if err != nil {
+				t.Fatalf("%s %d: uncompress: %v", name, length, err)
+			}
+			if !bytes.Equal(decoded[:n], raw) {
+				t.Errorf("%s %d: round trip mismatch", name, length)
+			}
+		}
+	}
+}
+
+func TestCompressDeflateRoundTrip(t *testing.T) {
+	for _, length := range roundTripLengths {
+		for name, raw := range roundTripInputs(length) {
+			compressed, err := compress(CompressionDeflate, raw)
+			if err != nil {
+				t.Fatalf("%s %d: compress: %v", name, length, err)
+			}
+			r, err := zlib.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatalf("%s %d: reader: %v", name, length, err)
+			}
+			decoded, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s %d: uncompress: %v", name, length, err)
+			}
+			if !bytes.Equal(decoded, raw) {
+				t.Errorf("%s %d: round trip mismatch", name, length)
+			}
+		}
+	}
+}
+
+func TestCompressZstdRoundTrip(t *testing.T) {
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer decoder.Close()
+
+	for _, length := range roundTripLengths {
+		for name, raw := range roundTripInputs(length) {
+			compressed, err := compress(CompressionZstd, raw)
+			if err != nil {
+				t.Fatalf("%s %d: compress: %v", name, length, err)
+			}
+			decoded, err := decoder.DecodeAll(compressed, nil)
+			if err != nil {
+				t.Fatalf("%s %d: uncompress: %v", name, length, err)
+			}
+			if !bytes.Equal(decoded, raw) {
+				t.Errorf("%s %d: round trip mismatch", name, length)
+			}
+		}
+	}
+}
+
+func TestLogGroupSenderSend(t *testing.T) {
+	var request *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	// an ip endpoint makes the SDK send the requests of the project through it as a proxy
+	project, err := slsSdk.NewLogProject("project", strings.TrimPrefix(server.URL, "http://"), "id", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &logGroupSender{project: project, compression: CompressionLZ4}
+
+	lg := &slsSdk.LogGroup{
+		Topic: proto.String("topic"),
+		Logs: []*slsSdk.Log{{
+			Time:     proto.Uint32(1),
+			Contents: []*slsSdk.LogContent{{Key: proto.String("traceID"), Value: proto.String(strings.Repeat("a", 300))}},
+		}},
+	}
+	hashKey := "00"
+	if err := sender.send(context.Background(), "logstore", lg, &hashKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if request.URL.Path != "/logstores/logstore/shards/route" || request.URL.Query().Get("key") != hashKey {
+		t.Errorf("unexpected request url %v", request.URL)
+	}
+	if got := request.Header.Get("x-log-compresstype"); got != CompressionLZ4 {
+		t.Errorf("compress type = %q, want lz4", got)
+	}
+	rawSize, err := strconv.Atoi(request.Header.Get("x-log-bodyrawsize"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := make([]byte, rawSize)
+	n, err := lz4.UncompressBlock(body, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &slsSdk.LogGroup{}
+	if err := got.Unmarshal(raw[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(got, lg) {
+		t.Errorf("sent %v, want %v", got, lg)
+	}
+}
+
+func TestLogGroupSenderStopsRetryingWhenContextIsDone(t *testing.T) {
+	fake := newFakeSLS(t)
+	fake.putStatus = http.StatusServiceUnavailable
+	project, err := slsSdk.NewLogProject("project", fake.endpoint(), "id", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := &logGroupSender{project: project, compression: CompressionNone}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := sender.send(ctx, "logstore", routerTestLogGroup(1), nil); !isServerError(err) {
+		t.Errorf("returned %v, want the server error", err)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("retried for %v after the context was done", waited)
+	}
+	if fake.putCount() < 2 {
+		t.Errorf("sent %d requests, want the server error retried until the context is done", fake.putCount())
+	}
+}
+
+func TestLogGroupSenderIsSharedByWriters(t *testing.T) {
+	fake := newFakeSLS(t)
+	plugin := fake.plugin(WithShardRouting(true))
+	defer plugin.Close()
+
+	sender := plugin.buildSpanWriter().sender
+	if sender == nil || plugin.buildSpanWriter().sender != sender || plugin.router.sender != sender {
+		t.Error("the writers and the shard router should share the sender built by the plugin")
+	}
+	if other := plugin.retarget(FederationTarget{Project: "other", Instance: "instance"}); other.sender != nil {
+		t.Error("a retargeted plugin should not send to the project of the plugin")
+	}
+}
@@ -0,0 +1,282 @@
+package sls_store
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+// CacheConfig the configuration of the query side result cache
+type CacheConfig struct {
+	Enabled bool
+	// TTL the expiration of cached services and operations
+	TTL time.Duration
+	// RefreshInterval the interval of refreshing cached services and operations in background
+	RefreshInterval time.Duration
+	// MaxOperations the max number of cached operation lists, 0 disables caching operations
+	MaxOperations int
+	// MaxTraces the max number of cached traces, 0 disables caching traces
+	MaxTraces int
+	// TraceTTL the expiration of cached traces
+	TraceTTL time.Duration
+	// MinTraceAge traces whose newest span is younger than it are not cached, they may still receive spans
+	MinTraceAge time.Duration
+}
+
+// queryCache caches the results of GetServices, GetOperations and GetTrace.
+type queryCache struct {
+	config     CacheConfig
+	reader     spanstore.Reader
+	logger     hclog.Logger
+	services   *lruCache
+	operations *lruCache
+	traces     *lruCache
+	stop       chan struct{}
+	stopped    chan struct{}
+	closeOnce  sync.Once
+}
+
+func newQueryCache(config CacheConfig, reader spanstore.Reader, logger hclog.Logger) *queryCache {
+	c := &queryCache{
+		config:     config,
+		reader:     reader,
+		logger:     logger,
+		services:   newLRUCache(1, config.TTL),
+		operations: newLRUCache(config.MaxOperations, config.TTL),
+		traces:     newLRUCache(config.MaxTraces, config.TraceTTL),
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	if config.RefreshInterval > 0 {
+		go c.refreshLoop()
+	} else {
+		close(c.stopped)
+	}
+
+	return c
+}
+
+func (c *queryCache) refreshLoop() {
+	defer close(c.stopped)
+	ticker := time.NewTicker(c.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// close stops the background refresh and waits for a running one.
+func (c *queryCache) close() {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.stopped
+}
+
+// refresh reloads the cached service and operation lists, so that the dropdowns of jaeger ui never wait for SLS.
+func (c *queryCache) refresh() {
+	defer func() {
+		if err := recover(); err != nil {
+			c.logger.Error("Failed to refresh query cache", "Exception", err)
+		}
+	}()
+
+	ctx := context.Background()
+	if services, err := c.reader.GetServices(ctx); err == nil {
+		c.services.put("", services)
+	} else {
+		c.logger.Warn("Failed to refresh services", "Exception", err)
+	}
+
+	for _, key := range c.operations.keys() {
+		query := key.(spanstore.OperationQueryParameters)
+		if operations, err := c.reader.GetOperations(ctx, query); err == nil {
+			c.operations.put(query, operations)
+		} else {
+			c.logger.Warn("Failed to refresh operations", "Service", query.ServiceName, "Exception", err)
+		}
+	}
+	incCounter("cache_refreshes", 1)
+}
+
+// cachingSpanReader a span reader serves services, operations and completed traces from the query cache.
+type cachingSpanReader struct {
+	spanstore.Reader
+	cache *queryCache
+}
+
+func (s cachingSpanReader) GetServices(ctx context.Context) ([]string, error) {
+	if v, ok := s.cache.services.get(""); ok {
+		incCounter("cache_hits_services", 1)
+		return v.([]string), nil
+	}
+
+	incCounter("cache_misses_services", 1)
+	services, err := s.Reader.GetServices(ctx)
+	if err == nil {
+		s.cache.services.put("", services)
+	}
+	return services, err
+}
+
+func (s cachingSpanReader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
+	if s.cache.config.MaxOperations <= 0 {
+		return s.Reader.GetOperations(ctx, query)
+	}
+
+	if v, ok := s.cache.operations.get(query); ok {
+		incCounter("cache_hits_operations", 1)
+		return v.([]spanstore.Operation), nil
+	}
+
+	incCounter("cache_misses_operations", 1)
+	operations, err := s.Reader.GetOperations(ctx, query)
+	if err == nil {
+		s.cache.operations.put(query, operations)
+	}
+	return operations, err
+}
+
+func (s cachingSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	if s.cache.config.MaxTraces <= 0 {
+		return s.Reader.GetTrace(ctx, traceID)
+	}
+
+	if v, ok := s.cache.traces.get(traceID); ok {
+		if trace, err := copyTrace(v.(*model.Trace)); err == nil {
+			incCounter("cache_hits_traces", 1)
+			return trace, nil
+		}
+	}
+
+	incCounter("cache_misses_traces", 1)
+	trace, err := s.Reader.GetTrace(ctx, traceID)
+	if err == nil && s.cache.isCompleted(trace) {
+		// the cache keeps its own copy, callers may change the trace they get
+		if cached, e := copyTrace(trace); e == nil {
+			s.cache.traces.put(traceID, cached)
+		}
+	}
+	return trace, err
+}
+
+// copyTrace a deep copy of the trace, which shares nothing with it.
+func copyTrace(trace *model.Trace) (*model.Trace, error) {
+	data, err := trace.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	result := &model.Trace{}
+	if err := result.Unmarshal(data); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// isCompleted a trace is treated as completed when its newest span ended MinTraceAge ago. Traces with warnings,
+// such as the ones read by incomplete queries, are never completed.
+func (c *queryCache) isCompleted(trace *model.Trace) bool {
+	if trace == nil || len(trace.Spans) == 0 || len(trace.Warnings) > 0 {
+		return false
+	}
+
+	var newest time.Time
+	for _, span := range trace.Spans {
+		if end := span.StartTime.Add(span.Duration); end.After(newest) {
+			newest = end
+		}
+	}
+
+	return time.Since(newest) >= c.config.MinTraceAge
+}
+
+// lruCache a size limited LRU cache whose entries expire after ttl.
+type lruCache struct {
+	lock     sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[interface{}]*list.Element
+	order    *list.List
+}
+
+type lruEntry struct {
+	key      interface{}
+	value    interface{}
+	expireAt time.Time
+}
+
+func newLRUCache(capacity int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[interface{}]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *lruCache) get(key interface{}) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*lruEntry)
+	if time.Now().After(entry.expireAt) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *lruCache) put(key, value interface{}) {
+	if c.capacity <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	expireAt := time.Now().Add(c.ttl)
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.expireAt = expireAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expireAt: expireAt})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) keys() []interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keys := make([]interface{}, 0, len(c.items))
+	for key := range c.items {
+		keys = append(keys, key)
+	}
+	return keys
+}
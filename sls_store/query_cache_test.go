@@ -0,0 +1,145 @@
+package sls_store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestLRUCacheEviction(t *testing.T) {
+	cache := newLRUCache(2, time.Hour)
+	cache.put("a", 1)
+	cache.put("b", 2)
+	// reading a makes b the least recently used entry
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	cache.put("c", 3)
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	for _, test := range tests {
+		if _, ok := cache.get(test.key); ok != test.want {
+			t.Errorf("get(%s) cached = %v, want %v", test.key, ok, test.want)
+		}
+	}
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	cache := newLRUCache(1, 10*time.Millisecond)
+	cache.put("a", 1)
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("a should be cached before the ttl")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := cache.get("a"); ok {
+		t.Error("a should expire after the ttl")
+	}
+	if len(cache.keys()) != 0 {
+		t.Error("the expired entry should be removed")
+	}
+}
+
+func TestLRUCacheDisabled(t *testing.T) {
+	cache := newLRUCache(0, time.Hour)
+	cache.put("a", 1)
+	if _, ok := cache.get("a"); ok {
+		t.Error("a cache of capacity 0 should not keep entries")
+	}
+}
+
+func TestIsCompleted(t *testing.T) {
+	cache := &queryCache{config: CacheConfig{MinTraceAge: time.Minute}}
+	old := &model.Span{StartTime: time.Now().Add(-time.Hour), Duration: time.Second}
+	recent := &model.Span{StartTime: time.Now().Add(-time.Hour), Duration: time.Hour}
+
+	tests := []struct {
+		name  string
+		trace *model.Trace
+		want  bool
+	}{
+		{"nil", nil, false},
+		{"no spans", &model.Trace{}, false},
+		{"old spans", &model.Trace{Spans: []*model.Span{old}}, true},
+		{"a span ended recently", &model.Trace{Spans: []*model.Span{old, recent}}, false},
+		{"warnings", &model.Trace{Spans: []*model.Span{old}, Warnings: []string{"incomplete"}}, false},
+	}
+	for _, test := range tests {
+		if got := cache.isCompleted(test.trace); got != test.want {
+			t.Errorf("%s: isCompleted = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+type countingTraceReader struct {
+	spanstore.Reader
+	calls int
+}
+
+func (r *countingTraceReader) GetServices(ctx context.Context) ([]string, error) {
+	return []string{"service"}, nil
+}
+
+func (r *countingTraceReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	r.calls++
+	return &model.Trace{Spans: []*model.Span{{
+		TraceID:       traceID,
+		OperationName: "op",
+		StartTime:     time.Now().Add(-time.Hour),
+		Process:       &model.Process{ServiceName: "service"},
+	}}}, nil
+}
+
+func TestCachingSpanReaderGetTraceReturnsCopies(t *testing.T) {
+	inner := &countingTraceReader{}
+	cache := newQueryCache(CacheConfig{MaxTraces: 1, TraceTTL: time.Hour}, inner, logger)
+	defer cache.close()
+	reader := cachingSpanReader{Reader: inner, cache: cache}
+	traceID := model.NewTraceID(1, 2)
+
+	first, err := reader.GetTrace(context.Background(), traceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Spans[0].OperationName = "changed"
+
+	for i := 0; i < 2; i++ {
+		trace, err := reader.GetTrace(context.Background(), traceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trace.Spans[0].OperationName != "op" {
+			t.Errorf("the cached trace was changed by a caller: %s", trace.Spans[0].OperationName)
+		}
+		trace.Spans[0].OperationName = "changed"
+	}
+	if inner.calls != 1 {
+		t.Errorf("the reader was called %d times, want 1", inner.calls)
+	}
+}
+
+func TestQueryCacheCloseStopsRefresh(t *testing.T) {
+	cache := newQueryCache(CacheConfig{RefreshInterval: time.Millisecond}, &countingTraceReader{}, logger)
+	done := make(chan struct{})
+	go func() {
+		cache.close()
+		cache.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("close did not stop the refresh loop")
+	}
+}
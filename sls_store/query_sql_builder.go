@@ -2,6 +2,8 @@ package sls_store
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jaegertracing/jaeger/model"
@@ -16,45 +18,188 @@ func toGetServicesQuery() string {
 	return GetServiceQueryString
 }
 
-var queryBuilder = QueryBuilder{
-	query:   "*",
-	analyze: " select * from log where 1=1 ",
+// toOperationsQuery builds the query of one page of distinct operations, ordered so that pages are stable.
+func toOperationsQuery(parameters spanstore.OperationQueryParameters, offset, limit int) string {
+	return QueryBuilder{
+		query:   "*",
+		analyze: "select distinct name, kind from log",
+	}.withSpanKind(parameters.SpanKind).
+		withServiceName(parameters.ServiceName).
+		withOrderBy("name, kind").
+		withPage(offset, limit).
+		toString()
 }
 
-func toOperationsQuery(parameters spanstore.OperationQueryParameters) string {
-	return queryBuilder.withSpanKind(parameters.SpanKind).withServiceName(parameters.ServiceName).toString()
-}
+// toFindTraceIdsQuery builds the query of one page of trace ids, the newest traces come first unless the
+// duration order is asked.
+func toFindTraceIdsQuery(parameters *spanstore.TraceQueryParameters, options TraceSearchOptions, offset, limit int) string {
+	if options.filtersTraceDuration(parameters) {
+		return toTraceDurationQuery(parameters, options, offset, limit)
+	}
 
-func toFindTraceIdsQuery(parameters *spanstore.TraceQueryParameters) string {
-	return QueryBuilder{
+	builder := QueryBuilder{
 		query:   "*",
-		analyze: "select traceid from log where 1=1 ",
-	}.withTags(parameters.Tags).
-		withDuration(parameters.DurationMin, parameters.DurationMax).
+		analyze: "select traceid, max(start) as latest, max(duration) as longest from log where 1=1 ",
+	}.withTags(parameters.Tags)
+	if options.RootOnly {
+		builder = builder.withRootSpan()
+	}
+
+	return builder.withDuration(parameters.DurationMin, parameters.DurationMax).
 		withServiceName(parameters.ServiceName).
 		withOperationName(parameters.OperationName).
 		withGroupByTraceID().
-		withLimit(parameters.NumTraces).
+		withOrderBy(options.orderBy()).
+		withPage(offset, limit).
 		toString()
 }
 
+// toTraceDurationQuery builds the query of one page of trace ids whose root span duration or end to end duration
+// is in the range. A search statement would hide the other spans of the traces from the durations, so the
+// conditions are evaluated by a subquery selecting the matching traces, and the durations over all their spans.
+func toTraceDurationQuery(parameters *spanstore.TraceQueryParameters, options TraceSearchOptions, offset, limit int) string {
+	matching := QueryBuilder{analyze: "select traceid from log where 1=1 "}.withSQLTags(parameters.Tags)
+	if options.RootOnly {
+		matching = matching.withRootSpan()
+	}
+	if parameters.ServiceName != "" {
+		matching.analyze += fmt.Sprintf(" and service = %s", quoteSQLString(parameters.ServiceName))
+	}
+	matching = *matching.withOperationName(parameters.OperationName)
+
+	duration := `max("end") - min(start)`
+	if options.DurationMode == DurationModeRoot {
+		duration = fmt.Sprintf("max(if(%s, duration, 0))", rootSpanCondition)
+	}
+
+	builder := QueryBuilder{
+		query: "*",
+		analyze: fmt.Sprintf("select traceid, max(start) as latest, max(duration) as longest from log where traceid in (%s) "+
+			"group by traceid having 1=1", matching.analyze),
+	}
+	if parameters.DurationMin > 0 {
+		builder.analyze += fmt.Sprintf(" and %s >= %d", duration, parameters.DurationMin.Nanoseconds()/1000)
+	}
+	if parameters.DurationMax > 0 {
+		builder.analyze += fmt.Sprintf(" and %s <= %d", duration, parameters.DurationMax.Nanoseconds()/1000)
+	}
+
+	return builder.withOrderBy(options.orderBy()).
+		withPage(offset, limit).
+		toString()
+}
+
+// TraceSearchOptions the options of FindTraces, the defaults come from configuration and can be overridden per
+// query by reserved tags.
+type TraceSearchOptions struct {
+	// Order the order of results, recency or duration
+	Order string
+	// DurationMode the duration compared with the duration filter, span, root or trace
+	DurationMode string
+	// RootOnly applies the service, operation and tag filters to the root span only
+	RootOnly bool
+	// LinkedTraces the max traces returned after each found trace which its spans link to, 0 returns none
+	LinkedTraces int
+	// ServiceTopic the spans are written under the topic of their service, so service scoped queries only read it
+	ServiceTopic bool
+	// ServiceTopicSince when the service topic was enabled, spans written before it are under the empty topic.
+	// Service scoped queries only read the topic of the service when it is set and the query starts after it.
+	ServiceTopicSince time.Time
+}
+
+// topic the topic of the query starting at from in seconds, the default topic reads every topic.
+func (o TraceSearchOptions) topic(service string, from int64) string {
+	if o.ServiceTopic && service != "" && !o.ServiceTopicSince.IsZero() && from >= o.ServiceTopicSince.Unix() {
+		return service
+	}
+	return DefaultTopicName
+}
+
+// filtersTraceDuration whether the duration filter of the query compares the durations of whole traces.
+func (o TraceSearchOptions) filtersTraceDuration(parameters *spanstore.TraceQueryParameters) bool {
+	if parameters.DurationMin <= 0 && parameters.DurationMax <= 0 {
+		return false
+	}
+
+	switch o.DurationMode {
+	case DurationModeTrace:
+		return true
+	case DurationModeRoot:
+		return !o.RootOnly
+	default:
+		return false
+	}
+}
+
+func (o TraceSearchOptions) orderBy() string {
+	if o.Order == TraceOrderDuration {
+		return "longest desc"
+	}
+	return "latest desc"
+}
+
+// splitSearchOptions removes the reserved tags from the query tags and returns the options of the query.
+func splitSearchOptions(parameters *spanstore.TraceQueryParameters, defaults TraceSearchOptions) (*spanstore.TraceQueryParameters, TraceSearchOptions) {
+	options := defaults
+	if options.Order == "" {
+		options.Order = TraceOrderRecency
+	}
+	if options.DurationMode == "" {
+		options.DurationMode = DurationModeSpan
+	}
+
+	query := *parameters
+	query.Tags = make(map[string]string, len(parameters.Tags))
+	for k, v := range parameters.Tags {
+		switch k {
+		case OrderTagKey:
+			options.Order = v
+		case DurationModeTagKey:
+			options.DurationMode = v
+		case RootOnlyTagKey:
+			options.RootOnly = v == "true"
+		case LinkedTracesTagKey:
+			options.LinkedTraces, _ = strconv.Atoi(v)
+		default:
+			query.Tags[k] = v
+		}
+	}
+
+	return &query, options
+}
+
 type QueryBuilder struct {
 	query   string
 	analyze string
 }
 
-func (o QueryBuilder) withLimit(p int) *QueryBuilder {
-	o.analyze += fmt.Sprintf(" limit %d", p)
+func (o QueryBuilder) withPage(offset, limit int) *QueryBuilder {
+	o.analyze += fmt.Sprintf(" limit %d, %d", offset, limit)
+	return &o
+}
+
+func (o QueryBuilder) withOrderBy(p string) *QueryBuilder {
+	o.analyze += fmt.Sprintf(" order by %s", p)
 	return &o
 }
+
 func (o QueryBuilder) withGroupByTraceID() *QueryBuilder {
 	o.analyze += " group by traceid"
 	return &o
 }
 
+// rootSpanCondition matches root spans, spans written by other producers may have an empty parent span id.
+const rootSpanCondition = "(parentspanid = '' or parentspanid = '0' or parentspanid = '0000000000000000')"
+
+// withRootSpan keeps root spans only.
+func (o QueryBuilder) withRootSpan() QueryBuilder {
+	o.analyze += " and " + rootSpanCondition
+	return o
+}
+
 func (o QueryBuilder) withSpanKind(p string) *QueryBuilder {
 	if p != "" {
-		o.query += fmt.Sprintf(" and kind: %s", p)
+		o.query += fmt.Sprintf(" and kind: %s", quoteQueryValue(p))
 	}
 
 	return &o
@@ -62,7 +207,7 @@ func (o QueryBuilder) withSpanKind(p string) *QueryBuilder {
 
 func (o QueryBuilder) withServiceName(p string) *QueryBuilder {
 	if p != "" {
-		o.query += fmt.Sprintf(" and service: %s", p)
+		o.query += fmt.Sprintf(" and service: %s", quoteQueryValue(p))
 	}
 
 	return &o
@@ -70,19 +215,44 @@ func (o QueryBuilder) withServiceName(p string) *QueryBuilder {
 
 func (o QueryBuilder) withOperationName(p string) *QueryBuilder {
 	if p != "" {
-		o.analyze += fmt.Sprintf(" and name like '%s'", p)
+		o.analyze += fmt.Sprintf(" and name = %s", quoteSQLString(p))
 	}
 
 	return &o
 }
 
+// withTags adds the tag conditions, see tag_query.go for the grammar. Invalid conditions are skipped.
 func (o QueryBuilder) withTags(p map[string]string) QueryBuilder {
 	if len(p) == 0 {
 		return o
 	}
 
 	for key, value := range p {
-		o.query += fmt.Sprintf(" and attribute.%s: %s", key, value)
+		condition, err := parseTagCondition(key, value)
+		if err != nil {
+			logger.Warn("Invalid tag condition", "key", key, "value", value, "exception", err)
+			continue
+		}
+
+		if condition.scope == tagScopeLog || condition.isNumeric() {
+			o.analyze += " and " + condition.toSQL()
+		} else {
+			o.query += " and " + condition.toSearch()
+		}
+	}
+
+	return o
+}
+
+// withSQLTags adds the tag conditions to the where clause only, for the queries without search statement.
+func (o QueryBuilder) withSQLTags(p map[string]string) QueryBuilder {
+	for key, value := range p {
+		condition, err := parseTagCondition(key, value)
+		if err != nil {
+			logger.Warn("Invalid tag condition", "key", key, "value", value, "exception", err)
+			continue
+		}
+		o.analyze += " and " + condition.toSQLFilter()
 	}
 
 	return o
@@ -103,3 +273,9 @@ func (o QueryBuilder) withDuration(min, max time.Duration) QueryBuilder {
 func (o QueryBuilder) toString() string {
 	return fmt.Sprintf("%s | %s", o.query, o.analyze)
 }
+
+// quoteQueryValue quotes a value of the search statement, so that values containing spaces or operators still
+// match as a whole.
+func quoteQueryValue(v string) string {
+	return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+}
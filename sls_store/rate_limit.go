@@ -0,0 +1,158 @@
+package sls_store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+// RateLimit the request rates of one logstore in requests per second, 0 is unlimited
+type RateLimit struct {
+	Reads  float64 `mapstructure:"reads"`
+	Writes float64 `mapstructure:"writes"`
+	// Burst the requests allowed at once, 1 when empty
+	Burst float64 `mapstructure:"burst"`
+}
+
+// RateLimitConfig the client side rate limits of SLS requests and the retries of throttled requests
+type RateLimitConfig struct {
+	// Default the rate limit of the logstores not in LogStores
+	Default   RateLimit
+	LogStores map[string]RateLimit
+	// ThrottleRetries the max retries of a request SLS throttled, with exponential backoff and jitter
+	ThrottleRetries int
+}
+
+// DefaultRateLimitConfig no rate limits, throttled requests are retried DefaultThrottleRetries times
+var DefaultRateLimitConfig = RateLimitConfig{
+	ThrottleRetries: DefaultThrottleRetries,
+}
+
+// rateLimiter keeps a read and a write token bucket per project and logstore. Requests wait for a token instead of
+// failing, so that bursts are smoothed under the quota of the project.
+type rateLimiter struct {
+	lock   sync.Mutex
+	config RateLimitConfig
+	reads  map[string]*tokenBucket
+	writes map[string]*tokenBucket
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		config: config,
+		reads:  make(map[string]*tokenBucket),
+		writes: make(map[string]*tokenBucket),
+	}
+}
+
+func (l *rateLimiter) limit(logstore string) RateLimit {
+	if limit, ok := l.config.LogStores[logstore]; ok {
+		return limit
+	}
+	return l.config.Default
+}
+
+// bucket returns the bucket of the logstore, nil when it is unlimited.
+func (l *rateLimiter) bucket(buckets map[string]*tokenBucket, project, logstore string, rate func(RateLimit) float64) *tokenBucket {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	key := project + "/" + logstore
+	if bucket, ok := buckets[key]; ok {
+		return bucket
+	}
+
+	var bucket *tokenBucket
+	if limit := l.limit(logstore); rate(limit) > 0 {
+		bucket = newTokenBucket(rate(limit), limit.Burst)
+	}
+	buckets[key] = bucket
+	return bucket
+}
+
+// waitRead waits for the read rate limit of the logstore.
+func (l *rateLimiter) waitRead(ctx context.Context, project, logstore string) error {
+	if l == nil {
+		return nil
+	}
+	return wait(ctx, l.bucket(l.reads, project, logstore, func(limit RateLimit) float64 { return limit.Reads }), "reads")
+}
+
+// waitWrite waits for the write rate limit of the logstore.
+func (l *rateLimiter) waitWrite(ctx context.Context, project, logstore string) error {
+	if l == nil {
+		return nil
+	}
+	return wait(ctx, l.bucket(l.writes, project, logstore, func(limit RateLimit) float64 { return limit.Writes }), "writes")
+}
+
+func wait(ctx context.Context, bucket *tokenBucket, kind string) error {
+	if bucket == nil {
+		return nil
+	}
+
+	waited, err := bucket.wait(ctx, 1)
+	if waited > 0 {
+		incCounter("rate_limited_"+kind, 1)
+		incCounter("rate_limit_wait_ms", waited.Milliseconds())
+	}
+	return err
+}
+
+func (l *rateLimiter) throttleRetries() int {
+	if l == nil {
+		return DefaultThrottleRetries
+	}
+	return l.config.ThrottleRetries
+}
+
+// isThrottleError returns whether SLS rejected the request by the quota of the project or shard.
+func isThrottleError(err error) bool {
+	var slsErr *slsSdk.Error
+	if !errors.As(err, &slsErr) {
+		return false
+	}
+
+	switch slsErr.Code {
+	case slsSdk.WRITE_QUOTA_EXCEED, slsSdk.SHARD_WRITE_QUOTA_EXCEED, slsSdk.READ_QUOTA_EXCEED,
+		slsSdk.SHARD_READ_QUOTA_EXCEED, slsSdk.PROJECT_QUOTA_EXCEED, slsSdk.SERVER_BUSY:
+		return true
+	}
+	return slsErr.HTTPCode == http.StatusTooManyRequests
+}
+
+// throttleBackoff the wait before the retry of a throttled request, an exponential backoff with full jitter.
+func throttleBackoff(attempt int) time.Duration {
+	backoff := ThrottleMaxBackoff
+	if attempt < 30 {
+		if exp := ThrottleMinBackoff << uint(attempt); exp < backoff {
+			backoff = exp
+		}
+	}
+	return time.Duration(randomFraction() * float64(backoff))
+}
+
+// retryThrottled calls the request, and retries it with backoff while SLS throttles it.
+func retryThrottled(ctx context.Context, retries int, kind string, request func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := request()
+		if err == nil || !isThrottleError(err) {
+			return err
+		}
+
+		incCounter("throttled_"+kind, 1)
+		if attempt >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(throttleBackoff(attempt)):
+		}
+	}
+}
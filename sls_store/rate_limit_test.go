@@ -0,0 +1,154 @@
+package sls_store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+)
+
+func TestIsThrottleError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"write quota", &slsSdk.Error{HTTPCode: http.StatusForbidden, Code: slsSdk.WRITE_QUOTA_EXCEED}, true},
+		{"shard read quota", &slsSdk.Error{HTTPCode: http.StatusForbidden, Code: slsSdk.SHARD_READ_QUOTA_EXCEED}, true},
+		{"server busy", &slsSdk.Error{HTTPCode: http.StatusServiceUnavailable, Code: slsSdk.SERVER_BUSY}, true},
+		{"too many requests", &slsSdk.Error{HTTPCode: http.StatusTooManyRequests}, true},
+		{"unauthorized", &slsSdk.Error{HTTPCode: http.StatusUnauthorized, Code: "Unauthorized"}, false},
+		{"network error", errors.New("connection refused"), false},
+	}
+
+	for _, test := range tests {
+		if got := isThrottleError(test.err); got != test.want {
+			t.Errorf("%s: isThrottleError = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	for attempt := 0; attempt < 40; attempt++ {
+		max := ThrottleMaxBackoff
+		if attempt < 30 && ThrottleMinBackoff<<uint(attempt) < max {
+			max = ThrottleMinBackoff << uint(attempt)
+		}
+		if backoff := throttleBackoff(attempt); backoff < 0 || backoff > max {
+			t.Errorf("backoff of attempt %d = %v, want at most %v", attempt, backoff, max)
+		}
+	}
+}
+
+func TestRetryThrottled(t *testing.T) {
+	throttled := &slsSdk.Error{HTTPCode: http.StatusTooManyRequests}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", context.Background(), nil, 1, false},
+		{"throttled then success", context.Background(), []error{throttled, throttled}, 3, false},
+		{"retries exhausted", context.Background(), []error{throttled, throttled, throttled, throttled}, 3, true},
+		{"other errors are not retried", context.Background(), []error{errors.New("unavailable")}, 1, true},
+		{"done context", canceled, []error{throttled, throttled}, 1, true},
+	}
+
+	for _, test := range tests {
+		calls := 0
+		err := retryThrottled(test.ctx, 2, "writes", func() error {
+			calls++
+			if calls <= len(test.errs) {
+				return test.errs[calls-1]
+			}
+			return nil
+		})
+		if calls != test.wantCalls || (err != nil) != test.wantErr {
+			t.Errorf("%s: %d calls and error %v, want %d calls", test.name, calls, err, test.wantCalls)
+		}
+	}
+}
+
+func TestRateLimiterBuckets(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{
+		Default:   RateLimit{Writes: 10},
+		LogStores: map[string]RateLimit{"unlimited": {}},
+	})
+
+	if bucket := limiter.bucket(limiter.writes, "project", "unlimited", func(l RateLimit) float64 { return l.Writes }); bucket != nil {
+		t.Error("the logstore without a rate should be unlimited")
+	}
+	a := limiter.bucket(limiter.writes, "project", "logstore", func(l RateLimit) float64 { return l.Writes })
+	b := limiter.bucket(limiter.writes, "other", "logstore", func(l RateLimit) float64 { return l.Writes })
+	if a == nil || a == b {
+		t.Error("every project and logstore should have its own bucket with the default rate")
+	}
+	if a != limiter.bucket(limiter.writes, "project", "logstore", func(l RateLimit) float64 { return l.Writes }) {
+		t.Error("the bucket of a logstore should be kept")
+	}
+	if limiter.bucket(limiter.reads, "project", "logstore", func(l RateLimit) float64 { return l.Reads }) != nil {
+		t.Error("the reads should not be limited by the write rate")
+	}
+}
+
+func TestSendLogGroupWaitsForWriteRateLimit(t *testing.T) {
+	fake := newFakeSLS(t)
+	plugin := fake.plugin(WithRateLimits(RateLimitConfig{Default: RateLimit{Writes: 0.1, Burst: 1}}))
+	defer plugin.Close()
+	writer := plugin.buildSpanWriter()
+
+	if err := writer.sendLogGroup(context.Background(), routerTestLogGroup(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next write waits for a token until the context of the call is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := writer.sendLogGroup(ctx, routerTestLogGroup(1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("the rate limited write returned %v, want the error of the context", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("the rate limited write waited %v after its context was done", waited)
+	}
+	if fake.putCount() != 1 {
+		t.Errorf("sent %d requests, want the rate limited one not sent", fake.putCount())
+	}
+}
+
+func TestSendLogGroupRetriesThrottledWrites(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantPuts int
+	}{
+		{"retries", context.Background(), 3},
+		{"done context", canceled, 1},
+	}
+
+	for _, test := range tests {
+		fake := newFakeSLS(t)
+		fake.putStatus = http.StatusTooManyRequests
+		plugin := fake.plugin(WithRateLimits(RateLimitConfig{ThrottleRetries: 2}))
+		err := plugin.buildSpanWriter().sendLogGroup(test.ctx, routerTestLogGroup(1))
+		plugin.Close()
+
+		if !isThrottleError(err) {
+			t.Errorf("%s: returned %v, want the throttle error", test.name, err)
+		}
+		if fake.putCount() != test.wantPuts {
+			t.Errorf("%s: sent %d requests, want %d", test.name, fake.putCount(), test.wantPuts)
+		}
+	}
+}
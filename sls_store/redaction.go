@@ -0,0 +1,212 @@
+package sls_store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+
+	"github.com/jaegertracing/jaeger/model"
+	commonV1 "go.opentelemetry.io/proto/otlp/common/v1"
+	traceV1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// redaction actions
+const (
+	// RedactionHash replaces the value with its salted sha256 hash
+	RedactionHash = "hash"
+	// RedactionMask replaces the value with RedactionMaskValue
+	RedactionMask = "mask"
+	// RedactionDrop removes the tag
+	RedactionDrop = "drop"
+	// RedactionMaskValue the value written in place of masked values
+	RedactionMaskValue = "****"
+)
+
+// RedactionRule one rule of the redaction pipeline. A rule matches a tag when all of its non empty matchers match.
+// When ValueRegex is set, hash and mask only replace the matched parts of the value.
+type RedactionRule struct {
+	Name       string `mapstructure:"name"`
+	Key        string `mapstructure:"key"`
+	KeyGlob    string `mapstructure:"keyGlob"`
+	ValueRegex string `mapstructure:"valueRegex"`
+	Action     string `mapstructure:"action"`
+}
+
+type compiledRedactionRule struct {
+	RedactionRule
+	valueRegex *regexp.Regexp
+}
+
+// Redactor scrubs span tags, process tags and log fields before spans are written to SLS.
+type Redactor struct {
+	rules []compiledRedactionRule
+	salt  string
+}
+
+// NewRedactor validates and compiles the redaction rules.
+func NewRedactor(rules []RedactionRule, salt string) (*Redactor, error) {
+	r := &Redactor{salt: salt}
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule_%d", i)
+		}
+
+		switch rule.Action {
+		case RedactionHash, RedactionMask, RedactionDrop:
+		default:
+			return nil, fmt.Errorf("unknown action %q of redaction rule %s", rule.Action, rule.Name)
+		}
+
+		if rule.Key == "" && rule.KeyGlob == "" && rule.ValueRegex == "" {
+			return nil, fmt.Errorf("redaction rule %s matches nothing", rule.Name)
+		}
+
+		if _, err := path.Match(rule.KeyGlob, ""); err != nil {
+			return nil, fmt.Errorf("invalid key glob of redaction rule %s: %w", rule.Name, err)
+		}
+
+		compiled := compiledRedactionRule{RedactionRule: rule}
+		if rule.ValueRegex != "" {
+			regex, err := regexp.Compile(rule.ValueRegex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value regex of redaction rule %s: %w", rule.Name, err)
+			}
+			compiled.valueRegex = regex
+		}
+		r.rules = append(r.rules, compiled)
+	}
+
+	return r, nil
+}
+
+// RedactSpan scrubs the span in place.
+func (r *Redactor) RedactSpan(span *model.Span) {
+	if r == nil || len(r.rules) == 0 {
+		return
+	}
+
+	span.Tags = r.redactKeyValues(span.Tags)
+	if span.Process != nil {
+		span.Process.Tags = r.redactKeyValues(span.Process.Tags)
+	}
+	for i := range span.Logs {
+		span.Logs[i].Fields = r.redactKeyValues(span.Logs[i].Fields)
+	}
+}
+
+// RedactOTLP scrubs resource attributes, span attributes, event attributes and link attributes of OTLP spans in place.
+func (r *Redactor) RedactOTLP(resourceSpans []*traceV1.ResourceSpans) {
+	if r == nil || len(r.rules) == 0 {
+		return
+	}
+
+	for _, rs := range resourceSpans {
+		if rs.Resource != nil {
+			rs.Resource.Attributes = r.redactOTLPAttributes(rs.Resource.Attributes)
+		}
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, span := range ils.Spans {
+				span.Attributes = r.redactOTLPAttributes(span.Attributes)
+				for _, event := range span.Events {
+					event.Attributes = r.redactOTLPAttributes(event.Attributes)
+				}
+				for _, link := range span.Links {
+					link.Attributes = r.redactOTLPAttributes(link.Attributes)
+				}
+			}
+		}
+	}
+}
+
+func (r *Redactor) redactKeyValues(kvs []model.KeyValue) []model.KeyValue {
+	result := kvs[:0]
+	for _, kv := range kvs {
+		value, changed, keep := r.redact(kv.Key, kv.AsString())
+		if !keep {
+			continue
+		}
+		if changed {
+			kv = model.String(kv.Key, value)
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+func (r *Redactor) redactOTLPAttributes(kvs []*commonV1.KeyValue) []*commonV1.KeyValue {
+	result := kvs[:0]
+	for _, kv := range kvs {
+		value, changed, keep := r.redact(kv.GetKey(), otlpValueToString(kv.GetValue()))
+		if !keep {
+			continue
+		}
+		if changed {
+			kv = otlpStringKeyValue(kv.GetKey(), value)
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+func (r *Redactor) redactMap(m map[string]string) {
+	for key, value := range m {
+		redacted, changed, keep := r.redact(key, value)
+		if !keep {
+			delete(m, key)
+		} else if changed {
+			m[key] = redacted
+		}
+	}
+}
+
+// redact applies the rules in order, returning the new value, whether it changed and whether the tag is kept.
+func (r *Redactor) redact(key, value string) (string, bool, bool) {
+	changed := false
+	for _, rule := range r.rules {
+		if !rule.matchKey(key) || (rule.valueRegex != nil && !rule.valueRegex.MatchString(value)) {
+			continue
+		}
+
+		incCounter("redaction_hits_"+rule.Name, 1)
+		switch rule.Action {
+		case RedactionDrop:
+			return "", true, false
+		case RedactionMask:
+			if rule.valueRegex != nil {
+				value = rule.valueRegex.ReplaceAllLiteralString(value, RedactionMaskValue)
+			} else {
+				value = RedactionMaskValue
+			}
+		case RedactionHash:
+			if rule.valueRegex != nil {
+				value = rule.valueRegex.ReplaceAllStringFunc(value, r.hash)
+			} else {
+				value = r.hash(value)
+			}
+		}
+		changed = true
+	}
+
+	return value, changed, true
+}
+
+func (r *Redactor) hash(value string) string {
+	sum := sha256.Sum256([]byte(r.salt + value))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (rule compiledRedactionRule) matchKey(key string) bool {
+	if rule.Key != "" && rule.Key != key {
+		return false
+	}
+
+	if rule.KeyGlob != "" {
+		if matched, _ := path.Match(rule.KeyGlob, key); !matched {
+			return false
+		}
+	}
+
+	return true
+}
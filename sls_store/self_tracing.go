@@ -0,0 +1,351 @@
+package sls_store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+	collectorV1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultSelfTracingServiceName the default service name of the spans of the plugin itself
+const DefaultSelfTracingServiceName = "aliyun-log-jaeger-plugin"
+
+// SelfTracingConfig the configuration of tracing the plugin itself. The spans are exported to the OTLP/gRPC
+// endpoint, or written to the logstore of the configured project when no endpoint is set.
+type SelfTracingConfig struct {
+	Enabled      bool
+	OTLPEndpoint string
+	LogStore     string
+	ServiceName  string
+	// SampleRate the fraction of reader calls traced, from 0 to 1
+	SampleRate float64
+	// WriteSampleRate the fraction of writer calls traced, from 0 to 1. Writes are far more frequent than reads.
+	WriteSampleRate float64
+}
+
+// selfTracer records the spans of the reader and writer calls, the SLS requests and the conversion steps, and
+// exports them in batches. Spans are dropped when the exporter falls behind.
+type selfTracer struct {
+	process         *model.Process
+	sampleRate      float64
+	writeSampleRate float64
+	queue           chan *model.Span
+	export          func(spans []*model.Span) error
+	conn            *grpc.ClientConn
+	logger          hclog.Logger
+	closeOnce       sync.Once
+	stop            chan struct{}
+	stopped         chan struct{}
+}
+
+func newSelfTracer(config SelfTracingConfig, plugin SlsJaegerStoragePlugin) (*selfTracer, error) {
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultSelfTracingServiceName
+	}
+
+	t := &selfTracer{
+		process: model.NewProcess(serviceName, []model.KeyValue{
+			model.String("sls.project", plugin.project),
+			model.String("sls.logstore", plugin.instance.traceLogStore()),
+		}),
+		sampleRate:      config.SampleRate,
+		writeSampleRate: config.WriteSampleRate,
+		queue:           make(chan *model.Span, SelfTracingQueueSize),
+		logger:          plugin.logger,
+		stop:            make(chan struct{}),
+		stopped:         make(chan struct{}),
+	}
+
+	switch {
+	case config.OTLPEndpoint != "":
+		conn, err := grpc.Dial(config.OTLPEndpoint, grpc.WithInsecure())
+		if err != nil {
+			return nil, err
+		}
+		t.conn = conn
+		client := collectorV1.NewTraceServiceClient(conn)
+		t.export = func(spans []*model.Span) error {
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultRequestTimeOut)
+			defer cancel()
+			_, err := client.Export(ctx, &collectorV1.ExportTraceServiceRequest{
+				ResourceSpans: JaegerTraceToOTLP(&model.Trace{Spans: spans}),
+			})
+			return err
+		}
+	case config.LogStore != "":
+		client := buildSLSSdkClient(plugin)
+		converter := &dataConverterImpl{}
+		t.export = func(spans []*model.Span) error {
+			lg := &slsSdk.LogGroup{Topic: proto.String(""), Source: proto.String(DefaultLogGroupTemplate.Source)}
+			for _, span := range spans {
+				logs, err := spanToLog(converter, span)
+				if err != nil {
+					continue
+				}
+				lg.Logs = append(lg.Logs, logs...)
+			}
+			return client.PutLogs(plugin.project, config.LogStore, lg)
+		}
+	default:
+		return nil, errors.New("self tracing needs an OTLP endpoint or a logstore")
+	}
+
+	go t.exportLoop()
+	return t, nil
+}
+
+func (t *selfTracer) exportLoop() {
+	ticker := time.NewTicker(SelfTracingFlushInterval)
+	defer ticker.Stop()
+	defer close(t.stopped)
+
+	batch := make([]*model.Span, 0, SelfTracingBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			incCounter("self_tracing_export_errors", 1)
+			t.logger.Warn("Failed to export self tracing spans", "Spans", len(batch), "Exception", err)
+		}
+		batch = make([]*model.Span, 0, SelfTracingBatchSize)
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= SelfTracingBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.stop:
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+					if len(batch) >= SelfTracingBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// close exports the queued spans and stops the exporter, spans finished later are dropped.
+func (t *selfTracer) close() {
+	if t == nil {
+		return
+	}
+
+	t.closeOnce.Do(func() {
+		close(t.stop)
+		<-t.stopped
+		if t.conn != nil {
+			if err := t.conn.Close(); err != nil {
+				t.logger.Warn("Failed to close the self tracing connection", "Exception", err)
+			}
+		}
+	})
+}
+
+// startSpan starts the span of a reader call. It is the child of the span of the context, or of the caller's span
+// propagated in the incoming gRPC metadata. Nothing is traced when the tracer is nil, the caller's span is not
+// sampled, or the call is not sampled.
+func (t *selfTracer) startSpan(ctx context.Context, operation string) (context.Context, *selfSpan) {
+	if t == nil {
+		return ctx, nil
+	}
+	return t.start(ctx, operation, t.sampleRate)
+}
+
+// startWriteSpan starts the span of a writer call, sampled by the write sample rate.
+func (t *selfTracer) startWriteSpan(ctx context.Context, operation string) (context.Context, *selfSpan) {
+	if t == nil {
+		return ctx, nil
+	}
+	return t.start(ctx, operation, t.writeSampleRate)
+}
+
+func (t *selfTracer) start(ctx context.Context, operation string, sampleRate float64) (context.Context, *selfSpan) {
+	if parent := selfSpanFromContext(ctx); parent != nil {
+		return parent.startChild(ctx, operation)
+	}
+
+	span := &model.Span{
+		SpanID:        randomSpanID(),
+		OperationName: operation,
+		StartTime:     time.Now(),
+		Process:       t.process,
+	}
+	if traceID, spanID, sampled, ok := remoteParent(ctx); ok {
+		if !sampled {
+			return ctx, nil
+		}
+		span.TraceID = traceID
+		span.References = []model.SpanRef{model.NewChildOfRef(traceID, spanID)}
+	} else {
+		if sampleRate < 1 && randomFraction() >= sampleRate {
+			return ctx, nil
+		}
+		span.TraceID = model.NewTraceID(uint64(randomSpanID()), uint64(randomSpanID()))
+	}
+
+	s := &selfSpan{tracer: t, span: span}
+	return context.WithValue(ctx, selfSpanKey{}, s), s
+}
+
+// startSelfSpan starts a child of the span of the context, nothing when the context is not traced.
+func startSelfSpan(ctx context.Context, operation string) (context.Context, *selfSpan) {
+	if parent := selfSpanFromContext(ctx); parent != nil {
+		return parent.startChild(ctx, operation)
+	}
+	return ctx, nil
+}
+
+type selfSpanKey struct{}
+
+func selfSpanFromContext(ctx context.Context) *selfSpan {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(selfSpanKey{}).(*selfSpan)
+	return s
+}
+
+// selfSpan one span of the plugin itself, every method is a no-op on a nil span.
+type selfSpan struct {
+	tracer *selfTracer
+	span   *model.Span
+}
+
+func (s *selfSpan) startChild(ctx context.Context, operation string) (context.Context, *selfSpan) {
+	child := &selfSpan{
+		tracer: s.tracer,
+		span: &model.Span{
+			TraceID:       s.span.TraceID,
+			SpanID:        randomSpanID(),
+			OperationName: operation,
+			References:    []model.SpanRef{model.NewChildOfRef(s.span.TraceID, s.span.SpanID)},
+			StartTime:     time.Now(),
+			Process:       s.tracer.process,
+		},
+	}
+	return context.WithValue(ctx, selfSpanKey{}, child), child
+}
+
+func (s *selfSpan) setTag(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	var tag model.KeyValue
+	switch v := value.(type) {
+	case string:
+		tag = model.String(key, v)
+	case int:
+		tag = model.Int64(key, int64(v))
+	case int64:
+		tag = model.Int64(key, v)
+	case bool:
+		tag = model.Bool(key, v)
+	case time.Time:
+		tag = model.String(key, v.Format(time.RFC3339))
+	default:
+		return
+	}
+	s.span.Tags = append(s.span.Tags, tag)
+}
+
+// setError marks the span as failed, with the request id and error code of SLS errors.
+func (s *selfSpan) setError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.setTag("error", true)
+	s.setTag("error.message", err.Error())
+	var slsErr *slsSdk.Error
+	if errors.As(err, &slsErr) {
+		s.setTag("sls.request_id", slsErr.RequestID)
+		s.setTag("sls.error_code", slsErr.Code)
+	}
+}
+
+func (s *selfSpan) finish() {
+	if s == nil {
+		return
+	}
+
+	s.span.Duration = time.Since(s.span.StartTime)
+	select {
+	case <-s.tracer.stop:
+		incCounter("self_tracing_dropped_spans", 1)
+	case s.tracer.queue <- s.span:
+	default:
+		incCounter("self_tracing_dropped_spans", 1)
+	}
+}
+
+// remoteParent reads the caller's span and its sampled flag from the uber-trace-id or the W3C traceparent gRPC
+// metadata.
+func remoteParent(ctx context.Context) (model.TraceID, model.SpanID, bool, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return model.TraceID{}, 0, false, false
+	}
+
+	if values := md.Get("uber-trace-id"); len(values) > 0 {
+		parts := strings.Split(strings.ReplaceAll(values[0], "%3A", ":"), ":")
+		if len(parts) == 4 {
+			traceID, err1 := model.TraceIDFromString(parts[0])
+			spanID, err2 := model.SpanIDFromString(parts[1])
+			flags, err3 := strconv.ParseUint(parts[3], 16, 8)
+			if err1 == nil && err2 == nil && err3 == nil {
+				return traceID, spanID, flags&1 == 1, true
+			}
+		}
+	}
+
+	if values := md.Get("traceparent"); len(values) > 0 {
+		parts := strings.Split(values[0], "-")
+		if len(parts) == 4 {
+			traceID, err1 := model.TraceIDFromString(parts[1])
+			spanID, err2 := model.SpanIDFromString(parts[2])
+			flags, err3 := strconv.ParseUint(parts[3], 16, 8)
+			if err1 == nil && err2 == nil && err3 == nil {
+				return traceID, spanID, flags&1 == 1, true
+			}
+		}
+	}
+
+	return model.TraceID{}, 0, false, false
+}
+
+func randomSpanID() model.SpanID {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return model.SpanID(binary.BigEndian.Uint64(b[:]) | 1)
+}
+
+func randomFraction() float64 {
+	return float64(uint64(randomSpanID())>>11) / (1 << 53)
+}
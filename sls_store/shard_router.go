@@ -0,0 +1,145 @@
+package sls_store
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/hashicorp/go-hclog"
+)
+
+// shardRouter routes logs to the shards of the trace logstore by the hash of their trace id, so that all spans of
+// a trace are written to the same shard.
+type shardRouter struct {
+	lock      sync.Mutex
+	client    slsSdk.ClientInterface
+	sender    *logGroupSender
+	instance  slsTraceInstance
+	shards    []*slsSdk.Shard
+	refreshed time.Time
+	logger    hclog.Logger
+}
+
+func newShardRouter(client slsSdk.ClientInterface, sender *logGroupSender, instance slsTraceInstance, logger hclog.Logger) *shardRouter {
+	return &shardRouter{
+		client:   client,
+		sender:   sender,
+		instance: instance,
+		logger:   logger,
+	}
+}
+
+// send splits the log group per shard and posts every part with a hash key inside the range of its shard.
+func (r *shardRouter) send(ctx context.Context, lg *slsSdk.LogGroup) error {
+	for hashKey, group := range r.route(lg) {
+		key := hashKey
+		if err := r.sender.send(ctx, r.instance.traceLogStore(), group, &key); err != nil {
+			r.invalidate()
+			return err
+		}
+		incCounter("shard_routed_logs", int64(len(group.Logs)))
+	}
+	return nil
+}
+
+// route groups the logs by the begin key of their shard, or by their own hash key when the shards are unknown.
+func (r *shardRouter) route(lg *slsSdk.LogGroup) map[string]*slsSdk.LogGroup {
+	shards := r.writableShards()
+	groups := make(map[string]*slsSdk.LogGroup)
+	for _, log := range lg.Logs {
+		key := traceHashKey(logTraceID(log))
+		if shard := findShard(shards, key); shard != nil {
+			key = shard.InclusiveBeginKey
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = &slsSdk.LogGroup{
+				Topic:   lg.Topic,
+				Source:  lg.Source,
+				LogTags: lg.LogTags,
+			}
+			groups[key] = group
+		}
+		group.Logs = append(group.Logs, log)
+	}
+	return groups
+}
+
+// writableShards the readwrite shards sorted by their key range, refreshed every ShardRefreshInterval. The shards are
+// listed outside the lock by one caller while the others keep using the known shards.
+func (r *shardRouter) writableShards() []*slsSdk.Shard {
+	r.lock.Lock()
+	known := r.shards
+	if time.Since(r.refreshed) < ShardRefreshInterval {
+		r.lock.Unlock()
+		return known
+	}
+	r.refreshed = time.Now()
+	r.lock.Unlock()
+
+	shards, err := r.client.ListShards(r.instance.project(), r.instance.traceLogStore())
+	if err != nil {
+		r.logger.Warn("Failed to list shards, keeping the known shards", "Exception", err)
+		return known
+	}
+
+	writable := make([]*slsSdk.Shard, 0, len(shards))
+	for _, shard := range shards {
+		if shard.Status == "readwrite" {
+			writable = append(writable, shard)
+		}
+	}
+	sort.Slice(writable, func(i, j int) bool {
+		return writable[i].InclusiveBeginKey < writable[j].InclusiveBeginKey
+	})
+
+	r.lock.Lock()
+	r.shards = writable
+	r.lock.Unlock()
+	setGauge("shard_count", int64(len(writable)))
+	return writable
+}
+
+// invalidate forces the shards to be listed again, a failed post may be caused by a shard split or merge.
+func (r *shardRouter) invalidate() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.refreshed = time.Time{}
+}
+
+// findShard finds the shard whose key range contains the key, keys are compared as fixed length lowercase hex.
+func findShard(shards []*slsSdk.Shard, key string) *slsSdk.Shard {
+	i := sort.Search(len(shards), func(i int) bool {
+		return shards[i].InclusiveBeginKey > key
+	})
+	if i == 0 {
+		return nil
+	}
+
+	// ExclusiveBeginKey holds the exclusive end key of the shard
+	shard := shards[i-1]
+	if key >= shard.ExclusiveBeginKey {
+		return nil
+	}
+	return shard
+}
+
+// traceHashKey the 128 bit hash key of the trace id in SLS format.
+func traceHashKey(traceID string) string {
+	sum := md5.Sum([]byte(traceID))
+	return hex.EncodeToString(sum[:])
+}
+
+func logTraceID(log *slsSdk.Log) string {
+	for _, content := range log.Contents {
+		if content.GetKey() == TraceID {
+			return content.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,173 @@
+package sls_store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+var testShards = []*slsSdk.Shard{
+	{ShardID: 1, Status: "readwrite", InclusiveBeginKey: "80000000000000000000000000000000",
+		ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+	{ShardID: 0, Status: "readwrite", InclusiveBeginKey: "00000000000000000000000000000000",
+		ExclusiveBeginKey: "80000000000000000000000000000000"},
+	{ShardID: 2, Status: "readonly", InclusiveBeginKey: "00000000000000000000000000000000",
+		ExclusiveBeginKey: "ffffffffffffffffffffffffffffffff"},
+}
+
+// listShardsClient answers ListShards with the shards, or fails it with err, and counts the calls.
+type listShardsClient struct {
+	slsSdk.ClientInterface
+	lock   sync.Mutex
+	shards []*slsSdk.Shard
+	err    error
+	calls  int
+}
+
+func (c *listShardsClient) ListShards(project, logstore string) ([]*slsSdk.Shard, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.calls++
+	return c.shards, c.err
+}
+
+func TestFindShard(t *testing.T) {
+	shards := []*slsSdk.Shard{testShards[1], testShards[0]}
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"00000000000000000000000000000000", 0},
+		{"7fffffffffffffffffffffffffffffff", 0},
+		{"80000000000000000000000000000000", 1},
+		{"fffffffffffffffffffffffffffffffe", 1},
+		// the exclusive end key of the last shard
+		{"ffffffffffffffffffffffffffffffff", -1},
+	}
+
+	for _, test := range tests {
+		got := findShard(shards, test.key)
+		if test.want < 0 {
+			if got != nil {
+				t.Errorf("findShard(%s) = shard %d, want none", test.key, got.ShardID)
+			}
+			continue
+		}
+		if got == nil || got.ShardID != test.want {
+			t.Errorf("findShard(%s) = %v, want shard %d", test.key, got, test.want)
+		}
+	}
+}
+
+func routerTestLogGroup(traces int) *slsSdk.LogGroup {
+	lg := &slsSdk.LogGroup{Topic: proto.String("topic"), Source: proto.String("source")}
+	for i := 0; i < traces; i++ {
+		for span := 0; span < 2; span++ {
+			lg.Logs = append(lg.Logs, &slsSdk.Log{
+				Time:     proto.Uint32(1),
+				Contents: []*slsSdk.LogContent{{Key: proto.String(TraceID), Value: proto.String(strconv.Itoa(i))}},
+			})
+		}
+	}
+	return lg
+}
+
+func TestShardRouterRoute(t *testing.T) {
+	client := &listShardsClient{shards: testShards}
+	router := newShardRouter(client, nil, newSlsTraceInstance("project", "instance"), logger)
+
+	groups := router.route(routerTestLogGroup(20))
+	if len(groups) != 2 {
+		t.Fatalf("routed to %d groups, want one per readwrite shard", len(groups))
+	}
+
+	total := 0
+	for key, group := range groups {
+		shard := findShard(router.shards, key)
+		if shard == nil || shard.InclusiveBeginKey != key {
+			t.Errorf("group key %s is not the begin key of a shard", key)
+		}
+		if group.GetTopic() != "topic" || group.GetSource() != "source" {
+			t.Errorf("the topic and source of the log group should be kept")
+		}
+		for _, log := range group.Logs {
+			if findShard(router.shards, traceHashKey(logTraceID(log))) != shard {
+				t.Errorf("trace %s is routed to the wrong shard", logTraceID(log))
+			}
+		}
+		total += len(group.Logs)
+	}
+	if total != 40 {
+		t.Errorf("routed %d logs, want 40", total)
+	}
+}
+
+func TestShardRouterRouteWithoutShards(t *testing.T) {
+	client := &listShardsClient{err: errors.New("unavailable")}
+	router := newShardRouter(client, nil, newSlsTraceInstance("project", "instance"), logger)
+
+	groups := router.route(routerTestLogGroup(3))
+	if len(groups) != 3 {
+		t.Fatalf("routed to %d groups, want one per trace hash key", len(groups))
+	}
+	for key, group := range groups {
+		if key != traceHashKey(logTraceID(group.Logs[0])) || len(group.Logs) != 2 {
+			t.Errorf("group %s should hold the 2 spans of its trace", key)
+		}
+	}
+}
+
+func TestShardRouterRefresh(t *testing.T) {
+	client := &listShardsClient{shards: testShards}
+	router := newShardRouter(client, nil, newSlsTraceInstance("project", "instance"), logger)
+
+	router.writableShards()
+	router.writableShards()
+	if client.calls != 1 {
+		t.Errorf("listed the shards %d times within the refresh interval, want 1", client.calls)
+	}
+
+	// a failed refresh keeps the known shards
+	router.invalidate()
+	client.err = errors.New("unavailable")
+	if shards := router.writableShards(); len(shards) != 2 {
+		t.Errorf("got %d shards after a failed refresh, want the 2 known ones", len(shards))
+	}
+	if client.calls != 2 {
+		t.Errorf("listed the shards %d times after invalidate, want 2", client.calls)
+	}
+	if shards := router.writableShards(); len(shards) != 2 || client.calls != 2 {
+		t.Errorf("a failed refresh should not be retried before the refresh interval")
+	}
+}
+
+func TestShardRouterSend(t *testing.T) {
+	fake := newFakeSLS(t)
+	project, err := slsSdk.NewLogProject("project", fake.endpoint(), "id", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := newSlsTraceInstance("project", "instance")
+	router := newShardRouter(fake.client(), &logGroupSender{project: project, compression: CompressionLZ4}, instance, logger)
+
+	if err := router.send(context.Background(), routerTestLogGroup(20)); err != nil {
+		t.Fatal(err)
+	}
+
+	if logs := fake.logs(instance.traceLogStore()); len(logs) != 40 {
+		t.Errorf("wrote %d logs, want 40", len(logs))
+	}
+	if len(fake.hashKeys) != 2 {
+		t.Fatalf("wrote %d log groups, want one per shard", len(fake.hashKeys))
+	}
+	for _, key := range fake.hashKeys {
+		if key != testShards[0].InclusiveBeginKey && key != testShards[1].InclusiveBeginKey {
+			t.Errorf("hash key %s is not the begin key of a shard", key)
+		}
+	}
+}
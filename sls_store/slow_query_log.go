@@ -0,0 +1,75 @@
+package sls_store
+
+import (
+	"errors"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/hashicorp/go-hclog"
+)
+
+// SlowQueryConfig the configuration of the query log. Every SLS query is logged at Debug level, queries slower
+// than the threshold are logged at Warn level as slow queries.
+type SlowQueryConfig struct {
+	// Threshold the elapsed time from which a query is slow, 0 disables the slow query log
+	Threshold time.Duration
+	// SampleRate the fraction of slow queries logged, from 0 to 1
+	SampleRate float64
+	// QuerySampleRate the fraction of all queries logged at Debug level, from 0 to 1
+	QuerySampleRate float64
+}
+
+// DefaultSlowQueryConfig logs every query slower than DefaultSlowQueryThreshold
+var DefaultSlowQueryConfig = SlowQueryConfig{
+	Threshold:       DefaultSlowQueryThreshold,
+	SampleRate:      1,
+	QuerySampleRate: 1,
+}
+
+// record logs the query with its time range, elapsed time, row count, progress and the request id of failures.
+func (o *queryOptions) record(project, logstore, query string, from, to int64, elapsed time.Duration,
+	response *slsSdk.GetLogsResponse, err error) {
+	slow := o.slowQuery.Threshold > 0 && elapsed >= o.slowQuery.Threshold
+	if slow {
+		incCounter("slow_queries", 1)
+	}
+
+	logSlow := slow && sampled(o.slowQuery.SampleRate)
+	if !logSlow && !(o.logger.IsDebug() && sampled(o.slowQuery.QuerySampleRate)) {
+		return
+	}
+
+	fields := []interface{}{
+		"Query", query,
+		"Project", project,
+		"Logstore", logstore,
+		"StartTime", time.Unix(from, 0),
+		"EndTime", time.Unix(to, 0),
+		"Elapsed", elapsed,
+	}
+	if response != nil {
+		fields = append(fields, "Rows", response.Count, "Progress", response.Progress)
+	}
+	var slsErr *slsSdk.Error
+	if errors.As(err, &slsErr) {
+		fields = append(fields, "RequestID", slsErr.RequestID)
+	}
+	if err != nil {
+		fields = append(fields, "Exception", err)
+	}
+
+	if logSlow {
+		o.logger.Warn("Slow query", fields...)
+	} else {
+		o.logger.Debug("Query", fields...)
+	}
+}
+
+func sampled(rate float64) bool {
+	return rate >= 1 || (rate > 0 && randomFraction() < rate)
+}
+
+// SetLogLevel sets the level of the logger the package uses outside the plugin, such as the exported query helpers.
+func SetLogLevel(level hclog.Level) {
+	logger.SetLevel(level)
+}
@@ -11,9 +11,11 @@ import (
 )
 
 type slsDependencyReader struct {
-	client   *slsSdk.Client
-	instance slsTraceInstance
-	logger   hclog.Logger
+	client       *slsSdk.Client
+	instance     slsTraceInstance
+	logger       hclog.Logger
+	tracer       *selfTracer
+	queryOptions *queryOptions
 }
 
 func (s slsDependencyReader) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
@@ -22,15 +24,18 @@ func (s slsDependencyReader) GetDependencies(ctx context.Context, endTs time.Tim
 			s.logger.Error("Failed to get DependencyLink", "Exception", err)
 		}
 	}()
+	ctx, span := s.tracer.startSpan(s.queryOptions.attach(ctx), "GetDependencies")
+	defer span.finish()
+	ctx, completeness := trackCompleteness(ctx)
 
-	response, error := s.client.GetLogs(s.instance.project(), s.instance.serviceDependencyLogStore(), DefaultTopicName,
-		endTs.Add(-1*lookback).Unix(), endTs.Unix(), DependenciesQueryString, DefaultFetchNumber, DefaultOffset, false)
+	response, error := getLogs(ctx, s.client, s.instance.project(), s.instance.serviceDependencyLogStore(), DefaultTopicName,
+		endTs.Add(-1*lookback).Unix(), endTs.Unix(), DependenciesQueryString, DefaultFetchNumber, DefaultOffset)
 
 	if error != nil {
+		span.setError(error)
 		return nil, error
 	}
 
-	s.logger.Info("GetDependencies", "Query", DependenciesQueryString, "Logstore", s.instance.serviceDependencyLogStore(), "DependencyLinks", response.Count)
 	var result []model.DependencyLink
 	for _, log := range response.Logs {
 		count, _ := strconv.ParseFloat(log["count"], 0)
@@ -44,6 +49,11 @@ func (s slsDependencyReader) GetDependencies(ctx context.Context, endTs time.Tim
 			CallCount: uint64(count),
 		})
 	}
+	span.setTag("rows", len(result))
+	if err := completeness.check(ctx, "GetDependencies"); err != nil {
+		span.setError(err)
+		return nil, err
+	}
 
 	return result, nil
 }
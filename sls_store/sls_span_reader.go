@@ -2,6 +2,8 @@ package sls_store
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 	"time"
 
 	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
@@ -11,10 +13,14 @@ import (
 )
 
 type slsSpanReader struct {
-	client      *slsSdk.Client
-	instance    slsTraceInstance
-	maxLookBack time.Duration
-	logger      hclog.Logger
+	client             *slsSdk.Client
+	instance           slsTraceInstance
+	maxLookBack        time.Duration
+	logger             hclog.Logger
+	operationsLogStore string
+	searchOptions      TraceSearchOptions
+	tracer             *selfTracer
+	queryOptions       *queryOptions
 }
 
 func (s slsSpanReader) GetServices(ctx context.Context) ([]string, error) {
@@ -23,20 +29,30 @@ func (s slsSpanReader) GetServices(ctx context.Context) ([]string, error) {
 			s.logger.Error("Failed to GetServices", "Exception", err)
 		}
 	}()
+	ctx, span := s.tracer.startSpan(s.queryOptions.attach(ctx), "GetServices")
+	defer span.finish()
+	ctx, completeness := trackCompleteness(ctx)
 	from, to := buildSearchingData(s.maxLookBack)
 
-	response, e := s.client.GetLogs(s.instance.project(), s.instance.traceLogStore(), DefaultTopicName, from, to,
-		toGetServicesQuery(), DefaultFetchNumber, DefaultOffset, false)
-
-	s.logger.Info("GetServicesList", "Query", toGetServicesQuery(), "StartTime", time.Unix(from, 0), "EndTime", time.Unix(to, 0), "Logstore", s.instance.traceLogStore())
+	response, e := getLogs(ctx, s.client, s.instance.project(), s.instance.traceLogStore(), DefaultTopicName, from, to,
+		toGetServicesQuery(), DefaultFetchNumber, DefaultOffset)
 
 	if e != nil {
+		span.setError(e)
 		return nil, e
 	}
 
-	services := make([]string, response.Count)
-	for i, data := range response.Logs {
-		services[i] = data[ServiceName]
+	services := make([]string, 0, response.Count)
+	for _, data := range response.Logs {
+		// logs without a service are not spans
+		if data[ServiceName] != "" {
+			services = append(services, data[ServiceName])
+		}
+	}
+	span.setTag("rows", len(services))
+	if err := completeness.check(ctx, "GetServices"); err != nil {
+		span.setError(err)
+		return nil, err
 	}
 
 	return services, nil
@@ -49,24 +65,51 @@ func (s slsSpanReader) GetOperations(ctx context.Context, query spanstore.Operat
 			s.logger.Error("Failed to get operations", "Exception", err)
 		}
 	}()
+	ctx, span := s.tracer.startSpan(s.queryOptions.attach(ctx), "GetOperations")
+	defer span.finish()
+	span.setTag("service", query.ServiceName)
+	ctx, completeness := trackCompleteness(ctx)
 
 	from, to := buildSearchingData(s.maxLookBack)
+	logstore := s.instance.traceLogStore()
+	if s.operationsLogStore != "" {
+		logstore = s.operationsLogStore
+	}
 
-	response, e := s.client.GetLogs(s.instance.project(), s.instance.traceLogStore(), DefaultTopicName, from, to,
-		toOperationsQuery(query), DefaultFetchNumber, DefaultOffset, false)
+	operations := make([]spanstore.Operation, 0)
+	for offset := 0; offset < MaxOperations; offset += DefaultOperationsPageSize {
+		queryString := toOperationsQuery(query, offset, DefaultOperationsPageSize)
+		topic := DefaultTopicName
+		if s.operationsLogStore == "" {
+			topic = s.searchOptions.topic(query.ServiceName, from)
+		}
+		response, e := getLogs(ctx, s.client, s.instance.project(), logstore, topic, from, to,
+			queryString, DefaultOperationsPageSize, DefaultOffset)
 
-	s.logger.Info("GetOperations", "Query", toOperationsQuery(query), "StartTime", time.Unix(from, 0), "EndTime", time.Unix(to, 0), "Logstore", s.instance.traceLogStore())
-	if e != nil {
-		return nil, e
-	}
+		if e != nil {
+			span.setError(e)
+			return nil, e
+		}
+
+		for _, data := range response.Logs {
+			if data[OperationName] == "" {
+				continue
+			}
+			operations = append(operations, spanstore.Operation{
+				Name:     data[OperationName],
+				SpanKind: data[SpanKind],
+			})
+		}
 
-	operations := make([]spanstore.Operation, response.Count)
-	for i, data := range response.Logs {
-		operations[i] = spanstore.Operation{
-			Name:     data[OperationName],
-			SpanKind: data[SpanKind],
+		if len(response.Logs) < DefaultOperationsPageSize {
+			break
 		}
 	}
+	span.setTag("rows", len(operations))
+	if err := completeness.check(ctx, "GetOperations"); err != nil {
+		span.setError(err)
+		return nil, err
+	}
 
 	return operations, nil
 }
@@ -77,38 +120,88 @@ func (s slsSpanReader) FindTraces(ctx context.Context, query *spanstore.TraceQue
 			s.logger.Error("Failed to find traces", "Exceptions", err)
 		}
 	}()
+	ctx, span := s.tracer.startSpan(s.queryOptions.attach(ctx), "FindTraces")
+	defer span.finish()
+	span.setTag("service", query.ServiceName)
+	span.setTag("operation", query.OperationName)
+	searchCtx, completeness := trackCompleteness(ctx)
 
-	traceIDs, err := GetTraceIDsWithQuery(s.client, s.instance.project(), s.instance.traceLogStore(), query)
+	traceIDs, err := findTraceIDs(searchCtx, s.client, s.instance.project(), s.instance.traceLogStore(), query, s.searchOptions)
 	if err != nil {
+		span.setError(err)
 		return nil, err
 	}
 
 	var result []*model.Trace
 	for _, tid := range traceIDs {
-		if t, e := GetTraceWithTime(s.client, tid, query.StartTimeMin.Unix(), query.StartTimeMax.Unix(), s.instance.project(),
+		if t, e := getTraceWithTime(ctx, s.client, tid, query.StartTimeMin.Unix(), query.StartTimeMax.Unix(), s.instance.project(),
 			s.instance.traceLogStore()); e == nil {
 			result = append(result, t)
 		} else {
 			logger.Warn("Failed to get trace data.", "TID", tid, "Exception", e)
 		}
 	}
+	if completeness.incomplete() {
+		warning := completeness.warning("traces matching the search")
+		for _, t := range result {
+			addTraceWarnings(t, warning)
+		}
+	}
+	if _, options := splitSearchOptions(query, s.searchOptions); options.LinkedTraces > 0 {
+		result = s.appendLinkedTraces(ctx, result, query.StartTimeMin.Unix(), query.StartTimeMax.Unix(), options.LinkedTraces)
+	}
+	span.setTag("traces", len(result))
 
 	return result, nil
 }
 
 func (s slsSpanReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	ranked, err := s.findRankedTraceIDs(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return traceIDsOf(ranked), nil
+}
+
+// findRankedTraceIDs finds the trace ids with the start time or duration they are ordered by.
+func (s slsSpanReader) findRankedTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]rankedTraceID, error) {
 	defer func() {
 		if err := recover(); err != nil {
 			s.logger.Error("Failed to FindTraceIDs", "Exception", err)
 		}
 	}()
-
-	return GetTraceIDsWithQuery(s.client, s.instance.project(), s.instance.traceLogStore(), query)
+	ctx, span := s.tracer.startSpan(s.queryOptions.attach(ctx), "FindTraceIDs")
+	defer span.finish()
+	span.setTag("service", query.ServiceName)
+	span.setTag("operation", query.OperationName)
+	ctx, completeness := trackCompleteness(ctx)
+
+	traceIDs, err := findRankedTraceIDs(ctx, s.client, s.instance.project(), s.instance.traceLogStore(), query, s.searchOptions)
+	if err == nil {
+		err = completeness.check(ctx, "FindTraceIDs")
+	}
+	if err != nil {
+		span.setError(err)
+		return nil, err
+	}
+	span.setTag("traces", len(traceIDs))
+	return traceIDs, nil
 }
 
 func (s slsSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
 	from, to := buildSearchingData(s.maxLookBack)
-	return GetTraceWithTime(s.client, traceID, from, to, s.instance.project(), s.instance.traceLogStore())
+	return s.getTraceInRange(ctx, traceID, from, to)
+}
+
+// getTraceInRange reads the trace within the time range of a search in seconds, instead of the max look back.
+func (s slsSpanReader) getTraceInRange(ctx context.Context, traceID model.TraceID, from, to int64) (*model.Trace, error) {
+	ctx, span := s.tracer.startSpan(s.queryOptions.attach(ctx), "GetTrace")
+	defer span.finish()
+	span.setTag("trace_id", traceID.String())
+
+	trace, err := getTraceWithTime(ctx, s.client, traceID, from, to, s.instance.project(), s.instance.traceLogStore())
+	span.setError(err)
+	return trace, err
 }
 
 var logger = hclog.New(&hclog.LoggerOptions{
@@ -117,52 +210,137 @@ var logger = hclog.New(&hclog.LoggerOptions{
 	JSONFormat: true,
 })
 
+// GetTraceIDsWithQuery returns the trace ids matching the query in the asked order, at most query.NumTraces ids.
 func GetTraceIDsWithQuery(client *slsSdk.Client, project, logstore string, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
-	from, to := query.StartTimeMin.Unix(), query.StartTimeMax.Unix()
-	queryString := toFindTraceIdsQuery(query)
+	return findTraceIDs(context.Background(), client, project, logstore, query, TraceSearchOptions{})
+}
 
-	response, e := client.GetLogs(project, logstore, DefaultTopicName, from, to,
-		queryString, DefaultFetchNumber, DefaultOffset, false)
+// rankedTraceID a trace id with the start time of its newest span or its longest duration in microseconds, the
+// value the trace ids are ordered by.
+type rankedTraceID struct {
+	id   model.TraceID
+	rank int64
+}
 
-	if e != nil {
-		return nil, e
+// rankedTraceIDFinder the readers which can tell the rank of the trace ids they find, so that federated results
+// are merged in order.
+type rankedTraceIDFinder interface {
+	findRankedTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]rankedTraceID, error)
+}
+
+// rangedTraceReader the readers which can read a trace within the time range of a search.
+type rangedTraceReader interface {
+	getTraceInRange(ctx context.Context, traceID model.TraceID, from, to int64) (*model.Trace, error)
+}
+
+func traceIDsOf(ranked []rankedTraceID) []model.TraceID {
+	traceIDs := make([]model.TraceID, len(ranked))
+	for i, r := range ranked {
+		traceIDs[i] = r.id
+	}
+	return traceIDs
+}
+
+func findTraceIDs(ctx context.Context, client *slsSdk.Client, project, logstore string, query *spanstore.TraceQueryParameters,
+	defaults TraceSearchOptions) ([]model.TraceID, error) {
+	ranked, err := findRankedTraceIDs(ctx, client, project, logstore, query, defaults)
+	if err != nil {
+		return nil, err
+	}
+	return traceIDsOf(ranked), nil
+}
+
+func findRankedTraceIDs(ctx context.Context, client *slsSdk.Client, project, logstore string, query *spanstore.TraceQueryParameters,
+	defaults TraceSearchOptions) ([]rankedTraceID, error) {
+	from, to := query.StartTimeMin.Unix(), query.StartTimeMax.Unix()
+	query, options := splitSearchOptions(query, defaults)
+	numTraces := query.NumTraces
+	if numTraces <= 0 {
+		numTraces = DefaultNumTraces
+	}
+
+	topic := options.topic(query.ServiceName, from)
+	if options.filtersTraceDuration(query) {
+		// the other spans of the traces may be under the topics of other services
+		topic = DefaultTopicName
 	}
 
-	traceIDS := make(map[string]bool)
-	for _, log := range response.Logs {
-		traceIDS[log[TraceIDField]] = true
+	rankField := "latest"
+	if options.Order == TraceOrderDuration {
+		rankField = "longest"
 	}
 
-	var result []model.TraceID
+	result := make([]rankedTraceID, 0, numTraces)
+	seen := make(map[string]bool)
+	for page := 0; page < MaxTraceIDsPages && len(result) < numTraces; page++ {
+		queryString := toFindTraceIdsQuery(query, options, page*numTraces, numTraces)
+		response, e := getLogs(ctx, client, project, logstore, topic, from, to,
+			queryString, int64(numTraces), DefaultOffset)
+		if e != nil {
+			return nil, e
+		}
 
-	for key, _ := range traceIDS {
-		traceId, e1 := model.TraceIDFromString(key)
-		if e1 != nil {
-			logger.Warn("Failed to convert trace ID", "tid", key)
-			continue
+		for _, log := range response.Logs {
+			key := log[TraceIDField]
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			traceId, e1 := model.TraceIDFromString(key)
+			if e1 != nil {
+				logger.Warn("Failed to convert trace ID", "tid", key)
+				continue
+			}
+
+			rank, _ := strconv.ParseFloat(log[rankField], 64)
+			result = append(result, rankedTraceID{id: traceId, rank: int64(rank)})
+			if len(result) == numTraces {
+				break
+			}
 		}
 
-		result = append(result, traceId)
+		if len(response.Logs) < numTraces {
+			break
+		}
 	}
 
 	return result, nil
 }
 
 func GetTraceWithTime(client *slsSdk.Client, traceID model.TraceID, from, to int64, project, logstore string) (*model.Trace, error) {
-	if response, e := client.GetLogs(project, logstore, DefaultTopicName, from, to, toGetTraceQuery(traceID),
-		DefaultFetchNumber, DefaultOffset, false); e != nil {
+	return getTraceWithTime(context.Background(), client, traceID, from, to, project, logstore)
+}
+
+// getTraceWithTime reads the spans of the trace, with a warning when SLS returned an incomplete result.
+func getTraceWithTime(ctx context.Context, client *slsSdk.Client, traceID model.TraceID, from, to int64, project, logstore string) (*model.Trace, error) {
+	ctx, completeness := trackCompleteness(ctx)
+	response, e := getLogs(ctx, client, project, logstore, DefaultTopicName, from, to, toGetTraceQuery(traceID),
+		DefaultFetchNumber, DefaultOffset)
+	if e != nil {
 		return nil, e
-	} else {
-		return mappingTraceData(response.Logs)
 	}
+
+	trace, e := mappingTraceData(ctx, response.Logs)
+	if e == nil && completeness.incomplete() {
+		addTraceWarnings(trace, completeness.warning("spans of the trace"))
+	}
+	return trace, e
 }
 
 // mappingTraceData the method used to converting sls span data to jaeger span data.
-func mappingTraceData(logs []map[string]string) (*model.Trace, error) {
+func mappingTraceData(ctx context.Context, logs []map[string]string) (*model.Trace, error) {
+	converter := queryOptionsFromContext(ctx).converter
+	_, step := startSelfSpan(ctx, "convert.ToJaegerSpan")
+	defer step.finish()
+	step.setTag("rows", len(logs))
+
 	var processMapping []model.Trace_ProcessMapping
+	var warnings []string
 	spans := make([]*model.Span, 0)
 	for _, data := range logs {
-		if spanData, err := dataConvert.ToJaegerSpan(data); err != nil {
+		if spanData, err := converter.ToJaegerSpan(data); err != nil {
+			warnings = append(warnings, fmt.Sprintf("dropped span %s: %v", data[SpanID], err))
 			continue
 		} else {
 			spans = append(spans, spanData)
@@ -175,9 +353,13 @@ func mappingTraceData(logs []map[string]string) (*model.Trace, error) {
 			Process:   *span.Process,
 		})
 	}
+	step.setTag("spans", len(spans))
 
-	return &model.Trace{
+	// jaeger ui only shows the warnings of spans, the dropped spans are reported on a span which survived
+	trace := &model.Trace{
 		Spans:      spans,
 		ProcessMap: processMapping,
-	}, nil
+	}
+	addTraceWarnings(trace, warnings...)
+	return trace, nil
 }
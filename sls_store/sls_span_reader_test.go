@@ -0,0 +1,345 @@
+package sls_store
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// fakeSLS an SLS endpoint for the clients of the SDK, which send their requests to it as a proxy when their
+// endpoint is an ip. GetLogs is answered by getLogs, the written log groups are recorded.
+type fakeSLS struct {
+	server *httptest.Server
+	// getLogs returns the logs and the progress of the GetLogs request, an error fails it
+	getLogs func(logstore string, query url.Values) ([]map[string]string, string, error)
+	// putStatus fails PutLogs with the status when set
+	putStatus int
+	// puts the PutLogs requests received, also the failed ones
+	puts int
+
+	lock    sync.Mutex
+	queries []url.Values
+	written map[string][]*slsSdk.LogGroup
+	// hashKeys the hash keys of the written log groups, empty for groups written without one
+	hashKeys []string
+}
+
+func newFakeSLS(t *testing.T) *fakeSLS {
+	f := &fakeSLS{written: make(map[string][]*slsSdk.LogGroup)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeSLS) endpoint() string {
+	return strings.TrimPrefix(f.server.URL, "http://")
+}
+
+func (f *fakeSLS) client() *slsSdk.Client {
+	return &slsSdk.Client{Endpoint: f.endpoint(), AccessKeyID: "id", AccessKeySecret: "secret", RequestTimeOut: time.Second,
+		RetryTimeOut: time.Second}
+}
+
+// plugin a plugin reading and writing the project and instance through the fake.
+func (f *fakeSLS) plugin(opts ...PluginOption) *SlsJaegerStoragePlugin {
+	return NewSLSStorageForJaegerPlugin(f.endpoint(), "id", "secret", "project", "instance", time.Hour, logger, opts...)
+}
+
+func (f *fakeSLS) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "logstores" {
+		http.NotFound(w, r)
+		return
+	}
+	logstore := parts[1]
+
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[2] == "shards":
+		fmt.Fprint(w, `[{"shardID":0,"status":"readwrite","inclusiveBeginKey":"00000000000000000000000000000000",`+
+			`"exclusiveEndKey":"80000000000000000000000000000000","createTime":0},`+
+			`{"shardID":1,"status":"readwrite","inclusiveBeginKey":"80000000000000000000000000000000",`+
+			`"exclusiveEndKey":"ffffffffffffffffffffffffffffffff","createTime":0}]`)
+	case r.Method == http.MethodGet:
+		f.serveGetLogs(w, r, logstore)
+	case r.Method == http.MethodPost:
+		f.servePutLogs(w, r, logstore)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeSLS) serveGetLogs(w http.ResponseWriter, r *http.Request, logstore string) {
+	query := r.URL.Query()
+	f.lock.Lock()
+	f.queries = append(f.queries, query)
+	getLogs := f.getLogs
+	f.lock.Unlock()
+
+	var logs []map[string]string
+	progress := "Complete"
+	if getLogs != nil {
+		var err error
+		if logs, progress, err = getLogs(logstore, query); err != nil {
+			writeSLSError(w, http.StatusBadRequest, "ParameterInvalid", err.Error())
+			return
+		}
+		if progress == "" {
+			progress = "Complete"
+		}
+	}
+	if logs == nil {
+		logs = []map[string]string{}
+	}
+
+	w.Header().Set(slsSdk.GetLogsCountHeader, strconv.Itoa(len(logs)))
+	w.Header().Set(slsSdk.ProgressHeader, progress)
+	json.NewEncoder(w).Encode(logs)
+}
+
+func (f *fakeSLS) servePutLogs(w http.ResponseWriter, r *http.Request, logstore string) {
+	f.lock.Lock()
+	f.puts++
+	f.lock.Unlock()
+	if f.putStatus != 0 {
+		writeSLSError(w, f.putStatus, "InternalServerError", "put logs failed")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	raw, err := decompressBody(r.Header.Get("x-log-compresstype"), r.Header.Get("x-log-bodyrawsize"), body)
+	if err != nil {
+		writeSLSError(w, http.StatusBadRequest, "PostBodyInvalid", err.Error())
+		return
+	}
+	lg := &slsSdk.LogGroup{}
+	if err := lg.Unmarshal(raw); err != nil {
+		writeSLSError(w, http.StatusBadRequest, "PostBodyInvalid", err.Error())
+		return
+	}
+
+	f.lock.Lock()
+	f.written[logstore] = append(f.written[logstore], lg)
+	f.hashKeys = append(f.hashKeys, r.URL.Query().Get("key"))
+	f.lock.Unlock()
+}
+
+// logs the logs written to the logstore, as maps of their contents.
+func (f *fakeSLS) logs(logstore string) []map[string]string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var result []map[string]string
+	for _, lg := range f.written[logstore] {
+		for _, log := range lg.Logs {
+			contents := make(map[string]string)
+			for _, content := range log.Contents {
+				contents[content.GetKey()] = content.GetValue()
+			}
+			result = append(result, contents)
+		}
+	}
+	return result
+}
+
+func (f *fakeSLS) queryCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.queries)
+}
+
+func (f *fakeSLS) putCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.puts
+}
+
+func decompressBody(compression, rawSize string, body []byte) ([]byte, error) {
+	switch compression {
+	case "":
+		return body, nil
+	case CompressionLZ4:
+		size, err := strconv.Atoi(rawSize)
+		if err != nil {
+			return nil, err
+		}
+		raw := make([]byte, size)
+		n, err := lz4.UncompressBlock(body, raw)
+		return raw[:n], err
+	case CompressionDeflate:
+		r, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		return decoder.DecodeAll(body, nil)
+	}
+	return nil, fmt.Errorf("unknown compression %q", compression)
+}
+
+func writeSLSError(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"errorCode": code, "errorMessage": message})
+}
+
+var pageLimit = regexp.MustCompile(`limit (\d+), (\d+)$`)
+
+// page the offset and the limit of the paged query.
+func page(query url.Values) (int, int) {
+	m := pageLimit.FindStringSubmatch(query.Get("query"))
+	if m == nil {
+		return 0, 0
+	}
+	offset, _ := strconv.Atoi(m[1])
+	limit, _ := strconv.Atoi(m[2])
+	return offset, limit
+}
+
+func TestFindTraceIdsQueryOrderAndPage(t *testing.T) {
+	query := &spanstore.TraceQueryParameters{ServiceName: "frontend"}
+	tests := []struct {
+		options       TraceSearchOptions
+		offset, limit int
+		want          string
+	}{
+		{TraceSearchOptions{Order: TraceOrderRecency}, 0, 20, "order by latest desc limit 0, 20"},
+		{TraceSearchOptions{Order: TraceOrderDuration}, 40, 20, "order by longest desc limit 40, 20"},
+		{TraceSearchOptions{}, 5, 5, "order by latest desc limit 5, 5"},
+	}
+
+	for _, test := range tests {
+		got := toFindTraceIdsQuery(query, test.options, test.offset, test.limit)
+		if !strings.HasSuffix(got, test.want) {
+			t.Errorf("query %s does not end with %s", got, test.want)
+		}
+		if !strings.Contains(got, "select traceid, max(start) as latest, max(duration) as longest") {
+			t.Errorf("query %s does not select the ranks", got)
+		}
+	}
+}
+
+func TestSplitSearchOptions(t *testing.T) {
+	defaults := TraceSearchOptions{RootOnly: true}
+	tests := []struct {
+		tags     map[string]string
+		want     TraceSearchOptions
+		wantTags map[string]string
+	}{
+		{map[string]string{"http.method": "GET"}, TraceSearchOptions{Order: TraceOrderRecency, DurationMode: DurationModeSpan, RootOnly: true},
+			map[string]string{"http.method": "GET"}},
+		{map[string]string{OrderTagKey: TraceOrderDuration, DurationModeTagKey: DurationModeTrace, RootOnlyTagKey: "false"},
+			TraceSearchOptions{Order: TraceOrderDuration, DurationMode: DurationModeTrace}, map[string]string{}},
+	}
+
+	for _, test := range tests {
+		query := &spanstore.TraceQueryParameters{Tags: test.tags}
+		got, options := splitSearchOptions(query, defaults)
+		if options != test.want {
+			t.Errorf("options of %v = %+v, want %+v", test.tags, options, test.want)
+		}
+		if fmt.Sprint(got.Tags) != fmt.Sprint(test.wantTags) {
+			t.Errorf("tags of %v = %v, want %v", test.tags, got.Tags, test.wantTags)
+		}
+		if len(query.Tags) != len(test.tags) {
+			t.Errorf("the tags of the query were changed")
+		}
+	}
+}
+
+// traceIDRows the rows of trace ids 1 to n, with descending ranks.
+func traceIDRows(n int) []map[string]string {
+	rows := make([]map[string]string, n)
+	for i := range rows {
+		rows[i] = map[string]string{
+			TraceIDField: model.NewTraceID(0, uint64(i+1)).String(),
+			"latest":     strconv.Itoa(1000 - i),
+			"longest":    strconv.Itoa(2000 - i),
+		}
+	}
+	return rows
+}
+
+func TestFindRankedTraceIDsPaging(t *testing.T) {
+	rows := traceIDRows(7)
+	// a repeated trace id and an invalid one, which are skipped
+	rows = append(rows[:2], append([]map[string]string{rows[1], {TraceIDField: "invalid"}}, rows[2:]...)...)
+
+	tests := []struct {
+		name      string
+		numTraces int
+		order     string
+		wantIDs   int
+		wantRank  int64
+		wantPages int
+	}{
+		{"first page only", 2, TraceOrderRecency, 2, 999, 1},
+		{"several pages", 3, TraceOrderRecency, 3, 998, 2},
+		{"duration ranks", 3, TraceOrderDuration, 3, 1998, 2},
+		{"fewer traces than asked", 20, TraceOrderRecency, 7, 994, 1},
+	}
+
+	for _, test := range tests {
+		fake := newFakeSLS(t)
+		fake.getLogs = func(logstore string, query url.Values) ([]map[string]string, string, error) {
+			offset, limit := page(query)
+			if offset >= len(rows) {
+				return nil, "", nil
+			}
+			end := offset + limit
+			if end > len(rows) {
+				end = len(rows)
+			}
+			return rows[offset:end], "", nil
+		}
+
+		query := &spanstore.TraceQueryParameters{
+			ServiceName:  "frontend",
+			StartTimeMin: time.Now().Add(-time.Hour),
+			StartTimeMax: time.Now(),
+			NumTraces:    test.numTraces,
+			Tags:         map[string]string{OrderTagKey: test.order},
+		}
+		ranked, err := findRankedTraceIDs(context.Background(), fake.client(), "project", "logstore", query, TraceSearchOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if len(ranked) != test.wantIDs {
+			t.Fatalf("%s: got %d trace ids, want %d", test.name, len(ranked), test.wantIDs)
+		}
+		for i, r := range ranked {
+			if want := model.NewTraceID(0, uint64(i+1)); r.id != want {
+				t.Errorf("%s: trace id %d = %v, want %v", test.name, i, r.id, want)
+			}
+		}
+		if last := ranked[len(ranked)-1].rank; last != test.wantRank {
+			t.Errorf("%s: rank of the last trace id = %d, want %d", test.name, last, test.wantRank)
+		}
+		if pages := fake.queryCount(); pages != test.wantPages {
+			t.Errorf("%s: read %d pages, want %d", test.name, pages, test.wantPages)
+		}
+	}
+}
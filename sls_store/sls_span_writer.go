@@ -15,35 +15,200 @@ type slsSpanWriter struct {
 	instance    slsTraceInstance
 	maxLookBack time.Duration
 	logger      hclog.Logger
+	redactor    *Redactor
+	sampler     *spanSampler
+	converter   DataConverter
+	limits      SizeLimits
+	spool       *spool
+	router      *shardRouter
+	template    LogGroupTemplate
+	// mirror writes to the old target during a migration
+	mirror  *logGroupMirror
+	tracer  *selfTracer
+	limiter *rateLimiter
+	sender  *logGroupSender
 }
 
 func (s slsSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error {
-	if contents, err := convertToSpanLog(span, "", "0.0.0.0"); err != nil {
-		s.logger.Error("Failed to convert span", "spanID", span.SpanID)
+	ctx, selfSpan := s.tracer.startWriteSpan(ctx, "WriteSpan")
+	defer selfSpan.finish()
+
+	s.redactor.RedactSpan(span)
+	if s.sampler != nil {
+		err := s.sampler.add(sampledSpan{span: span})
+		selfSpan.setError(err)
+		return err
+	}
+
+	if logs, err := convertSpans(ctx, s.converter, []*model.Span{span}, s.logger); err != nil {
 		return nil
 	} else {
-		e := s.client.PutLogs(s.instance.project(), s.instance.traceLogStore(), contents)
-		if e != nil {
-			s.logger.Error("Failed to send log.", "exception", e)
+		err = s.writeLogs(ctx, logs)
+		selfSpan.setError(err)
+		return err
+	}
+}
+
+// writeSampled sends the spans of one trace kept by the sampler, the spans the OTLP receiver converted already are
+// sent as they are.
+func (s slsSpanWriter) writeSampled(spans []sampledSpan) error {
+	ctx, selfSpan := s.tracer.startWriteSpan(context.Background(), "WriteSampledTrace")
+	defer selfSpan.finish()
+
+	var logs []*slsSdk.Log
+	var jaegerSpans []*model.Span
+	for _, span := range spans {
+		if span.log != nil {
+			logs = append(logs, span.log)
+		} else {
+			jaegerSpans = append(jaegerSpans, span.span)
 		}
-		return e
 	}
+	converted, _ := convertSpans(ctx, s.converter, jaegerSpans, s.logger)
+	err := s.writeLogs(ctx, append(logs, converted...))
+	selfSpan.setError(err)
+	return err
 }
 
-func convertToSpanLog(span *model.Span, topic, source string) (*slsSdk.LogGroup, error) {
-	if logs, err := spanToLog(span); err == nil {
-		return &slsSdk.LogGroup{
-			Topic:  proto.String(topic),
-			Source: proto.String(source),
-			Logs:   logs,
-		}, nil
-	} else {
-		return nil, err
+// writeOTLPSpans sends the spans the OTLP receiver converted, through the sampler like WriteSpan when sampling is
+// enabled.
+func (s slsSpanWriter) writeOTLPSpans(ctx context.Context, spans []sampledSpan) error {
+	if s.sampler == nil {
+		logs := make([]*slsSdk.Log, len(spans))
+		for i, span := range spans {
+			logs[i] = span.log
+		}
+		return s.writeLogs(ctx, logs)
+	}
+
+	var err error
+	for _, span := range spans {
+		if e := s.sampler.add(span); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// convertSpans converts the spans to logs in a span of the conversion step, spans failed to convert are skipped.
+// The error of the last failed span is returned when no span is converted.
+func convertSpans(ctx context.Context, converter DataConverter, spans []*model.Span, logger hclog.Logger) ([]*slsSdk.Log, error) {
+	_, step := startSelfSpan(ctx, "convert.ToSLSSpan")
+	defer step.finish()
+	step.setTag("spans", len(spans))
+
+	var lastErr error
+	logs := make([]*slsSdk.Log, 0, len(spans))
+	for _, span := range spans {
+		spanLogs, err := spanToLog(converter, span)
+		if err != nil {
+			logger.Error("Failed to convert span", "spanID", span.SpanID)
+			lastErr = err
+			continue
+		}
+		logs = append(logs, spanLogs...)
+	}
+
+	step.setTag("rows", len(logs))
+	if len(logs) == 0 && lastErr != nil {
+		step.setError(lastErr)
+		return nil, lastErr
+	}
+	return logs, nil
+}
+
+// writeLogs sends spans which are already in the SLS layout, grouped by the topic, source and tags rendered from
+// the log group template and split into log groups SLS accepts.
+func (s slsSpanWriter) writeLogs(ctx context.Context, logs []*slsSdk.Log) error {
+	var order []string
+	groups := make(map[string]*slsSdk.LogGroup)
+	for _, log := range logs {
+		dimensions := s.template.render(log)
+		key := dimensions.key()
+		group, ok := groups[key]
+		if !ok {
+			group = &slsSdk.LogGroup{
+				Topic:   proto.String(dimensions.topic),
+				Source:  proto.String(dimensions.source),
+				LogTags: dimensions.tags,
+			}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.Logs = append(group.Logs, log)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		for start := 0; start < len(group.Logs); start += MaxLogGroupSize {
+			end := start + MaxLogGroupSize
+			if end > len(group.Logs) {
+				end = len(group.Logs)
+			}
+
+			e := s.putLogGroup(ctx, &slsSdk.LogGroup{
+				Topic:   group.Topic,
+				Source:  group.Source,
+				LogTags: group.LogTags,
+				Logs:    group.Logs[start:end],
+			})
+			if e != nil {
+				return e
+			}
+		}
+	}
+
+	return nil
+}
+
+// putLogGroup sends the log group, and keeps it in the spool when SLS is unavailable. During a migration the log
+// group is then queued for the old target, whatever the result of the new one.
+func (s slsSpanWriter) putLogGroup(ctx context.Context, lg *slsSdk.LogGroup) error {
+	if s.mirror != nil {
+		defer s.mirror.add(lg)
+	}
+
+	_, span := startSelfSpan(ctx, "sls.PutLogs")
+	span.setTag("sls.logstore", s.instance.traceLogStore())
+	span.setTag("sls.topic", lg.GetTopic())
+	span.setTag("rows", len(lg.Logs))
+	e := s.sendLogGroup(ctx, lg)
+	span.setError(e)
+	span.finish()
+	if e == nil {
+		return nil
+	}
+
+	if s.spool != nil && isRetryableError(e) {
+		if err := s.spool.append(lg); err == nil {
+			s.logger.Warn("Failed to send log, spooled it for replay.", "exception", e)
+			return nil
+		} else {
+			s.logger.Error("Failed to spool log.", "exception", err)
+		}
 	}
+
+	s.logger.Error("Failed to send log.", "exception", e)
+	return e
+}
+
+// sendLogGroup sends the log group under the write rate limit of the logstore, and retries it with backoff while
+// SLS throttles it, until the context is done.
+func (s slsSpanWriter) sendLogGroup(ctx context.Context, lg *slsSdk.LogGroup) error {
+	return retryThrottled(ctx, s.limiter.throttleRetries(), "writes", func() error {
+		if err := s.limiter.waitWrite(ctx, s.instance.project(), s.instance.traceLogStore()); err != nil {
+			return err
+		}
+
+		if s.router != nil {
+			return s.router.send(ctx, lg)
+		}
+		return s.sender.send(ctx, s.instance.traceLogStore(), lg, nil)
+	})
 }
 
-func spanToLog(span *model.Span) ([]*slsSdk.Log, error) {
-	contents, err := dataConvert.ToSLSSpan(span)
+func spanToLog(converter DataConverter, span *model.Span) ([]*slsSdk.Log, error) {
+	contents, err := converter.ToSLSSpan(span)
 	if err != nil {
 		return nil, err
 	}
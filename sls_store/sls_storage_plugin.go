@@ -17,62 +17,471 @@ type SlsJaegerStoragePlugin struct {
 	instance     slsTraceInstance
 	maxLookBack  time.Duration
 	logger       hclog.Logger
+	cacheConfig  CacheConfig
+	queryCache   *queryCache
+	// operationsLogStore the logstore of the materialized operations index, empty to query the trace logstore
+	operationsLogStore string
+	searchOptions      TraceSearchOptions
+	redactor           *Redactor
+	samplingConfig     SamplingConfig
+	sampler            *spanSampler
+	sizeLimits         SizeLimits
+	spoolConfig        SpoolConfig
+	spool              *spool
+	shardRouting       bool
+	router             *shardRouter
+	sender             *logGroupSender
+	logGroupTemplate   LogGroupTemplate
+	tenancyConfig      TenancyConfig
+	tenancy            *tenancy
+	federationTargets  []FederationTarget
+	federation         *federation
+	migrationTarget    *MigrationTarget
+	mirror             *logGroupMirror
+	// accessMode read-write, read-only or write-only
+	accessMode        string
+	selfTracingConfig SelfTracingConfig
+	tracer            *selfTracer
+	slowQueryConfig   SlowQueryConfig
+	incompleteConfig  IncompleteResultConfig
+	rateLimitConfig   RateLimitConfig
+	limiter           *rateLimiter
+	transportConfig   TransportConfig
+	// logTimestampUnit the unit of span log timestamps read from the project, empty detects it
+	logTimestampUnit string
+}
+
+// PluginOption the optional configuration of the plugin
+type PluginOption func(*SlsJaegerStoragePlugin)
+
+// WithQueryCache enables the query side result cache
+func WithQueryCache(config CacheConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.cacheConfig = config
+	}
+}
+
+// WithOperationsLogStore reads operations from a materialized operations index logstore, whose logs carry the
+// service, name and kind fields.
+func WithOperationsLogStore(logstore string) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.operationsLogStore = logstore
+	}
+}
+
+// WithTraceSearchOptions sets the default options of FindTraces
+func WithTraceSearchOptions(options TraceSearchOptions) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.searchOptions = options
+	}
+}
+
+// WithRedactor scrubs spans by the redaction rules before they are written
+func WithRedactor(redactor *Redactor) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.redactor = redactor
+	}
+}
+
+// WithSampling buffers spans per trace and only writes the traces kept by the sampling rules
+func WithSampling(config SamplingConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.samplingConfig = config
+	}
+}
+
+// WithSizeLimits truncates oversized fields and spans before they are written
+func WithSizeLimits(limits SizeLimits) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.sizeLimits = limits
+	}
+}
+
+// WithSpool keeps the logs SLS did not accept in a local spool and replays them later
+func WithSpool(config SpoolConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.spoolConfig = config
+	}
+}
+
+// WithShardRouting writes the spans of a trace to one shard, by the hash key of the trace id
+func WithShardRouting(enabled bool) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.shardRouting = enabled
+	}
+}
+
+// WithLogGroupTemplate fills the topic, source and tags of the log groups from the templates. With the
+// ServiceTopicTemplate topic, service scoped queries after TraceSearchOptions.ServiceTopicSince only read the topic
+// of the service.
+func WithLogGroupTemplate(template LogGroupTemplate) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.logGroupTemplate = template
+	}
+}
+
+// WithTenancy routes every call to the project and instance of the tenant read from the gRPC metadata
+func WithTenancy(config TenancyConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.tenancyConfig = config
+	}
+}
+
+// WithFederation reads the targets together with the configured project and instance, spans are still written
+// to the configured ones only
+func WithFederation(targets []FederationTarget) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.federationTargets = targets
+	}
+}
+
+// WithMigration writes spans to the old target too, and reads traces not found in the configured target from it
+func WithMigration(old *MigrationTarget) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.migrationTarget = old
+	}
+}
+
+// WithAccessMode disables the reader or the writer side, the calls of the disabled side return Unimplemented
+func WithAccessMode(mode string) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.accessMode = mode
+	}
+}
+
+// WithSelfTracing traces the reader and writer calls, the SLS requests and the conversion steps of the plugin
+func WithSelfTracing(config SelfTracingConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.selfTracingConfig = config
+	}
+}
+
+// WithSlowQueryLog sets the threshold and the sample rates of the query log
+func WithSlowQueryLog(config SlowQueryConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.slowQueryConfig = config
+	}
+}
+
+// WithIncompleteResults sets how long incomplete SLS results are retried, and whether calls fail on them
+func WithIncompleteResults(config IncompleteResultConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.incompleteConfig = config
+	}
+}
+
+// WithRateLimits limits the read and write requests per logstore, and sets the retries of throttled requests
+func WithRateLimits(config RateLimitConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.rateLimitConfig = config
+	}
+}
+
+// WithTransport sets the compression of PutLogs requests and the HTTP connection pool of the process
+func WithTransport(config TransportConfig) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.transportConfig = config
+	}
+}
+
+// WithLogTimestampUnit sets the unit of the time of span logs and events read from SLS, auto detects it
+func WithLogTimestampUnit(unit string) PluginOption {
+	return func(s *SlsJaegerStoragePlugin) {
+		s.logTimestampUnit = unit
+	}
 }
 
 func NewSLSStorageForJaegerPlugin(endpoint string, accessKeyID string, accessSecret string,
-	project string, instance string, maxLookBack time.Duration, logger hclog.Logger) *SlsJaegerStoragePlugin {
-	return &SlsJaegerStoragePlugin{
-		endpoint:     endpoint,
-		accessKeyID:  accessKeyID,
-		accessSecret: accessSecret,
-		project:      project,
-		instance:     newSlsTraceInstance(project, instance),
-		maxLookBack:  maxLookBack,
-		logger:       logger,
+	project string, instance string, maxLookBack time.Duration, logger hclog.Logger, opts ...PluginOption) *SlsJaegerStoragePlugin {
+	plugin := &SlsJaegerStoragePlugin{
+		endpoint:         endpoint,
+		accessKeyID:      accessKeyID,
+		accessSecret:     accessSecret,
+		project:          project,
+		instance:         newSlsTraceInstance(project, instance),
+		maxLookBack:      maxLookBack,
+		logger:           logger,
+		logGroupTemplate: DefaultLogGroupTemplate,
+		accessMode:       ReadWriteMode,
+		slowQueryConfig:  DefaultSlowQueryConfig,
+		incompleteConfig: DefaultIncompleteResultConfig,
+		rateLimitConfig:  DefaultRateLimitConfig,
+		transportConfig:  DefaultTransportConfig,
+	}
+
+	for _, opt := range opts {
+		opt(plugin)
+	}
+	plugin.searchOptions.ServiceTopic = plugin.logGroupTemplate.Topic == ServiceTopicTemplate
+	configureHTTPTransport(plugin.transportConfig)
+	plugin.limiter = newRateLimiter(plugin.rateLimitConfig)
+	if plugin.selfTracingConfig.Enabled {
+		tracer, err := newSelfTracer(plugin.selfTracingConfig, *plugin)
+		if err != nil {
+			plugin.logger.Error("Failed to start self tracing, the plugin is not traced", "Exception", err)
+		}
+		plugin.tracer = tracer
 	}
+	if len(plugin.federationTargets) > 0 {
+		plugin.federation = newFederation(plugin.federationTargets, *plugin)
+	}
+
+	if plugin.tenancyConfig.Enabled {
+		plugin.tenancy = newTenancy(plugin.tenancyConfig, *plugin)
+		return plugin
+	}
+
+	plugin.start()
+	return plugin
+}
+
+// start builds the shared state of the plugin, the query cache and the write pipeline.
+func (s *SlsJaegerStoragePlugin) start() {
+	if s.cacheConfig.Enabled && s.canRead() {
+		s.queryCache = newQueryCache(s.cacheConfig, s.buildSpanReader(), s.logger)
+	}
+
+	if !s.canWrite() {
+		return
+	}
+
+	s.sender = newLogGroupSender(*s)
+	if s.shardRouting {
+		s.router = newShardRouter(buildSLSSdkClient(*s), s.sender, s.instance, s.logger)
+	}
+
+	if s.spoolConfig.Dir != "" {
+		writer := s.buildSpanWriter()
+		spool, err := newSpool(s.spoolConfig, writer.sendLogGroup, s.logger)
+		if err != nil {
+			s.logger.Error("Failed to open spool, spans failed to send will be lost", "Dir", s.spoolConfig.Dir, "Exception", err)
+		}
+		s.spool = spool
+	}
+
+	if s.migrationTarget != nil {
+		s.mirror = newLogGroupMirror(s.buildMirrorWriter(), s.logger)
+	}
+
+	if s.samplingConfig.Enabled {
+		s.sampler = newSpanSampler(s.samplingConfig, s.buildSpanWriter().writeSampled, s.logger)
+	}
+}
+
+// Close stops the query cache refresh, decides the traces buffered by the samplers of the plugin and its tenants,
+// writes the log groups waiting for the old target of a migration, stops the spool replay closing its active segment
+// and exports the self tracing spans, so that their spans are not lost on shutdown.
+func (s *SlsJaegerStoragePlugin) Close() error {
+	var err error
+	if s.queryCache != nil {
+		s.queryCache.close()
+	}
+	if s.sampler != nil {
+		err = s.sampler.close()
+	}
+	if s.mirror != nil {
+		s.mirror.close()
+	}
+	if s.spool != nil {
+		s.spool.close()
+	}
+	if s.tenancy != nil {
+		if e := s.tenancy.close(); e != nil {
+			err = e
+		}
+	}
+	s.tracer.close()
+	return err
 }
 
 func (s SlsJaegerStoragePlugin) ArchiveSpanReader() spanstore.Reader {
+	if !s.canRead() {
+		return disabledSpanReader{mode: s.accessMode}
+	}
+
+	if s.tenancy != nil {
+		return tenantSpanReader{tenancy: s.tenancy, archive: true}
+	}
+
 	return &slsSpanReader{
-		client:      buildSLSSdkClient(s),
-		instance:    s.instance,
-		maxLookBack: s.maxLookBack,
-		logger:      s.logger,
+		client:       buildSLSSdkClient(s),
+		instance:     s.instance,
+		maxLookBack:  s.maxLookBack,
+		logger:       s.logger,
+		tracer:       s.tracer,
+		queryOptions: s.buildQueryOptions(),
 	}
 }
 
 func (s SlsJaegerStoragePlugin) ArchiveSpanWriter() spanstore.Writer {
-	return &slsSpanWriter{
-		client:      buildSLSSdkClient(s),
-		instance:    s.instance,
-		maxLookBack: s.maxLookBack,
-		logger:      s.logger,
+	if !s.canWrite() {
+		return disabledSpanWriter{mode: s.accessMode}
 	}
+
+	if s.tenancy != nil {
+		return tenantSpanWriter{tenancy: s.tenancy, archive: true}
+	}
+
+	return s.buildSpanWriter()
 }
 
 func (s SlsJaegerStoragePlugin) SpanReader() spanstore.Reader {
-	return &slsSpanReader{
-		client:      buildSLSSdkClient(s),
-		instance:    s.instance,
-		maxLookBack: s.maxLookBack,
-		logger:      s.logger,
+	if !s.canRead() {
+		return disabledSpanReader{mode: s.accessMode}
 	}
+
+	if s.tenancy != nil {
+		return tenantSpanReader{tenancy: s.tenancy}
+	}
+
+	if s.queryCache != nil {
+		return &cachingSpanReader{
+			Reader: s.buildSpanReader(),
+			cache:  s.queryCache,
+		}
+	}
+
+	return s.buildSpanReader()
+}
+
+func (s SlsJaegerStoragePlugin) buildSpanReader() spanstore.Reader {
+	var reader spanstore.Reader = &slsSpanReader{
+		client:             buildSLSSdkClient(s),
+		instance:           s.instance,
+		maxLookBack:        s.maxLookBack,
+		logger:             s.logger,
+		operationsLogStore: s.operationsLogStore,
+		searchOptions:      s.searchOptions,
+		tracer:             s.tracer,
+		queryOptions:       s.buildQueryOptions(),
+	}
+	if s.federation != nil {
+		reader = federatedSpanReader{federation: s.federation}
+	}
+
+	if s.migrationTarget != nil {
+		return migrationSpanReader{
+			Reader: reader,
+			old:    s.retarget(*s.migrationTarget).buildSpanReader(),
+		}
+	}
+	return reader
 }
 
 func (s SlsJaegerStoragePlugin) SpanWriter() spanstore.Writer {
+	if !s.canWrite() {
+		return disabledSpanWriter{mode: s.accessMode}
+	}
+
+	if s.tenancy != nil {
+		return tenantSpanWriter{tenancy: s.tenancy}
+	}
+
+	return s.buildSpanWriter()
+}
+
+func (s SlsJaegerStoragePlugin) buildSpanWriter() *slsSpanWriter {
 	return &slsSpanWriter{
 		client:      buildSLSSdkClient(s),
 		instance:    s.instance,
 		maxLookBack: s.maxLookBack,
 		logger:      s.logger,
+		redactor:    s.redactor,
+		sampler:     s.sampler,
+		converter:   &dataConverterImpl{limits: s.sizeLimits},
+		limits:      s.sizeLimits,
+		spool:       s.spool,
+		router:      s.router,
+		template:    s.logGroupTemplate,
+		mirror:      s.mirror,
+		tracer:      s.tracer,
+		limiter:     s.limiter,
+		sender:      s.sender,
+	}
+}
+
+// buildMirrorWriter the writer of the old target of the migration, which only sends the log groups the writer of
+// the new target has prepared.
+func (s SlsJaegerStoragePlugin) buildMirrorWriter() *slsSpanWriter {
+	if s.migrationTarget == nil {
+		return nil
+	}
+
+	old := s.retarget(*s.migrationTarget)
+	return &slsSpanWriter{
+		client:   buildSLSSdkClient(old),
+		instance: old.instance,
+		logger:   old.logger,
+		limiter:  old.limiter,
+		sender:   newLogGroupSender(old),
 	}
 }
 
+// retarget returns a copy of the plugin for the project and instance of the target, without the shared state of
+// the plugin. Empty endpoint and credentials are kept.
+func (s SlsJaegerStoragePlugin) retarget(target FederationTarget) SlsJaegerStoragePlugin {
+	plugin := s
+	plugin.project = target.Project
+	plugin.instance = newSlsTraceInstance(target.Project, target.Instance)
+	if target.Endpoint != "" {
+		plugin.endpoint = target.Endpoint
+	}
+	if target.AccessKeyID != "" {
+		plugin.accessKeyID = target.AccessKeyID
+		plugin.accessSecret = target.AccessKeySecret
+	}
+	if target.LogTimestampUnit != "" {
+		plugin.logTimestampUnit = target.LogTimestampUnit
+	}
+
+	plugin.queryCache = nil
+	plugin.sender = nil
+	plugin.router = nil
+	plugin.spool = nil
+	plugin.sampler = nil
+	plugin.tenancy = nil
+	plugin.federation = nil
+	plugin.migrationTarget = nil
+	return plugin
+}
+
 func (s SlsJaegerStoragePlugin) DependencyReader() dependencystore.Reader {
+	if !s.canRead() {
+		return disabledDependencyReader{mode: s.accessMode}
+	}
+
+	if s.tenancy != nil {
+		return tenantDependencyReader{tenancy: s.tenancy}
+	}
+
+	if s.federation != nil {
+		return federatedDependencyReader{federation: s.federation}
+	}
+
 	return &slsDependencyReader{
-		client:   buildSLSSdkClient(s),
-		instance: s.instance,
-		logger:   s.logger,
+		client:       buildSLSSdkClient(s),
+		instance:     s.instance,
+		logger:       s.logger,
+		tracer:       s.tracer,
+		queryOptions: s.buildQueryOptions(),
+	}
+}
+
+func (s SlsJaegerStoragePlugin) buildQueryOptions() *queryOptions {
+	return &queryOptions{
+		slowQuery:  s.slowQueryConfig,
+		incomplete: s.incompleteConfig,
+		limiter:    s.limiter,
+		logger:     s.logger,
+		converter:  &dataConverterImpl{logTimestampUnit: s.logTimestampUnit},
+	}
+}
+
+func (s SlsJaegerStoragePlugin) OTLPReceiver() *OTLPReceiver {
+	return &OTLPReceiver{
+		writer:  s.buildSpanWriter(),
+		tenancy: s.tenancy,
+		logger:  s.logger,
 	}
 }
 
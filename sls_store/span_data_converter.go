@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
 
 	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
 	"github.com/gogo/protobuf/proto"
@@ -21,13 +20,25 @@ type DataConverter interface {
 var dataConvert = &dataConverterImpl{}
 
 type dataConverterImpl struct {
+	limits SizeLimits
+	// logTimestampUnit the unit of the time of span logs and events read from SLS, other SLS Trace producers may not
+	// write nanoseconds as the plugin does. Empty detects it.
+	logTimestampUnit string
 }
 
-func (dataConverterImpl) ToJaegerSpan(log map[string]string) (*model.Span, error) {
+func (c dataConverterImpl) timestampUnit() string {
+	if c.logTimestampUnit == "" {
+		return TimestampUnitAuto
+	}
+	return c.logTimestampUnit
+}
+
+func (c dataConverterImpl) ToJaegerSpan(log map[string]string) (*model.Span, error) {
 	span := model.Span{}
 	process := model.Process{
 		Tags: make([]model.KeyValue, 0),
 	}
+	var linkTags []model.KeyValue
 
 	for k, v := range log {
 		switch k {
@@ -63,15 +74,16 @@ func (dataConverterImpl) ToJaegerSpan(log map[string]string) (*model.Span, error
 			process.ServiceName = v
 			break
 		case Links:
-			refs, err := unmarshalReferences(v)
-			if err != nil {
-				logger.Warn("Failed to convert links", "key", k, "value", v, "exception", err)
-				return nil, err
+			refs, tags, warnings := unmarshalReferences(v)
+			if len(warnings) > 0 {
+				logger.Warn("Failed to convert links", "key", k, "value", v, "warnings", warnings)
 			}
 			span.References = refs
+			linkTags = tags
+			span.Warnings = append(span.Warnings, warnings...)
 			break
 		case Logs:
-			logs, err := unmarshalLogs(v)
+			logs, err := unmarshalLogs(v, c.timestampUnit())
 			if err != nil {
 				logger.Warn("Failed to convert logs", "key", k, "value", v, "exception", err)
 				return nil, err
@@ -79,9 +91,7 @@ func (dataConverterImpl) ToJaegerSpan(log map[string]string) (*model.Span, error
 			span.Logs = logs
 			break
 		case StatusMessageField:
-			if v != "" {
-				span.Warnings = append(span.Warnings, v)
-			}
+			span.Warnings = append(span.Warnings, unmarshalWarnings(v)...)
 			break
 		case Attribute:
 			span.Tags = unmarshalTags(v)
@@ -96,11 +106,13 @@ func (dataConverterImpl) ToJaegerSpan(log map[string]string) (*model.Span, error
 		}
 	}
 
+	span.Tags = append(span.Tags, linkTags...)
 	span.Process = &process
 	return &span, nil
 }
 
-func (dataConverterImpl) ToSLSSpan(span *model.Span) ([]*slsSdk.LogContent, error) {
+func (c dataConverterImpl) ToSLSSpan(span *model.Span) ([]*slsSdk.LogContent, error) {
+	span = c.limits.apply(span)
 	contents := make([]*slsSdk.LogContent, 0)
 	contents = appendAttributeToLogContent(contents, TraceID, TraceIDToString(&span.TraceID))
 	contents = appendAttributeToLogContent(contents, SpanID, span.SpanID.String())
@@ -112,7 +124,8 @@ func (dataConverterImpl) ToSLSSpan(span *model.Span) ([]*slsSdk.LogContent, erro
 	contents = appendAttributeToLogContent(contents, EndTime, cast.ToString((span.StartTime.UnixNano()+span.Duration.Nanoseconds())/1000))
 	contents = appendAttributeToLogContent(contents, ServiceName, span.Process.ServiceName)
 	contents = appendAttributeToLogContent(contents, StatusCode, "UNSET")
-	contents = appendAttributeToLogContent(contents, Attribute, marshalTags(span.Tags))
+	tags, links := splitLinkTags(span.Tags, span.References)
+	contents = appendAttributeToLogContent(contents, Attribute, marshalTags(tags))
 	contents = appendAttributeToLogContent(contents, Resource, marshalResource(span.Process.Tags, span.ProcessID))
 	if spankind, ok := span.GetSpanKind(); ok {
 		contents = appendAttributeToLogContent(contents, SpanKind, strings.ToLower(spankind))
@@ -120,7 +133,7 @@ func (dataConverterImpl) ToSLSSpan(span *model.Span) ([]*slsSdk.LogContent, erro
 		contents = appendAttributeToLogContent(contents, SpanKind, "")
 	}
 
-	if refStr, err := marshalReferences(span.References); err != nil {
+	if refStr, err := marshalReferences(span.References, links); err != nil {
 		logger.Warn("Failed to convert references", "spanID", span.SpanID, "reference", span.References, "exception", err)
 		return nil, err
 	} else {
@@ -156,6 +169,20 @@ func appendWarnings(contents []*slsSdk.LogContent, warnings []string) ([]*slsSdk
 	return appendAttributeToLogContent(contents, StatusMessage, string(r)), nil
 }
 
+// unmarshalWarnings reads the warnings stored by appendWarnings, the status message of other producers is one
+// warning.
+func unmarshalWarnings(v string) []string {
+	if v == "" {
+		return nil
+	}
+
+	var warnings []string
+	if strings.HasPrefix(v, "[") && json.Unmarshal([]byte(v), &warnings) == nil {
+		return warnings
+	}
+	return []string{v}
+}
+
 func marshalResource(v []model.KeyValue, processID string) string {
 	dataMap := keyValueToMap(v)
 	dataMap["ProcessID"] = processID
@@ -204,6 +231,7 @@ func unmarshalTags(v string) (kvs []model.KeyValue) {
 	return mapToKeyValue(data)
 }
 
+// SpanLog the stored form of a span log or event, the plugin writes Time in nanoseconds
 type SpanLog struct {
 	Attribute map[string]string `json:"attribute"`
 	Time      int64             `json:"time"`
@@ -230,7 +258,8 @@ func marshalLogs(logs []model.Log) (string, error) {
 	return string(r), nil
 }
 
-func unmarshalLogs(s string) ([]model.Log, error) {
+// unmarshalLogs reads the span logs, whose time is in the unit, or in the unit detected for each log when it is auto.
+func unmarshalLogs(s string, unit string) ([]model.Log, error) {
 	if s == "[]" {
 		return nil, nil
 	}
@@ -243,14 +272,16 @@ func unmarshalLogs(s string) ([]model.Log, error) {
 	result := make([]model.Log, len(logs))
 	for i, log := range logs {
 		result[i] = model.Log{
-			Timestamp: time.Unix(log.Time/1e9, log.Time%1e9),
+			Timestamp: timestampToTime(log.Time, unit),
 			Fields:    mapToKeyValue(log.Attribute),
 		}
 	}
 	return result, nil
 }
 
-func marshalReferences(refs []model.SpanRef) (string, error) {
+// marshalReferences stores the references with the attributes and the trace state of the links restored as span
+// tags by unmarshalReferences.
+func marshalReferences(refs []model.SpanRef, links map[string]*spanLink) (string, error) {
 	if len(refs) <= 0 {
 		return "[]", nil
 	}
@@ -258,11 +289,16 @@ func marshalReferences(refs []model.SpanRef) (string, error) {
 	rs := make([]map[string]string, 0)
 
 	for _, ref := range refs {
-		r := make(map[string]string)
-		r["TraceID"] = ref.TraceID.String()
-		r["SpanID"] = ref.SpanID.String()
-		r["RefType"] = ref.RefType.String()
-		rs = append(rs, r)
+		r := spanLink{
+			TraceID: ref.TraceID.String(),
+			SpanID:  ref.SpanID.String(),
+			RefType: ref.RefType.String(),
+		}
+		if link, ok := links[linkKey(ref.TraceID, ref.SpanID)]; ok {
+			r.TraceState = link.TraceState
+			r.Attributes = link.Attributes
+		}
+		rs = append(rs, r.toMap())
 	}
 
 	r, err := json.Marshal(rs)
@@ -273,27 +309,30 @@ func marshalReferences(refs []model.SpanRef) (string, error) {
 	return string(r), nil
 }
 
-func unmarshalReferences(s string) (refs []model.SpanRef, err error) {
-	if s == "[]" {
-		return nil, nil
+// unmarshalReferences keeps the valid references, and returns a warning for each one with a bad trace or span id
+// instead of failing the span. The attributes and the trace state of span links are returned as link tags.
+func unmarshalReferences(s string) (refs []model.SpanRef, tags []model.KeyValue, warnings []string) {
+	if s == "[]" || s == "" {
+		return nil, nil, nil
 	}
 
 	rs := make([]map[string]string, 0)
 
-	err = json.Unmarshal([]byte(s), &rs)
-	if err != nil {
-		return nil, err
+	if err := json.Unmarshal([]byte(s), &rs); err != nil {
+		return nil, nil, []string{fmt.Sprintf("dropped the references of the span, they are not valid JSON: %v", err)}
 	}
 
 	for _, r := range rs {
 		tid, e1 := model.TraceIDFromString(r["TraceID"])
 		if e1 != nil {
-			return nil, e1
+			warnings = append(warnings, fmt.Sprintf("dropped a reference with invalid trace id %q: %v", r["TraceID"], e1))
+			continue
 		}
 
 		spanID, e2 := model.SpanIDFromString(r["SpanID"])
 		if e2 != nil {
-			return nil, e2
+			warnings = append(warnings, fmt.Sprintf("dropped a reference with invalid span id %q: %v", r["SpanID"], e2))
+			continue
 		}
 
 		spanType := model.SpanRefType_value[r["RefType"]]
@@ -302,9 +341,10 @@ func unmarshalReferences(s string) (refs []model.SpanRef, err error) {
 			SpanID:  spanID,
 			RefType: model.SpanRefType(spanType),
 		})
+		tags = append(tags, linkTags(tid, spanID, r)...)
 	}
 
-	return refs, nil
+	return refs, tags, warnings
 }
 
 func mapToKeyValue(data map[string]string) []model.KeyValue {
@@ -0,0 +1,175 @@
+package sls_store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+const (
+	// LinkTagPrefix the prefix of the span tags carrying the attributes and the trace state of a span link, jaeger
+	// references have neither. The attributes of the link to a span are the JSON object in the tag
+	// "link.<trace id>.<span id>", its trace state is in the tag "link.<trace id>.<span id>.tracestate".
+	LinkTagPrefix = "link."
+	// linkTraceStateSuffix the suffix of the tag carrying the trace state of a span link
+	linkTraceStateSuffix = ".tracestate"
+)
+
+// spanLink the stored form of a reference, Attributes and TraceState are only set for OTel span links.
+type spanLink struct {
+	TraceID    string
+	SpanID     string
+	RefType    string
+	TraceState string
+	// Attributes the JSON object of the link attributes
+	Attributes string
+}
+
+func (l spanLink) toMap() map[string]string {
+	r := map[string]string{
+		"TraceID": l.TraceID,
+		"SpanID":  l.SpanID,
+		"RefType": l.RefType,
+	}
+	if l.TraceState != "" {
+		r["TraceState"] = l.TraceState
+	}
+	if l.Attributes != "" {
+		r["Attributes"] = l.Attributes
+	}
+	return r
+}
+
+// linkKey the key of a link in its tags, span ids are only unique within a trace.
+func linkKey(traceID model.TraceID, spanID model.SpanID) string {
+	return traceID.String() + "." + spanID.String()
+}
+
+// linkTags the span tags restoring the attributes and the trace state of the link.
+func linkTags(traceID model.TraceID, spanID model.SpanID, r map[string]string) []model.KeyValue {
+	tags := make([]model.KeyValue, 0)
+	key := LinkTagPrefix + linkKey(traceID, spanID)
+	if r["Attributes"] != "" {
+		tags = append(tags, model.String(key, r["Attributes"]))
+	}
+	if r["TraceState"] != "" {
+		tags = append(tags, model.String(key+linkTraceStateSuffix, r["TraceState"]))
+	}
+	return tags
+}
+
+// splitLinkTags separates the link tags restored by linkTags from the other tags of the span, the links are keyed
+// by linkKey of the span they point to.
+func splitLinkTags(tags []model.KeyValue, refs []model.SpanRef) ([]model.KeyValue, map[string]*spanLink) {
+	links := make(map[string]*spanLink)
+	for _, ref := range refs {
+		links[linkKey(ref.TraceID, ref.SpanID)] = &spanLink{}
+	}
+
+	result := make([]model.KeyValue, 0, len(tags))
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag.Key, LinkTagPrefix) {
+			result = append(result, tag)
+			continue
+		}
+
+		key := strings.TrimPrefix(tag.Key, LinkTagPrefix)
+		if strings.HasSuffix(key, linkTraceStateSuffix) {
+			if link, ok := links[strings.TrimSuffix(key, linkTraceStateSuffix)]; ok {
+				link.TraceState = tag.AsString()
+				continue
+			}
+		} else if link, ok := links[key]; ok {
+			link.Attributes = tag.AsString()
+			continue
+		}
+		result = append(result, tag)
+	}
+
+	return result, links
+}
+
+// linkAttributes the attributes of a span link stored by marshalOTLPLinks, as a JSON object of strings.
+func linkAttributes(s string) map[string]string {
+	attributes := make(map[string]string)
+	if err := json.Unmarshal([]byte(s), &attributes); err != nil {
+		attributes["attributes"] = s
+	}
+	return attributes
+}
+
+// linkedTraceIDs the ids of the other traces the spans of the trace reference, in the order of the references.
+func linkedTraceIDs(trace *model.Trace) []model.TraceID {
+	own := make(map[model.TraceID]bool)
+	for _, span := range trace.Spans {
+		own[span.TraceID] = true
+	}
+
+	result := make([]model.TraceID, 0)
+	seen := make(map[model.TraceID]bool)
+	for _, span := range trace.Spans {
+		for _, ref := range span.References {
+			if own[ref.TraceID] || seen[ref.TraceID] {
+				continue
+			}
+			seen[ref.TraceID] = true
+			result = append(result, ref.TraceID)
+		}
+	}
+	return result
+}
+
+// appendLinkedTraces returns the traces followed by the traces their spans link to, at most maxLinked per trace and
+// each trace once, read within the time range in seconds.
+func (s slsSpanReader) appendLinkedTraces(ctx context.Context, traces []*model.Trace, from, to int64, maxLinked int) []*model.Trace {
+	known := make(map[model.TraceID]bool)
+	for _, trace := range traces {
+		for _, span := range trace.Spans {
+			known[span.TraceID] = true
+		}
+	}
+
+	result := traces
+	for _, trace := range traces {
+		result = append(result, s.getLinkedTraces(ctx, trace, from, to, maxLinked, known)...)
+	}
+	return result
+}
+
+// getLinkedTraces reads the traces the spans of the trace link to which are not known yet, at most maxLinked of
+// them, and adds them to known. Linked traces which can not be read are left out, with a warning on the trace.
+func (s slsSpanReader) getLinkedTraces(ctx context.Context, trace *model.Trace, from, to int64, maxLinked int,
+	known map[model.TraceID]bool) []*model.Trace {
+	ctx, span := s.tracer.startSpan(s.queryOptions.attach(ctx), "GetLinkedTraces")
+	defer span.finish()
+
+	ids := make([]model.TraceID, 0)
+	for _, id := range linkedTraceIDs(trace) {
+		if !known[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxLinked {
+		addTraceWarnings(trace, fmt.Sprintf("%d linked traces are not returned, at most %d are", len(ids)-maxLinked, maxLinked))
+		ids = ids[:maxLinked]
+	}
+	span.setTag("traces", len(ids))
+
+	result := make([]*model.Trace, 0, len(ids))
+	for _, id := range ids {
+		known[id] = true
+		linked, err := s.getTraceInRange(ctx, id, from, to)
+		if err != nil {
+			s.logger.Warn("Failed to get linked trace", "TID", id, "Exception", err)
+			addTraceWarnings(trace, fmt.Sprintf("failed to get linked trace %s: %v", id, err))
+			continue
+		}
+		if len(linked.Spans) > 0 {
+			result = append(result, linked)
+		}
+	}
+	return result
+}
@@ -0,0 +1,154 @@
+package sls_store
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestLinkTagsAreKeyedByTraceID(t *testing.T) {
+	refs, err := json.Marshal([]map[string]string{
+		{"TraceID": "1", "SpanID": "5", "RefType": "FOLLOWS_FROM", "Attributes": `{"job":"a"}`, "TraceState": "k=a"},
+		{"TraceID": "2", "SpanID": "5", "RefType": "FOLLOWS_FROM", "Attributes": `{"job":"b"}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, tags, warnings := unmarshalReferences(string(refs))
+	if len(parsed) != 2 || len(warnings) != 0 {
+		t.Fatalf("parsed %d references and warnings %v, want 2 references", len(parsed), warnings)
+	}
+	want := map[string]string{
+		"link.0000000000000001.0000000000000005":            `{"job":"a"}`,
+		"link.0000000000000001.0000000000000005.tracestate": "k=a",
+		"link.0000000000000002.0000000000000005":            `{"job":"b"}`,
+	}
+	if len(tags) != len(want) {
+		t.Errorf("got %d link tags, want %d", len(tags), len(want))
+	}
+	for _, tag := range tags {
+		if want[tag.Key] != tag.AsString() {
+			t.Errorf("tag %s = %s, want %s", tag.Key, tag.AsString(), want[tag.Key])
+		}
+	}
+
+	// the links of the same span id in two traces are stored back into their own reference
+	rest, links := splitLinkTags(append(tags, model.String("other", "value")), parsed)
+	if len(rest) != 1 || rest[0].Key != "other" {
+		t.Errorf("the tags other than the link tags should be kept, got %v", rest)
+	}
+	stored, err := marshalReferences(parsed, links)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]string
+	if err := json.Unmarshal([]byte(stored), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got[0]["Attributes"] != `{"job":"a"}` || got[0]["TraceState"] != "k=a" || got[1]["Attributes"] != `{"job":"b"}` ||
+		got[1]["TraceState"] != "" {
+		t.Errorf("stored %s, want the attributes and the trace state of each link", stored)
+	}
+}
+
+// linkedTraceRows the span of the trace linking to the traces, as stored with log timestamps in microseconds.
+func linkedTraceRows(t *testing.T, trace uint64, links ...uint64) []map[string]string {
+	refs := make([]map[string]string, 0)
+	for _, link := range links {
+		refs = append(refs, map[string]string{"TraceID": strconv.FormatUint(link, 16), "SpanID": "1", "RefType": "FOLLOWS_FROM"})
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := storedSpanRow(t, trace, nil)
+	row[TraceID] = model.NewTraceID(0, trace).String()
+	row[Links] = string(data)
+	return []map[string]string{row}
+}
+
+func TestFindTracesWithLinkedTraces(t *testing.T) {
+	traces := map[model.TraceID][]map[string]string{
+		model.NewTraceID(0, 1): linkedTraceRows(t, 1, 2, 3, 4),
+		model.NewTraceID(0, 2): linkedTraceRows(t, 2, 1),
+		model.NewTraceID(0, 3): linkedTraceRows(t, 3, 5),
+		// trace 4 is not found, trace 5 is only linked by a linked trace
+		model.NewTraceID(0, 5): linkedTraceRows(t, 5),
+	}
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:  "frontend",
+		StartTimeMin: time.Unix(1699990000, 0),
+		StartTimeMax: time.Unix(1700010000, 0),
+		NumTraces:    20,
+	}
+
+	tests := []struct {
+		name        string
+		found       int
+		links       string
+		want        string
+		wantWarning string
+	}{
+		{"not asked", 1, "", "[0000000000000001]", ""},
+		{"linked traces", 1, "5", "[0000000000000001 0000000000000002 0000000000000003]", ""},
+		{"at most the asked traces", 1, "1", "[0000000000000001 0000000000000002]", "2 linked traces are not returned, at most 1 are"},
+		{"found traces are not repeated", 2, "1", "[0000000000000001 0000000000000002 0000000000000003]", ""},
+	}
+
+	for _, test := range tests {
+		fake := newFakeSLS(t)
+		var ranges []string
+		fake.getLogs = func(logstore string, q url.Values) ([]map[string]string, string, error) {
+			if strings.Contains(q.Get("query"), "select") {
+				return traceIDRows(test.found), "", nil
+			}
+			ranges = append(ranges, q.Get("from")+"-"+q.Get("to"))
+			for id, rows := range traces {
+				if q.Get("query") == toGetTraceQuery(id) {
+					return rows, "", nil
+				}
+			}
+			return nil, "", nil
+		}
+		plugin := fake.plugin(WithLogTimestampUnit(TimestampUnitMicroseconds))
+
+		q := *query
+		if test.links != "" {
+			q.Tags = map[string]string{LinkedTracesTagKey: test.links}
+		}
+		result, err := plugin.SpanReader().FindTraces(context.Background(), &q)
+		plugin.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		ids := make([]string, 0, len(result))
+		for _, trace := range result {
+			ids = append(ids, trace.Spans[0].TraceID.String())
+			// the linked traces are read with the query options of the reader
+			if logs := trace.Spans[0].Logs; len(logs) != 1 || !logs[0].Timestamp.Equal(time.Unix(1700000000, 123456000)) {
+				t.Errorf("%s: trace %s was not read with the timestamp unit of the reader", test.name, trace.Spans[0].TraceID)
+			}
+		}
+		if got := "[" + strings.Join(ids, " ") + "]"; got != test.want {
+			t.Errorf("%s: returned %s, want %s", test.name, got, test.want)
+		}
+		if warnings := result[0].Spans[0].Warnings; test.wantWarning != "" && !hasWarning(warnings, test.wantWarning) {
+			t.Errorf("%s: warnings %v, want %s", test.name, warnings, test.wantWarning)
+		}
+		for _, r := range ranges {
+			if r != "1699990000-1700010000" {
+				t.Errorf("%s: read a trace in %s, want the time range of the search", test.name, r)
+			}
+		}
+	}
+}
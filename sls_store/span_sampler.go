@@ -0,0 +1,300 @@
+package sls_store
+
+import (
+	"sync"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/hashicorp/go-hclog"
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// SamplingConfig the configuration of storage side sampling
+type SamplingConfig struct {
+	Enabled bool
+	// BufferWindow how long the spans of a trace are buffered before the sampling decision
+	BufferWindow time.Duration
+	// MaxBufferedTraces the max number of buffered traces, the oldest traces are decided early beyond it
+	MaxBufferedTraces int
+	// ProbabilisticRate the ratio of traces kept, decided by trace id so all spans of a trace stay together
+	ProbabilisticRate float64
+	// DefaultRateLimit the max traces per second kept for a service without its own limit, 0 for no limit
+	DefaultRateLimit float64
+	// ServiceRateLimits the max traces per second kept per service
+	ServiceRateLimits map[string]float64
+	// KeepErrors keeps traces having an error span regardless of the rate
+	KeepErrors bool
+	// LatencyThreshold keeps traces having a span longer than it regardless of the rate, 0 to disable
+	LatencyThreshold time.Duration
+	// DecisionTTL how long the decision of a trace is applied to its late spans
+	DecisionTTL time.Duration
+}
+
+// sampledSpan a span buffered by the sampler. log is the span in the SLS layout when the OTLP receiver has converted
+// it already, span then only carries the trace id, parent, duration, status and service the decision reads.
+type sampledSpan struct {
+	span *model.Span
+	log  *slsSdk.Log
+}
+
+type bufferedTrace struct {
+	spans     []sampledSpan
+	firstSeen time.Time
+}
+
+type samplingDecision struct {
+	id      model.TraceID
+	keep    bool
+	expires time.Time
+}
+
+// spanSampler buffers spans per trace and decides whether a whole trace is written when its window is over.
+type spanSampler struct {
+	lock   sync.Mutex
+	config SamplingConfig
+	traces map[model.TraceID]*bufferedTrace
+	order  []model.TraceID
+	// decisions the decisions of recent traces by trace id, decisionOrder in the order they expire
+	decisions     map[model.TraceID]*samplingDecision
+	decisionOrder []*samplingDecision
+	// writeErr the error of the last failed write, returned by the next add
+	writeErr error
+	limiters map[string]*tokenBucket
+	write    func(spans []sampledSpan) error
+	logger   hclog.Logger
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func newSpanSampler(config SamplingConfig, write func(spans []sampledSpan) error, logger hclog.Logger) *spanSampler {
+	s := &spanSampler{
+		config:    config,
+		traces:    make(map[model.TraceID]*bufferedTrace),
+		decisions: make(map[model.TraceID]*samplingDecision),
+		limiters:  make(map[string]*tokenBucket),
+		write:     write,
+		logger:    logger,
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+
+	go s.flushLoop()
+	return s
+}
+
+// add buffers the span until the sampling decision of its trace, spans arriving after the decision follow it. The
+// error of a failed write of a kept trace is returned by the next call, as the spans were already accepted.
+func (s *spanSampler) add(span sampledSpan) error {
+	s.lock.Lock()
+	err := s.writeErr
+	s.writeErr = nil
+	if decision, ok := s.decisions[span.span.TraceID]; ok && time.Now().Before(decision.expires) {
+		s.lock.Unlock()
+		if !decision.keep {
+			incCounter("sampling_dropped_spans", 1)
+			return err
+		}
+
+		incCounter("sampling_kept_spans", 1)
+		if e := s.write([]sampledSpan{span}); e != nil {
+			incCounter("sampling_write_errors", 1)
+			return e
+		}
+		return err
+	}
+
+	trace, ok := s.traces[span.span.TraceID]
+	if !ok {
+		trace = &bufferedTrace{firstSeen: time.Now()}
+		s.traces[span.span.TraceID] = trace
+		s.order = append(s.order, span.span.TraceID)
+	}
+	trace.spans = append(trace.spans, span)
+
+	var evicted []*bufferedTrace
+	for s.config.MaxBufferedTraces > 0 && len(s.order) > s.config.MaxBufferedTraces {
+		evicted = append(evicted, s.pop())
+	}
+	setGauge("sampling_buffered_traces", int64(len(s.order)))
+	s.lock.Unlock()
+
+	for _, t := range evicted {
+		s.decide(t)
+	}
+	return err
+}
+
+func (s *spanSampler) pop() *bufferedTrace {
+	id := s.order[0]
+	s.order = s.order[1:]
+	trace := s.traces[id]
+	delete(s.traces, id)
+	return trace
+}
+
+func (s *spanSampler) flushLoop() {
+	interval := s.config.BufferWindow / 4
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer close(s.stopped)
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(time.Now().Add(-1 * s.config.BufferWindow))
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// close stops the flush loop and decides all buffered traces, returning the error of the last failed write.
+func (s *spanSampler) close() error {
+	close(s.stop)
+	<-s.stopped
+	s.flush(time.Now().Add(time.Hour))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.writeErr
+}
+
+// flush decides the traces first seen before the deadline, and forgets the expired decisions.
+func (s *spanSampler) flush(deadline time.Time) {
+	s.lock.Lock()
+	var ready []*bufferedTrace
+	for len(s.order) > 0 && s.traces[s.order[0]].firstSeen.Before(deadline) {
+		ready = append(ready, s.pop())
+	}
+	setGauge("sampling_buffered_traces", int64(len(s.order)))
+
+	now := time.Now()
+	for len(s.decisionOrder) > 0 && !now.Before(s.decisionOrder[0].expires) {
+		decision := s.decisionOrder[0]
+		s.decisionOrder = s.decisionOrder[1:]
+		if s.decisions[decision.id] == decision {
+			delete(s.decisions, decision.id)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, trace := range ready {
+		s.decide(trace)
+	}
+}
+
+func (s *spanSampler) decide(trace *bufferedTrace) {
+	keep := s.keep(trace.spans)
+	s.remember(trace.spans[0].span.TraceID, keep)
+	if !keep {
+		incCounter("sampling_dropped_traces", 1)
+		incCounter("sampling_dropped_spans", int64(len(trace.spans)))
+		return
+	}
+
+	incCounter("sampling_kept_traces", 1)
+	incCounter("sampling_kept_spans", int64(len(trace.spans)))
+	if err := s.write(trace.spans); err != nil {
+		incCounter("sampling_write_errors", 1)
+		s.logger.Error("Failed to write sampled trace", "TID", trace.spans[0].span.TraceID, "Exception", err)
+		s.lock.Lock()
+		s.writeErr = err
+		s.lock.Unlock()
+	}
+}
+
+// remember keeps the decision of the trace for its late spans.
+func (s *spanSampler) remember(id model.TraceID, keep bool) {
+	if s.config.DecisionTTL <= 0 {
+		return
+	}
+
+	decision := &samplingDecision{id: id, keep: keep, expires: time.Now().Add(s.config.DecisionTTL)}
+	s.lock.Lock()
+	s.decisions[id] = decision
+	s.decisionOrder = append(s.decisionOrder, decision)
+	s.lock.Unlock()
+}
+
+func (s *spanSampler) keep(spans []sampledSpan) bool {
+	for _, span := range spans {
+		if s.config.KeepErrors && isErrorSpan(span.span) {
+			return true
+		}
+		if s.config.LatencyThreshold > 0 && span.span.Duration >= s.config.LatencyThreshold {
+			return true
+		}
+	}
+
+	if !s.sampledByTraceID(spans[0].span.TraceID) {
+		return false
+	}
+
+	limiter := s.limiter(traceServiceName(spans))
+	return limiter == nil || limiter.allow(1)
+}
+
+// sampledByTraceID the decision only depends on the trace id, so that every collector makes the same one.
+func (s *spanSampler) sampledByTraceID(id model.TraceID) bool {
+	if s.config.ProbabilisticRate >= 1 {
+		return true
+	}
+
+	const buckets = 10000
+	return float64(id.Low%buckets) < s.config.ProbabilisticRate*buckets
+}
+
+func (s *spanSampler) limiter(service string) *tokenBucket {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if limiter, ok := s.limiters[service]; ok {
+		return limiter
+	}
+
+	rate, ok := s.config.ServiceRateLimits[service]
+	if !ok {
+		rate = s.config.DefaultRateLimit
+	}
+	if rate <= 0 {
+		s.limiters[service] = nil
+		return nil
+	}
+
+	limiter := newTokenBucket(rate, rate)
+	s.limiters[service] = limiter
+	return limiter
+}
+
+// traceServiceName the service of the root span, or of the first span when the root span is not buffered.
+func traceServiceName(spans []sampledSpan) string {
+	for _, span := range spans {
+		if span.span.ParentSpanID() == model.NewSpanID(0) && span.span.Process != nil {
+			return span.span.Process.ServiceName
+		}
+	}
+
+	if spans[0].span.Process != nil {
+		return spans[0].span.Process.ServiceName
+	}
+	return ""
+}
+
+func isErrorSpan(span *model.Span) bool {
+	for _, tag := range span.Tags {
+		switch tag.Key {
+		case "error":
+			if tag.AsString() == "true" {
+				return true
+			}
+		case "otel.status_code":
+			if tag.AsString() == "ERROR" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package sls_store
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// recordingWrite records the spans written by the sampler, failing the writes with err when set.
+type recordingWrite struct {
+	lock  sync.Mutex
+	spans []*model.Span
+	err   error
+}
+
+func (r *recordingWrite) write(spans []sampledSpan) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	for _, span := range spans {
+		r.spans = append(r.spans, span.span)
+	}
+	return nil
+}
+
+func (r *recordingWrite) written() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.spans)
+}
+
+// newTestSampler a sampler which only decides when flushed by the test.
+func newTestSampler(t *testing.T, config SamplingConfig) (*spanSampler, *recordingWrite) {
+	config.BufferWindow = time.Hour
+	w := &recordingWrite{}
+	s := newSpanSampler(config, w.write, logger)
+	t.Cleanup(func() { s.close() })
+	return s, w
+}
+
+// decideAll decides every buffered trace.
+func decideAll(s *spanSampler) {
+	s.flush(time.Now().Add(time.Hour))
+}
+
+func sampledTestSpan(low uint64, service string, duration time.Duration, tags ...model.KeyValue) sampledSpan {
+	return sampledSpan{span: &model.Span{
+		TraceID:  model.NewTraceID(0, low),
+		SpanID:   model.NewSpanID(low),
+		Duration: duration,
+		Tags:     tags,
+		Process:  &model.Process{ServiceName: service},
+	}}
+}
+
+func TestSpanSamplerDecisions(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SamplingConfig
+		span   sampledSpan
+		want   bool
+	}{
+		{"rate 1", SamplingConfig{ProbabilisticRate: 1}, sampledTestSpan(1, "a", 0), true},
+		{"rate 0", SamplingConfig{}, sampledTestSpan(1, "a", 0), false},
+		{"under the rate", SamplingConfig{ProbabilisticRate: 0.5}, sampledTestSpan(4999, "a", 0), true},
+		{"over the rate", SamplingConfig{ProbabilisticRate: 0.5}, sampledTestSpan(5000, "a", 0), false},
+		{"error tag", SamplingConfig{KeepErrors: true}, sampledTestSpan(1, "a", 0, model.Bool("error", true)), true},
+		{"error status", SamplingConfig{KeepErrors: true},
+			sampledTestSpan(1, "a", 0, model.String("otel.status_code", "ERROR")), true},
+		{"ok status", SamplingConfig{KeepErrors: true}, sampledTestSpan(1, "a", 0, model.String("otel.status_code", "OK")),
+			false},
+		{"errors not kept", SamplingConfig{}, sampledTestSpan(1, "a", 0, model.Bool("error", true)), false},
+		{"slow span", SamplingConfig{LatencyThreshold: time.Second}, sampledTestSpan(1, "a", 2*time.Second), true},
+		{"fast span", SamplingConfig{LatencyThreshold: time.Second}, sampledTestSpan(1, "a", time.Millisecond), false},
+	}
+
+	for _, test := range tests {
+		s, w := newTestSampler(t, test.config)
+		if err := s.add(test.span); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if w.written() != 0 {
+			t.Errorf("%s: the span was written before the decision", test.name)
+		}
+
+		decideAll(s)
+		if got := w.written() == 1; got != test.want {
+			t.Errorf("%s: kept = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSpanSamplerRateLimit(t *testing.T) {
+	s, w := newTestSampler(t, SamplingConfig{
+		ProbabilisticRate: 1,
+		DefaultRateLimit:  1,
+		ServiceRateLimits: map[string]float64{"unlimited": 100},
+	})
+
+	for i := uint64(1); i <= 3; i++ {
+		s.add(sampledTestSpan(i, "limited", 0))
+		s.add(sampledTestSpan(10+i, "unlimited", 0))
+	}
+	decideAll(s)
+
+	kept := map[string]int{}
+	for _, span := range w.spans {
+		kept[span.Process.ServiceName]++
+	}
+	if kept["limited"] != 1 {
+		t.Errorf("kept %d traces of the limited service, want 1", kept["limited"])
+	}
+	if kept["unlimited"] != 3 {
+		t.Errorf("kept %d traces of the unlimited service, want 3", kept["unlimited"])
+	}
+}
+
+func TestSpanSamplerRateLimitByRootService(t *testing.T) {
+	s, w := newTestSampler(t, SamplingConfig{ProbabilisticRate: 1, ServiceRateLimits: map[string]float64{"root": 1}})
+
+	for i := uint64(1); i <= 2; i++ {
+		child := sampledTestSpan(i, "child", 0)
+		child.span.SpanID = model.NewSpanID(100 + i)
+		child.span.References = []model.SpanRef{model.NewChildOfRef(child.span.TraceID, model.NewSpanID(i))}
+		s.add(child)
+		s.add(sampledTestSpan(i, "root", 0))
+	}
+	decideAll(s)
+
+	if w.written() != 2 {
+		t.Errorf("wrote %d spans, want the 2 spans of one trace limited by its root service", w.written())
+	}
+}
+
+func TestSpanSamplerLateSpans(t *testing.T) {
+	s, w := newTestSampler(t, SamplingConfig{KeepErrors: true, DecisionTTL: time.Hour})
+
+	s.add(sampledTestSpan(1, "a", 0, model.Bool("error", true)))
+	s.add(sampledTestSpan(2, "a", 0))
+	decideAll(s)
+	if w.written() != 1 {
+		t.Fatalf("wrote %d spans, want the error span", w.written())
+	}
+
+	tests := []struct {
+		name string
+		span sampledSpan
+		want int
+	}{
+		{"late span of a kept trace", sampledTestSpan(1, "a", 0), 2},
+		{"late span of a dropped trace", sampledTestSpan(2, "a", 0, model.Bool("error", true)), 2},
+	}
+	for _, test := range tests {
+		if err := s.add(test.span); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if w.written() != test.want {
+			t.Errorf("%s: wrote %d spans, want %d", test.name, w.written(), test.want)
+		}
+	}
+
+	// a late span of a kept trace is written right away, its error returned by the same call
+	w.err = errors.New("write failed")
+	if err := s.add(sampledTestSpan(1, "a", 0)); err != w.err {
+		t.Errorf("add = %v, want the write error", err)
+	}
+}
+
+func TestSpanSamplerWriteErrorReturnedByNextAdd(t *testing.T) {
+	s, w := newTestSampler(t, SamplingConfig{ProbabilisticRate: 1})
+	w.err = errors.New("write failed")
+
+	s.add(sampledTestSpan(1, "a", 0))
+	decideAll(s)
+	w.err = nil
+
+	if err := s.add(sampledTestSpan(2, "a", 0)); err == nil {
+		t.Error("the error of the failed write should be returned by the next add")
+	}
+	if err := s.add(sampledTestSpan(3, "a", 0)); err != nil {
+		t.Errorf("the error should be returned once, got %v", err)
+	}
+}
+
+func TestSpanSamplerMaxBufferedTraces(t *testing.T) {
+	s, w := newTestSampler(t, SamplingConfig{ProbabilisticRate: 1, MaxBufferedTraces: 2})
+
+	for i := uint64(1); i <= 3; i++ {
+		s.add(sampledTestSpan(i, "a", 0))
+	}
+	if w.written() != 1 || w.spans[0].TraceID.Low != 1 {
+		t.Errorf("the oldest trace should be decided early beyond the max buffered traces, wrote %d spans", w.written())
+	}
+}
@@ -0,0 +1,345 @@
+package sls_store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// TruncatedMarker the suffix of values truncated by the size limits
+const TruncatedMarker = "...[truncated]"
+
+// SizeLimits the size limits of spans written to SLS, 0 for no limit
+type SizeLimits struct {
+	// MaxFieldSize the max bytes of one tag value or log field value
+	MaxFieldSize int
+	// MaxSpanSize the max bytes of one span, log events are dropped first and then the largest tags are cut
+	MaxSpanSize int
+}
+
+func (l SizeLimits) enabled() bool {
+	return l.MaxFieldSize > 0 || l.MaxSpanSize > 0
+}
+
+// apply returns a copy of the span within the limits, with a warning recording what was cut. The span itself is
+// not modified.
+func (l SizeLimits) apply(span *model.Span) *model.Span {
+	if !l.enabled() {
+		return span
+	}
+
+	limited := *span
+	cut := make(map[string]bool)
+	limited.Tags = l.truncateKeyValues(span.Tags, tagScopeAttribute, cut)
+	if span.Process != nil {
+		process := *span.Process
+		process.Tags = l.truncateKeyValues(span.Process.Tags, tagScopeResource, cut)
+		limited.Process = &process
+	}
+	limited.Logs = make([]model.Log, len(span.Logs))
+	for i, log := range span.Logs {
+		limited.Logs[i] = model.Log{
+			Timestamp: log.Timestamp,
+			Fields:    l.truncateKeyValues(log.Fields, tagScopeLog, cut),
+		}
+	}
+
+	dropped := 0
+	if l.MaxSpanSize > 0 {
+		for estimateSpanSize(&limited) > l.MaxSpanSize && len(limited.Logs) > 0 {
+			limited.Logs = dropLowestPriorityLog(limited.Logs)
+			dropped++
+		}
+		for estimateSpanSize(&limited) > l.MaxSpanSize {
+			if !shrinkLargestTag(&limited, cut) {
+				break
+			}
+		}
+	}
+
+	if len(cut) == 0 && dropped == 0 {
+		return span
+	}
+
+	limited.Warnings = append([]string(nil), span.Warnings...)
+	if len(cut) > 0 {
+		fields := make([]string, 0, len(cut))
+		for field := range cut {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		limited.Warnings = append(limited.Warnings, fmt.Sprintf("truncated oversized fields: %s", strings.Join(fields, ", ")))
+		incCounter("truncated_fields", int64(len(fields)))
+	}
+	if dropped > 0 {
+		limited.Warnings = append(limited.Warnings, fmt.Sprintf("dropped %d of %d log events exceeding the span size limit", dropped, len(span.Logs)))
+		incCounter("dropped_span_logs", int64(dropped))
+	}
+	return &limited
+}
+
+func (l SizeLimits) truncateKeyValues(kvs []model.KeyValue, scope string, cut map[string]bool) []model.KeyValue {
+	result := make([]model.KeyValue, len(kvs))
+	for i, kv := range kvs {
+		result[i] = kv
+		if l.MaxFieldSize <= 0 {
+			continue
+		}
+
+		if truncated, ok := truncateKeyValue(kv, l.MaxFieldSize); ok {
+			result[i] = truncated
+			cut[scope+"."+kv.Key] = true
+		}
+	}
+	return result
+}
+
+// truncateKeyValue cuts a string or binary value longer than size bytes, keeping its type. Binary values are
+// measured and cut by their raw bytes, without the marker. Numbers and booleans are never cut.
+func truncateKeyValue(kv model.KeyValue, size int) (model.KeyValue, bool) {
+	if size < 0 {
+		size = 0
+	}
+
+	switch kv.VType {
+	case model.StringType:
+		if len(kv.VStr) > size {
+			return model.String(kv.Key, truncateValue(kv.VStr, size)), true
+		}
+	case model.BinaryType:
+		if len(kv.VBinary) > size {
+			return model.Binary(kv.Key, kv.VBinary[:size:size]), true
+		}
+	}
+	return kv, false
+}
+
+// valueSize the bytes truncateKeyValue measures, 0 for values which are never cut.
+func valueSize(kv model.KeyValue) int {
+	switch kv.VType {
+	case model.StringType:
+		return len(kv.VStr)
+	case model.BinaryType:
+		return len(kv.VBinary)
+	}
+	return 0
+}
+
+// truncateValue cuts the value at a rune boundary so that it fits into size bytes together with the marker. The
+// marker is left out when the size can't hold it.
+func truncateValue(value string, size int) string {
+	if size < 0 {
+		size = 0
+	}
+	if len(value) <= size {
+		return value
+	}
+
+	marker := TruncatedMarker
+	if size < len(marker) {
+		marker = ""
+	}
+	keep := size - len(marker)
+	for keep > 0 && !utf8.RuneStart(value[keep]) {
+		keep--
+	}
+	return value[:keep] + marker
+}
+
+// estimateSpanSize the approximate bytes of the span in the SLS layout, including the json quoting of tags and logs.
+func estimateSpanSize(span *model.Span) int {
+	const fixedFieldsSize = 512
+	size := fixedFieldsSize + len(span.OperationName)
+	size += keyValuesSize(span.Tags)
+	if span.Process != nil {
+		size += len(span.Process.ServiceName) + keyValuesSize(span.Process.Tags)
+	}
+	for _, log := range span.Logs {
+		size += 40 + keyValuesSize(log.Fields)
+	}
+	for _, warning := range span.Warnings {
+		size += len(warning) + 3
+	}
+	return size
+}
+
+func keyValuesSize(kvs []model.KeyValue) int {
+	size := 2
+	for _, kv := range kvs {
+		size += len(kv.Key) + len(kv.AsString()) + 6
+	}
+	return size
+}
+
+// dropLowestPriorityLog removes the latest log event which is not an error, or the latest one when all are errors.
+func dropLowestPriorityLog(logs []model.Log) []model.Log {
+	index := len(logs) - 1
+	for i := len(logs) - 1; i >= 0; i-- {
+		if !isErrorLog(logs[i]) {
+			index = i
+			break
+		}
+	}
+	return append(logs[:index:index], logs[index+1:]...)
+}
+
+func isErrorLog(log model.Log) bool {
+	for _, field := range log.Fields {
+		if field.Key == "error" || strings.HasPrefix(field.Key, "exception.") || field.Key == "error.object" {
+			return true
+		}
+		if field.Key == "event" || field.Key == "level" {
+			switch strings.ToLower(field.AsString()) {
+			case "error", "exception", "fatal":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shrinkLargestTag halves the largest string or binary tag value of the span, returning false when nothing can be
+// cut any more.
+func shrinkLargestTag(span *model.Span, cut map[string]bool) bool {
+	var largest *model.KeyValue
+	scope := ""
+	find := func(kvs []model.KeyValue, s string) {
+		for i := range kvs {
+			if largest == nil || valueSize(kvs[i]) > valueSize(*largest) {
+				largest, scope = &kvs[i], s
+			}
+		}
+	}
+	find(span.Tags, tagScopeAttribute)
+	if span.Process != nil {
+		find(span.Process.Tags, tagScopeResource)
+	}
+
+	if largest == nil {
+		return false
+	}
+	size := valueSize(*largest)
+	if size <= 2*len(TruncatedMarker) {
+		return false
+	}
+
+	*largest, _ = truncateKeyValue(*largest, size/2)
+	cut[scope+"."+largest.Key] = true
+	return true
+}
+
+// applyOTLP cuts the attributes, events and link attributes of an OTLP span already in the SLS layout in place,
+// the same way apply cuts a span, and returns the warnings recording what was cut. The resource is shared by the
+// spans of a resource, so it is only truncated by truncateMap, and counted in the span size.
+func (l SizeLimits) applyOTLP(attributes map[string]string, events []SpanLog, links []map[string]string, resourceSize int,
+	cut map[string]bool) ([]SpanLog, []string) {
+	if !l.enabled() {
+		return events, nil
+	}
+
+	l.truncateMap(attributes, tagScopeAttribute, cut)
+	for _, event := range events {
+		l.truncateMap(event.Attribute, tagScopeLog, cut)
+	}
+	for _, link := range links {
+		l.truncateMap(link, "link", cut)
+	}
+
+	total, dropped := len(events), 0
+	if l.MaxSpanSize > 0 {
+		size := func() int {
+			size := 512 + resourceSize + mapSize(attributes)
+			for _, event := range events {
+				size += 40 + mapSize(event.Attribute)
+			}
+			for _, link := range links {
+				size += mapSize(link)
+			}
+			return size
+		}
+		for size() > l.MaxSpanSize && len(events) > 0 {
+			events = dropLowestPriorityEvent(events)
+			dropped++
+		}
+		for size() > l.MaxSpanSize {
+			if !shrinkLargestValue(attributes, tagScopeAttribute, cut) {
+				break
+			}
+		}
+	}
+
+	var warnings []string
+	if len(cut) > 0 {
+		fields := make([]string, 0, len(cut))
+		for field := range cut {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		warnings = append(warnings, fmt.Sprintf("truncated oversized fields: %s", strings.Join(fields, ", ")))
+		incCounter("truncated_fields", int64(len(fields)))
+	}
+	if dropped > 0 {
+		warnings = append(warnings, fmt.Sprintf("dropped %d of %d log events exceeding the span size limit", dropped, total))
+		incCounter("dropped_span_logs", int64(dropped))
+	}
+	return events, warnings
+}
+
+// truncateMap truncates the values of the map longer than the field size limit in place.
+func (l SizeLimits) truncateMap(m map[string]string, scope string, cut map[string]bool) {
+	if l.MaxFieldSize <= 0 {
+		return
+	}
+
+	for key, value := range m {
+		if len(value) > l.MaxFieldSize {
+			m[key] = truncateValue(value, l.MaxFieldSize)
+			cut[scope+"."+key] = true
+		}
+	}
+}
+
+func mapSize(m map[string]string) int {
+	size := 2
+	for key, value := range m {
+		size += len(key) + len(value) + 6
+	}
+	return size
+}
+
+// dropLowestPriorityEvent removes the latest event which is not an error, or the latest one when all are errors.
+func dropLowestPriorityEvent(events []SpanLog) []SpanLog {
+	index := len(events) - 1
+	for i := len(events) - 1; i >= 0; i-- {
+		log := model.Log{Fields: make([]model.KeyValue, 0, len(events[i].Attribute))}
+		for key, value := range events[i].Attribute {
+			log.Fields = append(log.Fields, model.String(key, value))
+		}
+		if !isErrorLog(log) {
+			index = i
+			break
+		}
+	}
+	return append(events[:index:index], events[index+1:]...)
+}
+
+// shrinkLargestValue halves the largest value of the map, returning false when nothing can be cut any more.
+func shrinkLargestValue(m map[string]string, scope string, cut map[string]bool) bool {
+	largest, value := "", ""
+	for k, v := range m {
+		if len(v) > len(value) {
+			largest, value = k, v
+		}
+	}
+
+	if len(value) <= 2*len(TruncatedMarker) {
+		return false
+	}
+
+	m[largest] = truncateValue(value, len(value)/2)
+	cut[scope+"."+largest] = true
+	return true
+}
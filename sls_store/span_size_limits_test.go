@@ -0,0 +1,200 @@
+package sls_store
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/jaegertracing/jaeger/model"
+	commonV1 "go.opentelemetry.io/proto/otlp/common/v1"
+	traceV1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+func TestTruncateValue(t *testing.T) {
+	long := strings.Repeat("a", 30)
+	tests := []struct {
+		name  string
+		value string
+		size  int
+		want  string
+	}{
+		{"fits", "abc", 3, "abc"},
+		{"cut with the marker", long, 20, long[:20-len(TruncatedMarker)] + TruncatedMarker},
+		{"smaller than the marker", long, 5, "aaaaa"},
+		{"negative size", long, -1, ""},
+		{"rune boundary", "ééééé", 5, "éé"},
+		{"rune boundary with the marker", strings.Repeat("é", 20), 17, "é" + TruncatedMarker},
+	}
+
+	for _, test := range tests {
+		got := truncateValue(test.value, test.size)
+		if got != test.want {
+			t.Errorf("%s: truncateValue = %q, want %q", test.name, got, test.want)
+		}
+		if test.size >= 0 && len(got) > test.size {
+			t.Errorf("%s: %d bytes exceed the size %d", test.name, len(got), test.size)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: %q is not valid utf8", test.name, got)
+		}
+	}
+}
+
+func TestTruncateKeyValuesKeepsTypes(t *testing.T) {
+	limits := SizeLimits{MaxFieldSize: 20}
+	binary := bytes.Repeat([]byte{0xff}, 15)
+	tests := []struct {
+		name string
+		kv   model.KeyValue
+		want model.KeyValue
+		cut  bool
+	}{
+		{"short string", model.String("k", "short"), model.String("k", "short"), false},
+		{"long string", model.String("k", strings.Repeat("a", 30)),
+			model.String("k", strings.Repeat("a", 20-len(TruncatedMarker))+TruncatedMarker), true},
+		// 15 raw bytes are 30 hex digits, only the raw bytes are measured
+		{"binary within the limit", model.Binary("k", binary), model.Binary("k", binary), false},
+		{"long binary", model.Binary("k", bytes.Repeat([]byte{0xff}, 30)), model.Binary("k", bytes.Repeat([]byte{0xff}, 20)), true},
+		{"int", model.Int64("k", -1234567890123456789), model.Int64("k", -1234567890123456789), false},
+		{"float", model.Float64("k", 1.0/3), model.Float64("k", 1.0/3), false},
+		{"bool", model.Bool("k", true), model.Bool("k", true), false},
+	}
+
+	for _, test := range tests {
+		cut := make(map[string]bool)
+		got := limits.truncateKeyValues([]model.KeyValue{test.kv}, tagScopeAttribute, cut)
+		if !got[0].Equal(&test.want) {
+			t.Errorf("%s: truncated to %v (%v), want %v (%v)", test.name, got[0].Value(), got[0].VType, test.want.Value(), test.want.VType)
+		}
+		if cut["attribute.k"] != test.cut {
+			t.Errorf("%s: cut = %v, want %v", test.name, cut["attribute.k"], test.cut)
+		}
+	}
+}
+
+func sizeLimitsTestLog(fields ...model.KeyValue) model.Log {
+	return model.Log{Fields: fields}
+}
+
+func TestDropLowestPriorityLog(t *testing.T) {
+	info1 := sizeLimitsTestLog(model.String("event", "info1"))
+	info2 := sizeLimitsTestLog(model.String("event", "info2"))
+	errorEvent := sizeLimitsTestLog(model.String("event", "error"))
+	exception := sizeLimitsTestLog(model.String("exception.message", "boom"))
+	errorLevel := sizeLimitsTestLog(model.String("level", "ERROR"))
+
+	tests := []struct {
+		name string
+		logs []model.Log
+		want []model.Log
+	}{
+		{"latest non error log", []model.Log{info1, info2, errorEvent}, []model.Log{info1, errorEvent}},
+		{"non error log before errors", []model.Log{info1, exception, errorLevel}, []model.Log{exception, errorLevel}},
+		{"latest log when all are errors", []model.Log{errorEvent, exception, errorLevel}, []model.Log{errorEvent, exception}},
+		{"single log", []model.Log{info1}, []model.Log{}},
+	}
+
+	for _, test := range tests {
+		got := dropLowestPriorityLog(append([]model.Log(nil), test.logs...))
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: kept %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSizeLimitsApplyDropsLogsFirst(t *testing.T) {
+	span := &model.Span{
+		Tags: []model.KeyValue{model.String("tag", strings.Repeat("t", 100))},
+		Logs: []model.Log{
+			sizeLimitsTestLog(model.String("exception.message", strings.Repeat("e", 200))),
+			sizeLimitsTestLog(model.String("event", strings.Repeat("1", 200))),
+			sizeLimitsTestLog(model.String("event", strings.Repeat("2", 200))),
+		},
+	}
+	limits := SizeLimits{MaxSpanSize: estimateSpanSize(span) - 100}
+
+	limited := limits.apply(span)
+	if len(limited.Logs) != 2 || limited.Logs[1].Fields[0].VStr[0] != '1' {
+		t.Errorf("the latest non error log should be dropped first, kept %v", limited.Logs)
+	}
+	if limited.Tags[0].VStr != span.Tags[0].VStr {
+		t.Errorf("the tag should not be cut once dropping a log is enough")
+	}
+	if len(span.Logs) != 3 {
+		t.Errorf("the span itself should not be modified")
+	}
+	if len(limited.Warnings) != 1 || limited.Warnings[0] != "dropped 1 of 3 log events exceeding the span size limit" {
+		t.Errorf("unexpected warnings %v", limited.Warnings)
+	}
+}
+
+func TestSizeLimitsApplyShrinksLargestTag(t *testing.T) {
+	span := &model.Span{
+		Tags: []model.KeyValue{
+			model.String("small", strings.Repeat("s", 100)),
+			model.String("large", strings.Repeat("l", 1000)),
+			model.Int64("number", 1),
+		},
+		Logs: []model.Log{sizeLimitsTestLog(model.String("event", "info"))},
+	}
+	limits := SizeLimits{MaxSpanSize: estimateSpanSize(span) - 400}
+
+	limited := limits.apply(span)
+	if len(limited.Logs) != 0 {
+		t.Errorf("the logs should be dropped before the tags are cut")
+	}
+	if len(limited.Tags[1].VStr) >= 1000 || !strings.HasSuffix(limited.Tags[1].VStr, TruncatedMarker) {
+		t.Errorf("the largest tag should be cut, got %d bytes", len(limited.Tags[1].VStr))
+	}
+	if limited.Tags[0].VStr != span.Tags[0].VStr || limited.Tags[2].VType != model.Int64Type {
+		t.Errorf("the other tags should be kept, got %v", limited.Tags)
+	}
+	if estimateSpanSize(limited) > limits.MaxSpanSize {
+		t.Errorf("the span of %d bytes exceeds the limit %d", estimateSpanSize(limited), limits.MaxSpanSize)
+	}
+}
+
+func TestOTLPWarningsStoredAsJSONArray(t *testing.T) {
+	span := otlpTestSpan(1, traceV1.Status_STATUS_CODE_ERROR,
+		&commonV1.KeyValue{Key: "large", Value: &commonV1.AnyValue{Value: &commonV1.AnyValue_StringValue{StringValue: strings.Repeat("a", 100)}}})
+	span.Status.Message = "request failed"
+
+	spans, _, err := convertOTLPSpans(otlpTestRequest(span).GetResourceSpans(), SizeLimits{MaxFieldSize: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statusMessage string
+	for _, content := range spans[0].log.Contents {
+		if content.GetKey() == StatusMessage {
+			statusMessage = content.GetValue()
+		}
+	}
+
+	want := `["request failed","truncated oversized fields: attribute.large"]`
+	if statusMessage != want {
+		t.Errorf("statusMessage = %s, want %s", statusMessage, want)
+	}
+	if got := unmarshalWarnings(statusMessage); len(got) != 2 || got[0] != "request failed" {
+		t.Errorf("warnings read back as %v", got)
+	}
+}
+
+func TestUnmarshalWarnings(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"", nil},
+		{`["a","b"]`, []string{"a", "b"}},
+		{"plain status message", []string{"plain status message"}},
+		{"[not json", []string{"[not json"}},
+	}
+
+	for _, test := range tests {
+		if got := unmarshalWarnings(test.value); fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("unmarshalWarnings(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
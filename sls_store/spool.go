@@ -0,0 +1,386 @@
+package sls_store
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	spoolSegmentSuffix = ".wal"
+	spoolRecordHeader  = 8
+)
+
+// SpoolConfig the configuration of the local spool of log groups failed to send
+type SpoolConfig struct {
+	// Dir the directory of the spool segments, empty to disable the spool
+	Dir string
+	// MaxBytes the max total size of the segments, the oldest segments are dropped beyond it
+	MaxBytes int64
+	// MaxAge the max age of a segment, older segments are dropped without being replayed
+	MaxAge time.Duration
+	// SegmentSize the size at which the active segment is closed and a new one is started
+	SegmentSize int64
+}
+
+type spoolSegment struct {
+	path    string
+	size    int64
+	created time.Time
+}
+
+// spool a segment based write ahead log on disk. Log groups SLS did not accept are appended to the active segment,
+// closed segments are replayed oldest first with backoff and removed once sent. Delivery is at least once, a
+// segment partially replayed before a restart is replayed again from its beginning.
+type spool struct {
+	lock     sync.Mutex
+	config   SpoolConfig
+	segments []spoolSegment
+	active   *os.File
+	current  spoolSegment
+	lastSeq  int64
+	replayed map[string]int
+	send     func(context.Context, *slsSdk.LogGroup) error
+	logger   hclog.Logger
+	// ctx is canceled by close, which stops the replay loop and the send in progress
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// newSpool recovers the segments of the directory and starts replaying them.
+func newSpool(config SpoolConfig, send func(context.Context, *slsSdk.LogGroup) error, logger hclog.Logger) (*spool, error) {
+	s, err := openSpool(config, send, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	s.stopped = make(chan struct{})
+	go s.replayLoop()
+	return s, nil
+}
+
+// openSpool recovers the segments of the directory without replaying them.
+func openSpool(config SpoolConfig, send func(context.Context, *slsSdk.LogGroup) error, logger hclog.Logger) (*spool, error) {
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &spool{
+		config:   config,
+		replayed: make(map[string]int),
+		send:     send,
+		logger:   logger,
+		stopped:  make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	close(s.stopped)
+
+	files, err := ioutil.ReadDir(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		seq, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), spoolSegmentSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(file.Name(), spoolSegmentSuffix) {
+			continue
+		}
+		s.segments = append(s.segments, spoolSegment{
+			path:    filepath.Join(config.Dir, file.Name()),
+			size:    file.Size(),
+			created: time.Unix(0, seq),
+		})
+		if seq > s.lastSeq {
+			s.lastSeq = seq
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].created.Before(s.segments[j].created)
+	})
+
+	if len(s.segments) > 0 {
+		logger.Info("Recovered spooled segments", "Segments", len(s.segments), "Dir", config.Dir)
+	}
+	s.updateGauges()
+	return s, nil
+}
+
+// close stops the replay loop and closes the active segment, which is replayed after a restart. An empty active
+// segment is removed.
+func (s *spool) close() {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		<-s.stopped
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.active != nil && s.current.size == 0 {
+			path := s.current.path
+			s.closeActive()
+			s.removeSegment(path)
+		}
+		s.closeActive()
+		s.updateGauges()
+	})
+}
+
+// append writes the log group to the active segment and syncs it to disk.
+func (s *spool) append(lg *slsSdk.LogGroup) error {
+	data, err := lg.Marshal()
+	if err != nil {
+		return err
+	}
+
+	record := make([]byte, spoolRecordHeader+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[spoolRecordHeader:], data)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.active == nil || s.current.size >= s.config.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	s.current.size += int64(len(record))
+
+	incCounter("spool_appended_logs", int64(len(lg.Logs)))
+	s.enforceLimits()
+	s.updateGauges()
+	return nil
+}
+
+// rotate closes the active segment and starts a new one, must be called with the lock held.
+func (s *spool) rotate() error {
+	s.closeActive()
+
+	seq := time.Now().UnixNano()
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	s.lastSeq = seq
+
+	path := filepath.Join(s.config.Dir, fmt.Sprintf("%020d%s", seq, spoolSegmentSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.active = file
+	s.current = spoolSegment{path: path, created: time.Unix(0, seq)}
+	return nil
+}
+
+func (s *spool) closeActive() {
+	if s.active == nil {
+		return
+	}
+
+	if err := s.active.Close(); err != nil {
+		s.logger.Warn("Failed to close spool segment", "Path", s.current.path, "Exception", err)
+	}
+	s.segments = append(s.segments, s.current)
+	s.active = nil
+	s.current = spoolSegment{}
+}
+
+// enforceLimits drops the closed segments beyond the size and age limits, must be called with the lock held.
+func (s *spool) enforceLimits() {
+	total := s.current.size
+	for _, segment := range s.segments {
+		total += segment.size
+	}
+
+	expiry := time.Now().Add(-1 * s.config.MaxAge)
+	for len(s.segments) > 0 {
+		oldest := s.segments[0]
+		expired := s.config.MaxAge > 0 && oldest.created.Before(expiry)
+		if !expired && (s.config.MaxBytes <= 0 || total <= s.config.MaxBytes) {
+			break
+		}
+
+		s.logger.Warn("Dropping spool segment", "Path", oldest.path, "Size", oldest.size, "Expired", expired)
+		incCounter("spool_dropped_bytes", oldest.size)
+		total -= oldest.size
+		s.removeSegment(oldest.path)
+	}
+}
+
+// removeSegment deletes the segment file, must be called with the lock held.
+func (s *spool) removeSegment(path string) {
+	for i, segment := range s.segments {
+		if segment.path == path {
+			s.segments = append(s.segments[:i:i], s.segments[i+1:]...)
+			break
+		}
+	}
+	delete(s.replayed, path)
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		s.logger.Warn("Failed to remove spool segment", "Path", path, "Exception", err)
+	}
+}
+
+func (s *spool) updateGauges() {
+	total := s.current.size
+	for _, segment := range s.segments {
+		total += segment.size
+	}
+
+	segments := len(s.segments)
+	if s.active != nil {
+		segments++
+	}
+	setGauge("spool_bytes", total)
+	setGauge("spool_segments", int64(segments))
+}
+
+func (s *spool) replayLoop() {
+	defer close(s.stopped)
+
+	backoff := SpoolMinBackoff
+	for {
+		wait := SpoolReplayInterval
+		if err := s.replayOnce(); err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+			s.logger.Warn("Failed to replay spooled logs", "Backoff", backoff, "Exception", err)
+			wait = backoff
+			backoff *= 2
+			if backoff > SpoolMaxBackoff {
+				backoff = SpoolMaxBackoff
+			}
+		} else {
+			backoff = SpoolMinBackoff
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// replayOnce sends the oldest segment, closing the active segment first when it is the only one with data.
+func (s *spool) replayOnce() error {
+	s.lock.Lock()
+	s.enforceLimits()
+	if len(s.segments) == 0 && s.active != nil && s.current.size > 0 {
+		s.closeActive()
+	}
+	if len(s.segments) == 0 {
+		s.updateGauges()
+		s.lock.Unlock()
+		return nil
+	}
+	segment := s.segments[0]
+	start := s.replayed[segment.path]
+	s.lock.Unlock()
+
+	groups, err := readSpoolSegment(segment.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		s.logger.Warn("Spool segment is corrupted, replaying the readable part", "Path", segment.path, "Exception", err)
+	}
+
+	for i := start; i < len(groups); i++ {
+		if err := s.send(s.ctx, groups[i]); err != nil {
+			if isRetryableError(err) || s.ctx.Err() != nil {
+				s.lock.Lock()
+				s.replayed[segment.path] = i
+				s.lock.Unlock()
+				return err
+			}
+			s.logger.Error("SLS rejected spooled logs, dropping them", "Exception", err)
+			incCounter("spool_rejected_logs", int64(len(groups[i].Logs)))
+			continue
+		}
+		incCounter("spool_replayed_logs", int64(len(groups[i].Logs)))
+	}
+
+	s.lock.Lock()
+	s.removeSegment(segment.path)
+	s.updateGauges()
+	s.lock.Unlock()
+	return nil
+}
+
+// readSpoolSegment reads the log groups of the segment, stopping at the first torn or corrupted record.
+func readSpoolSegment(path string) ([]*slsSdk.LogGroup, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []*slsSdk.LogGroup
+	for len(data) > 0 {
+		if len(data) < spoolRecordHeader {
+			return groups, errors.New("torn record header")
+		}
+
+		size := int(binary.BigEndian.Uint32(data[0:4]))
+		if len(data) < spoolRecordHeader+size {
+			return groups, errors.New("torn record")
+		}
+
+		payload := data[spoolRecordHeader : spoolRecordHeader+size]
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(data[4:8]) {
+			return groups, errors.New("record checksum mismatch")
+		}
+
+		lg := &slsSdk.LogGroup{}
+		if err := lg.Unmarshal(payload); err != nil {
+			return groups, err
+		}
+		groups = append(groups, lg)
+		data = data[spoolRecordHeader+size:]
+	}
+
+	return groups, nil
+}
+
+// isRetryableError client errors other than throttling will fail again, so they are not worth spooling. A canceled
+// or timed out request was given up by the caller, so it is not retried either.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	slsErr, ok := err.(*slsSdk.Error)
+	if !ok || slsErr.HTTPCode < 400 || slsErr.HTTPCode >= 500 {
+		return true
+	}
+
+	switch slsErr.HTTPCode {
+	case 403, 408, 429:
+		return true
+	}
+	return false
+}
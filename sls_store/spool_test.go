@@ -0,0 +1,315 @@
+package sls_store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	slsSdk "github.com/aliyun/aliyun-log-go-sdk"
+	"github.com/gogo/protobuf/proto"
+)
+
+func spoolTestGroup(i int) *slsSdk.LogGroup {
+	return &slsSdk.LogGroup{Logs: []*slsSdk.Log{{
+		Time:     proto.Uint32(uint32(i)),
+		Contents: []*slsSdk.LogContent{{Key: proto.String("i"), Value: proto.String(strconv.Itoa(i))}},
+	}}}
+}
+
+// recordingSend records the log groups replayed by the spool, failing them with the errors of fail when set.
+type recordingSend struct {
+	sent []int
+	fail func(i int) error
+}
+
+func (r *recordingSend) send(ctx context.Context, lg *slsSdk.LogGroup) error {
+	i := int(lg.Logs[0].GetTime())
+	if r.fail != nil {
+		if err := r.fail(i); err != nil {
+			return err
+		}
+	}
+	r.sent = append(r.sent, i)
+	return nil
+}
+
+func openTestSpool(t *testing.T, dir string, config SpoolConfig) (*spool, *recordingSend) {
+	config.Dir = dir
+	r := &recordingSend{}
+	s, err := openSpool(config, r.send, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, r
+}
+
+// replayAll replays the segments until the spool is empty or a replay fails.
+func replayAll(s *spool) error {
+	for {
+		s.lock.Lock()
+		empty := len(s.segments) == 0 && s.current.size == 0
+		s.lock.Unlock()
+		if empty {
+			return nil
+		}
+		if err := s.replayOnce(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestSpoolRotatesSegments(t *testing.T) {
+	dir := t.TempDir()
+	record := int64(spoolRecordHeader + spoolTestGroup(0).Size())
+	s, _ := openTestSpool(t, dir, SpoolConfig{SegmentSize: 2 * record})
+
+	for i := 0; i < 5; i++ {
+		if err := s.append(spoolTestGroup(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.close()
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 3 {
+		t.Fatalf("got %d segments, want 3 of at most 2 records", len(files))
+	}
+	for i, file := range files {
+		groups, err := readSpoolSegment(filepath.Join(dir, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 2
+		if i == 2 {
+			want = 1
+		}
+		if len(groups) != want {
+			t.Errorf("segment %d has %d records, want %d", i, len(groups), want)
+		}
+	}
+}
+
+func TestSpoolRecoversSegmentsInOrder(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := openTestSpool(t, dir, SpoolConfig{SegmentSize: 1})
+	for i := 0; i < 3; i++ {
+		s.append(spoolTestGroup(i))
+	}
+	s.close()
+
+	recovered, r := openTestSpool(t, dir, SpoolConfig{SegmentSize: 1})
+	if err := replayAll(recovered); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(r.sent) != "[0 1 2]" {
+		t.Errorf("replayed %v, want the segments oldest first", r.sent)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d segments left after the replay", len(files))
+	}
+}
+
+func TestSpoolTornRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		cut  int
+	}{
+		{"torn record", 3},
+		{"torn header", spoolTestGroup(1).Size() + 3},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		s, _ := openTestSpool(t, dir, SpoolConfig{SegmentSize: 1 << 20})
+		s.append(spoolTestGroup(0))
+		s.append(spoolTestGroup(1))
+		path := s.current.path
+		s.close()
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, info.Size()-int64(test.cut)); err != nil {
+			t.Fatal(err)
+		}
+		if groups, err := readSpoolSegment(path); len(groups) != 1 || err == nil {
+			t.Errorf("%s: read %d records and error %v, want the first record and an error", test.name, len(groups), err)
+		}
+
+		recovered, r := openTestSpool(t, dir, SpoolConfig{})
+		if err := recovered.replayOnce(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if fmt.Sprint(r.sent) != "[0]" {
+			t.Errorf("%s: replayed %v, want the readable record", test.name, r.sent)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s: the torn segment should be removed after its readable part is replayed", test.name)
+		}
+	}
+}
+
+func TestSpoolChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := openTestSpool(t, dir, SpoolConfig{SegmentSize: 1 << 20})
+	s.append(spoolTestGroup(0))
+	s.append(spoolTestGroup(1))
+	path := s.current.path
+	s.close()
+
+	data, _ := ioutil.ReadFile(path)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(path, data, 0644)
+
+	if groups, err := readSpoolSegment(path); len(groups) != 1 || err == nil {
+		t.Errorf("read %d records and error %v, want the first record and a checksum error", len(groups), err)
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	record := int64(spoolRecordHeader + spoolTestGroup(0).Size())
+	tests := []struct {
+		name   string
+		config SpoolConfig
+		// age the age of the recovered segments
+		age  time.Duration
+		want string
+	}{
+		{"max bytes", SpoolConfig{MaxBytes: 2 * record}, 0, "[3 4]"},
+		{"max age", SpoolConfig{MaxAge: time.Hour}, 2 * time.Hour, "[4]"},
+		{"no limits", SpoolConfig{}, 2 * time.Hour, "[0 1 2 3 4]"},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		// the segments of a previous run, one record each, named by their creation time
+		created := time.Now().Add(-test.age)
+		for i := 0; i < 4; i++ {
+			s, _ := openTestSpool(t, t.TempDir(), SpoolConfig{})
+			s.append(spoolTestGroup(i))
+			data, _ := ioutil.ReadFile(s.current.path)
+			s.close()
+			name := fmt.Sprintf("%020d%s", created.Add(time.Duration(i)).UnixNano(), spoolSegmentSuffix)
+			ioutil.WriteFile(filepath.Join(dir, name), data, 0644)
+		}
+
+		config := test.config
+		config.SegmentSize = 1
+		s, r := openTestSpool(t, dir, config)
+		if err := s.append(spoolTestGroup(4)); err != nil {
+			t.Fatal(err)
+		}
+		if err := replayAll(s); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(r.sent) != test.want {
+			t.Errorf("%s: replayed %v, want %s", test.name, r.sent, test.want)
+		}
+	}
+}
+
+func TestSpoolReplayErrors(t *testing.T) {
+	dir := t.TempDir()
+	s, r := openTestSpool(t, dir, SpoolConfig{SegmentSize: 1 << 20})
+	for i := 0; i < 4; i++ {
+		s.append(spoolTestGroup(i))
+	}
+
+	// an unavailable SLS stops the replay at the failed record, which is retried next time
+	r.fail = func(i int) error {
+		if i == 2 {
+			return &slsSdk.Error{HTTPCode: http.StatusServiceUnavailable}
+		}
+		return nil
+	}
+	if err := s.replayOnce(); err == nil {
+		t.Fatal("the replay should fail")
+	}
+	if fmt.Sprint(r.sent) != "[0 1]" {
+		t.Errorf("replayed %v before the failure", r.sent)
+	}
+
+	// a record SLS rejects is dropped, the others are replayed from the failed one
+	r.fail = func(i int) error {
+		if i == 2 {
+			return &slsSdk.Error{HTTPCode: http.StatusBadRequest}
+		}
+		return nil
+	}
+	if err := s.replayOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(r.sent) != "[0 1 3]" {
+		t.Errorf("replayed %v, want the rejected record dropped", r.sent)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d segments left after the replay", len(files))
+	}
+}
+
+func TestSpoolCloseKeepsRecordsOfCanceledReplay(t *testing.T) {
+	dir := t.TempDir()
+	s, r := openTestSpool(t, dir, SpoolConfig{SegmentSize: 1})
+	s.append(spoolTestGroup(0))
+	s.append(spoolTestGroup(1))
+
+	s.cancel()
+	r.fail = func(int) error { return context.Canceled }
+	if err := s.replayOnce(); err == nil {
+		t.Fatal("the canceled replay should fail")
+	}
+	s.close()
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 2 {
+		t.Errorf("%d segments left, want the 2 segments kept for the next run", len(files))
+	}
+}
+
+func TestSpoolCloseStopsReplayLoop(t *testing.T) {
+	s, err := newSpool(SpoolConfig{Dir: t.TempDir()}, func(context.Context, *slsSdk.LogGroup) error { return nil }, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.close()
+		s.close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("close did not stop the replay loop")
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"network error", errors.New("connection refused"), true},
+		{"server error", &slsSdk.Error{HTTPCode: http.StatusInternalServerError}, true},
+		{"throttled", &slsSdk.Error{HTTPCode: http.StatusTooManyRequests}, true},
+		{"quota exceeded", &slsSdk.Error{HTTPCode: http.StatusForbidden}, true},
+		{"bad request", &slsSdk.Error{HTTPCode: http.StatusBadRequest}, false},
+		{"canceled", context.Canceled, false},
+		{"deadline exceeded", fmt.Errorf("put logs: %w", context.DeadlineExceeded), false},
+	}
+
+	for _, test := range tests {
+		if got := isRetryableError(test.err); got != test.want {
+			t.Errorf("%s: isRetryableError = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package sls_store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	tagScopeAttribute = "attribute"
+	tagScopeResource  = "resource"
+	tagScopeLog       = "log"
+	// tagScopeLogGroup the __topic__, __source__ and __tag__:<key> dimensions of the log group
+	tagScopeLogGroup = "loggroup"
+)
+
+// unsafeQueryChars the characters which are not allowed in tag keys and wildcard values, as they would change the
+// meaning of the search statement
+const unsafeQueryChars = " \t\r\n\"'\\():|"
+
+// unsafeWildcardChars the characters not allowed in the wildcard values of span and process tags, which can not be
+// quoted in the search statement, the comparison and range operators of the search syntax included
+const unsafeWildcardChars = unsafeQueryChars + "<>=[]{}#"
+
+// TagQueryPrefix marks the tags written in the query grammar of parseTagCondition, the other tags are exact matches
+// of span tags, whatever their key and value contain.
+const TagQueryPrefix = "@"
+
+type tagOperator int
+
+const (
+	tagEqual tagOperator = iota
+	tagWildcard
+	tagGreater
+	tagGreaterOrEqual
+	tagLess
+	tagLessOrEqual
+)
+
+type tagCondition struct {
+	scope  string
+	key    string
+	op     tagOperator
+	values []string
+	number float64
+	negate bool
+}
+
+// parseTagCondition parses one tag of FindTraces, every tag of the jaeger ui tag box is one condition and
+// conditions are ANDed. A tag is an exact match of the span tag of the same key, unless its key starts with
+// TagQueryPrefix, which opts in to the query grammar:
+//
+//	@[scope.]key=value       exact match, scope is attribute (default), resource or log
+//	@key=value1|value2       matches any of the values
+//	@key!=value              negation, also written as @key=!value
+//	@key=prefix*             wildcard match, * matches any characters and ? matches one character
+//	@key>=100, @key<=100     numeric comparison, also written as @key=>100, @key=>=100, @key=<100, @key=<=100
+//	@__topic__=value         matches the topic, __source__ and __tag__:<key> match the source and log group tags
+//
+// Numeric comparisons are evaluated by SQL, so they work for attributes and resources which are not indexed as
+// numbers. Log fields support exact, wildcard, negation and OR matches.
+func parseTagCondition(key, value string) (*tagCondition, error) {
+	c := &tagCondition{scope: tagScopeAttribute, op: tagEqual}
+
+	key = strings.TrimSpace(key)
+	if !strings.HasPrefix(key, TagQueryPrefix) {
+		if key == "" {
+			return nil, fmt.Errorf("empty tag key")
+		}
+		if strings.ContainsAny(key, unsafeQueryChars) {
+			return nil, fmt.Errorf("invalid tag key %q", key)
+		}
+		c.key = key
+		c.values = []string{value}
+		return c, nil
+	}
+
+	key = strings.TrimPrefix(key, TagQueryPrefix)
+	switch {
+	case strings.HasSuffix(key, "!"):
+		c.negate = true
+		key = strings.TrimSuffix(key, "!")
+	case strings.HasSuffix(key, ">"):
+		c.op = tagGreaterOrEqual
+		key = strings.TrimSuffix(key, ">")
+	case strings.HasSuffix(key, "<"):
+		c.op = tagLessOrEqual
+		key = strings.TrimSuffix(key, "<")
+	}
+
+	if key == "__topic__" || key == "__source__" || strings.HasPrefix(key, "__tag__:") {
+		c.scope = tagScopeLogGroup
+	} else {
+		for _, scope := range []string{tagScopeAttribute, tagScopeResource, tagScopeLog} {
+			if strings.HasPrefix(key, scope+".") {
+				c.scope = scope
+				key = strings.TrimPrefix(key, scope+".")
+				break
+			}
+		}
+	}
+	c.key = key
+	if c.key == "" {
+		return nil, fmt.Errorf("empty tag key")
+	}
+	if strings.ContainsAny(strings.TrimPrefix(c.key, "__tag__:"), unsafeQueryChars) {
+		return nil, fmt.Errorf("invalid tag key %q", c.key)
+	}
+
+	if c.op == tagEqual {
+		switch {
+		case strings.HasPrefix(value, "!"):
+			c.negate = !c.negate
+			value = strings.TrimPrefix(value, "!")
+		case strings.HasPrefix(value, ">="):
+			c.op, value = tagGreaterOrEqual, strings.TrimPrefix(value, ">=")
+		case strings.HasPrefix(value, ">"):
+			c.op, value = tagGreater, strings.TrimPrefix(value, ">")
+		case strings.HasPrefix(value, "<="):
+			c.op, value = tagLessOrEqual, strings.TrimPrefix(value, "<=")
+		case strings.HasPrefix(value, "<"):
+			c.op, value = tagLess, strings.TrimPrefix(value, "<")
+		}
+	}
+
+	if c.op != tagEqual {
+		if c.scope == tagScopeLog || c.scope == tagScopeLogGroup {
+			return nil, fmt.Errorf("numeric comparison is not supported for %q", c.key)
+		}
+
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, fmt.Errorf("invalid number %q of tag %q", value, c.key)
+		}
+		c.number = number
+		return c, nil
+	}
+
+	c.values = strings.Split(value, "|")
+	if strings.ContainsAny(value, "*?") {
+		c.op = tagWildcard
+		// wildcards of the search statement can not be quoted
+		for _, v := range c.values {
+			if c.scope != tagScopeLog && strings.ContainsAny(v, unsafeWildcardChars) {
+				return nil, fmt.Errorf("invalid wildcard value %q of tag %q", v, c.key)
+			}
+		}
+	}
+	return c, nil
+}
+
+// isNumeric numeric comparisons are evaluated in the analytic statement.
+func (c *tagCondition) isNumeric() bool {
+	return c.op != tagEqual && c.op != tagWildcard
+}
+
+// toSearch renders the condition into the search statement.
+func (c *tagCondition) toSearch() string {
+	field := c.scope + "." + c.key
+	if c.scope == tagScopeLogGroup {
+		field = c.key
+	}
+
+	terms := make([]string, len(c.values))
+	for i, v := range c.values {
+		if c.op == tagWildcard {
+			terms[i] = fmt.Sprintf("%s: %s", field, v)
+		} else {
+			terms[i] = fmt.Sprintf("%s: %s", field, quoteQueryValue(v))
+		}
+	}
+
+	return c.wrap(terms, " or ")
+}
+
+// toSQL renders the condition into the where clause of the analytic statement.
+func (c *tagCondition) toSQL() string {
+	if c.scope == tagScopeLog {
+		terms := make([]string, len(c.values))
+		for i, v := range c.values {
+			pattern := fmt.Sprintf(`%%"%s":"%s"%%`, toLikePattern(jsonString(c.key), false), toLikePattern(jsonString(v), c.op == tagWildcard))
+			terms[i] = fmt.Sprintf(`%s like %s escape '\'`, Logs, quoteSQLString(pattern))
+		}
+		return c.wrap(terms, " or ")
+	}
+
+	field := fmt.Sprintf("try_cast(json_extract_scalar(%s, %s) as double)", c.scope, quoteSQLString(fmt.Sprintf(`$["%s"]`, c.key)))
+	operators := map[tagOperator]string{tagGreater: ">", tagGreaterOrEqual: ">=", tagLess: "<", tagLessOrEqual: "<="}
+	return c.wrap([]string{fmt.Sprintf("%s %s %s", field, operators[c.op], strconv.FormatFloat(c.number, 'f', -1, 64))}, "")
+}
+
+// toSQLFilter renders the condition into the where clause of the analytic statement, for the queries whose search
+// statement can not filter the spans.
+func (c *tagCondition) toSQLFilter() string {
+	if c.scope == tagScopeLog || c.isNumeric() {
+		return c.toSQL()
+	}
+
+	field := fmt.Sprintf("coalesce(json_extract_scalar(%s, %s), '')", c.scope, quoteSQLString(fmt.Sprintf(`$["%s"]`, c.key)))
+	if c.scope == tagScopeLogGroup {
+		field = `"` + c.key + `"`
+	}
+
+	terms := make([]string, len(c.values))
+	for i, v := range c.values {
+		if c.op == tagWildcard {
+			terms[i] = fmt.Sprintf(`%s like %s escape '\'`, field, quoteSQLString(toLikePattern(v, true)))
+		} else {
+			terms[i] = fmt.Sprintf("%s = %s", field, quoteSQLString(v))
+		}
+	}
+	return c.wrap(terms, " or ")
+}
+
+func (c *tagCondition) wrap(terms []string, sep string) string {
+	expr := strings.Join(terms, sep)
+	if len(terms) > 1 {
+		expr = "(" + expr + ")"
+	}
+	if c.negate {
+		expr = "not " + expr
+	}
+	return expr
+}
+
+// jsonString the string as it appears inside the quotes of the JSON the log fields are stored in.
+func jsonString(v string) string {
+	data, _ := json.Marshal(v)
+	return string(data[1 : len(data)-1])
+}
+
+func quoteSQLString(v string) string {
+	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+}
+
+// toLikePattern escapes the special characters of like pattern, and converts * and ? to % and _ for wildcards.
+func toLikePattern(v string, wildcard bool) string {
+	var builder strings.Builder
+	for _, r := range v {
+		switch {
+		case wildcard && r == '*':
+			builder.WriteRune('%')
+		case wildcard && r == '?':
+			builder.WriteRune('_')
+		case r == '%' || r == '_' || r == '\\':
+			builder.WriteRune('\\')
+			builder.WriteRune(r)
+		default:
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,211 @@
+package sls_store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+)
+
+func TestParseTagCondition(t *testing.T) {
+	tests := []struct {
+		key, value string
+		want       tagCondition
+	}{
+		{"http.status_code", "200", tagCondition{scope: tagScopeAttribute, key: "http.status_code", op: tagEqual, values: []string{"200"}}},
+		{"@http.status_code", "200", tagCondition{scope: tagScopeAttribute, key: "http.status_code", op: tagEqual, values: []string{"200"}}},
+		{"@attribute.http.method", "GET", tagCondition{scope: tagScopeAttribute, key: "http.method", op: tagEqual, values: []string{"GET"}}},
+		{"@resource.host.name", "web-1", tagCondition{scope: tagScopeResource, key: "host.name", op: tagEqual, values: []string{"web-1"}}},
+		{"@log.event", "error", tagCondition{scope: tagScopeLog, key: "event", op: tagEqual, values: []string{"error"}}},
+		{"@__topic__", "frontend", tagCondition{scope: tagScopeLogGroup, key: "__topic__", op: tagEqual, values: []string{"frontend"}}},
+		{"@__tag__:cluster", "prod", tagCondition{scope: tagScopeLogGroup, key: "__tag__:cluster", op: tagEqual, values: []string{"prod"}}},
+		{"@error!", "true", tagCondition{scope: tagScopeAttribute, key: "error", op: tagEqual, values: []string{"true"}, negate: true}},
+		{"@error", "!true", tagCondition{scope: tagScopeAttribute, key: "error", op: tagEqual, values: []string{"true"}, negate: true}},
+		{"@error!", "!true", tagCondition{scope: tagScopeAttribute, key: "error", op: tagEqual, values: []string{"true"}}},
+		{"@http.method", "GET|POST", tagCondition{scope: tagScopeAttribute, key: "http.method", op: tagEqual, values: []string{"GET", "POST"}}},
+		{"@http.url", "/api/*", tagCondition{scope: tagScopeAttribute, key: "http.url", op: tagWildcard, values: []string{"/api/*"}}},
+		{"@http.url", "/api/v?|/web/*", tagCondition{scope: tagScopeAttribute, key: "http.url", op: tagWildcard, values: []string{"/api/v?", "/web/*"}}},
+		{"@retries>", "3", tagCondition{scope: tagScopeAttribute, key: "retries", op: tagGreaterOrEqual, number: 3}},
+		{"@retries<", "3", tagCondition{scope: tagScopeAttribute, key: "retries", op: tagLessOrEqual, number: 3}},
+		{"@retries", ">3", tagCondition{scope: tagScopeAttribute, key: "retries", op: tagGreater, number: 3}},
+		{"@retries", ">=3", tagCondition{scope: tagScopeAttribute, key: "retries", op: tagGreaterOrEqual, number: 3}},
+		{"@retries", "<3.5", tagCondition{scope: tagScopeAttribute, key: "retries", op: tagLess, number: 3.5}},
+		{"@resource.cpu", "<=-1", tagCondition{scope: tagScopeResource, key: "cpu", op: tagLessOrEqual, number: -1}},
+		{"@log.message", "it's 100% done", tagCondition{scope: tagScopeLog, key: "message", op: tagEqual, values: []string{"it's 100% done"}}},
+		// plain tags are exact matches of span tags, whatever their key and value contain
+		{"http.url", "/api?id=1", tagCondition{scope: tagScopeAttribute, key: "http.url", op: tagEqual, values: []string{"/api?id=1"}}},
+		{"http.url", "/static/*.js", tagCondition{scope: tagScopeAttribute, key: "http.url", op: tagEqual, values: []string{"/static/*.js"}}},
+		{"http.method", "GET|POST", tagCondition{scope: tagScopeAttribute, key: "http.method", op: tagEqual, values: []string{"GET|POST"}}},
+		{"comparison", ">=3", tagCondition{scope: tagScopeAttribute, key: "comparison", op: tagEqual, values: []string{">=3"}}},
+		{"greeting", "!hello", tagCondition{scope: tagScopeAttribute, key: "greeting", op: tagEqual, values: []string{"!hello"}}},
+		{"log.level", "info", tagCondition{scope: tagScopeAttribute, key: "log.level", op: tagEqual, values: []string{"info"}}},
+		{"resource.type", "pod", tagCondition{scope: tagScopeAttribute, key: "resource.type", op: tagEqual, values: []string{"pod"}}},
+		{"error!", "true", tagCondition{scope: tagScopeAttribute, key: "error!", op: tagEqual, values: []string{"true"}}},
+	}
+
+	for _, test := range tests {
+		got, err := parseTagCondition(test.key, test.value)
+		if err != nil {
+			t.Errorf("parseTagCondition(%q, %q): %v", test.key, test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("parseTagCondition(%q, %q) = %+v, want %+v", test.key, test.value, *got, test.want)
+		}
+	}
+}
+
+func TestParseTagConditionErrors(t *testing.T) {
+	tests := []struct {
+		key, value string
+	}{
+		{"", "value"},
+		{"@", "value"},
+		{"@attribute.", "value"},
+		{"@retries", ">many"},
+		{"@retries", ">NaN"},
+		{"@retries", "<=Inf"},
+		{"@log.count", ">1"},
+		{"@__topic__", ">1"},
+		{"a or service", "x"},
+		{"@a or service", "x"},
+		{`a"`, "x"},
+		{"a)", "x"},
+		{"a:b", "x"},
+		{"@http.url", "* or service: *"},
+		{"@http.url", `a*" or "b`},
+		{"@http.url", "a*)"},
+		{"@http.url", "/api?id=1"},
+		{"@http.url", "a*>b"},
+		{"@http.url", "[a*]"},
+	}
+
+	for _, test := range tests {
+		if got, err := parseTagCondition(test.key, test.value); err == nil {
+			t.Errorf("parseTagCondition(%q, %q) = %+v, want an error", test.key, test.value, *got)
+		}
+	}
+}
+
+func TestTagConditionStatements(t *testing.T) {
+	tests := []struct {
+		key, value string
+		search     string
+		sql        string
+	}{
+		{"http.method", "GET", `attribute.http.method: "GET"`, ""},
+		{"http.url", "/api?id=1", `attribute.http.url: "/api?id=1"`, ""},
+		{"http.url", "/static/*.js|x", `attribute.http.url: "/static/*.js|x"`, ""},
+		{"log.level", "info", `attribute.log.level: "info"`, ""},
+		{"resource.type", "pod", `attribute.resource.type: "pod"`, ""},
+		{"@resource.host.name", "web-1", `resource.host.name: "web-1"`, ""},
+		{"@__tag__:cluster", "prod", `__tag__:cluster: "prod"`, ""},
+		{"@http.method", "GET|POST", `(attribute.http.method: "GET" or attribute.http.method: "POST")`, ""},
+		{"@error!", "true", `not attribute.error: "true"`, ""},
+		{"@http.method!", "GET|POST", `not (attribute.http.method: "GET" or attribute.http.method: "POST")`, ""},
+		{"@http.url", "/api/*", `attribute.http.url: /api/*`, ""},
+		{"@db.statement", `select "a" and b`, `attribute.db.statement: "select \"a\" and b"`, ""},
+		{"@db.statement", "x or service: y", `attribute.db.statement: "x or service: y"`, ""},
+		{"@retries", ">3", "", `try_cast(json_extract_scalar(attribute, '$["retries"]') as double) > 3`},
+		{"@resource.cpu!", "<=0.5", "", `not try_cast(json_extract_scalar(resource, '$["cpu"]') as double) <= 0.5`},
+		{"@log.event", "error", "", `logs like '%"event":"error"%' escape '\'`},
+		{"@log.event", "err*|fail?", "", `(logs like '%"event":"err%"%' escape '\' or logs like '%"event":"fail_"%' escape '\')`},
+		{"@log.message", "100%_done", "", `logs like '%"message":"100\%\_done"%' escape '\'`},
+		{"@log.message", "it's", "", `logs like '%"message":"it''s"%' escape '\'`},
+		{"@log.message", `' or 1=1 --`, "", `logs like '%"message":"'' or 1=1 --"%' escape '\'`},
+		{"@log.message", `say "hi"`, "", `logs like '%"message":"say \\"hi\\""%' escape '\'`},
+		{"@log.path", `C:\tmp`, "", `logs like '%"path":"C:\\\\tmp"%' escape '\'`},
+	}
+
+	for _, test := range tests {
+		c, err := parseTagCondition(test.key, test.value)
+		if err != nil {
+			t.Errorf("parseTagCondition(%q, %q): %v", test.key, test.value, err)
+			continue
+		}
+
+		if test.search != "" {
+			if got := c.toSearch(); got != test.search {
+				t.Errorf("toSearch of %q=%q = %s, want %s", test.key, test.value, got, test.search)
+			}
+		}
+		if test.sql != "" {
+			if got := c.toSQL(); got != test.sql {
+				t.Errorf("toSQL of %q=%q = %s, want %s", test.key, test.value, got, test.sql)
+			}
+		}
+	}
+}
+
+func TestFindTraceIdsQueryTags(t *testing.T) {
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:   "frontend",
+		OperationName: "GET /api' or '1'='1",
+		Tags: map[string]string{
+			"http.status_code": "500",
+			"@retries":         ">=2",
+			"log.level":        "warn",
+			"a or service":     "x",
+		},
+		DurationMin: 10 * time.Millisecond,
+	}
+
+	got := toFindTraceIdsQuery(query, TraceSearchOptions{Order: TraceOrderRecency, DurationMode: DurationModeSpan}, 0, 20)
+	for _, want := range []string{
+		`and attribute.http.status_code: "500"`,
+		`and service: "frontend"`,
+		`and try_cast(json_extract_scalar(attribute, '$["retries"]') as double) >= 2`,
+		`and name = 'GET /api'' or ''1''=''1'`,
+		`and attribute.log.level: "warn"`,
+		`and duration >= 10000`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("query %s does not contain %s", got, want)
+		}
+	}
+	if strings.Contains(got, "a or service") {
+		t.Errorf("query %s contains the invalid tag", got)
+	}
+}
+
+func TestTraceDurationQuery(t *testing.T) {
+	query := &spanstore.TraceQueryParameters{
+		ServiceName:   "frontend",
+		OperationName: "GET /api",
+		Tags:          map[string]string{"@http.url": "/api/*", "@error!": "true", "http.target": "/a?b=1"},
+		DurationMin:   10 * time.Millisecond,
+		DurationMax:   time.Second,
+	}
+
+	got := toFindTraceIdsQuery(query, TraceSearchOptions{Order: TraceOrderRecency, DurationMode: DurationModeTrace}, 0, 20)
+	for _, want := range []string{
+		`where traceid in (select traceid from log where 1=1 `,
+		`and coalesce(json_extract_scalar(attribute, '$["http.url"]'), '') like '/api/%' escape '\'`,
+		`and not coalesce(json_extract_scalar(attribute, '$["error"]'), '') = 'true'`,
+		`and coalesce(json_extract_scalar(attribute, '$["http.target"]'), '') = '/a?b=1'`,
+		`and service = 'frontend'`,
+		`and name = 'GET /api')`,
+		`group by traceid having 1=1 and max("end") - min(start) >= 10000 and max("end") - min(start) <= 1000000`,
+		`order by latest desc`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("query %s does not contain %s", got, want)
+		}
+	}
+	if strings.HasPrefix(got, "* and") || strings.Contains(got, "attribute.http.url:") {
+		t.Errorf("query %s has a search statement", got)
+	}
+
+	got = toFindTraceIdsQuery(query, TraceSearchOptions{Order: TraceOrderDuration, DurationMode: DurationModeRoot}, 0, 20)
+	want := `having 1=1 and max(if(` + rootSpanCondition + `, duration, 0)) >= 10000`
+	if !strings.Contains(got, want) || !strings.Contains(got, "order by longest desc") {
+		t.Errorf("query %s does not contain %s", got, want)
+	}
+
+	got = toFindTraceIdsQuery(query, TraceSearchOptions{DurationMode: DurationModeRoot, RootOnly: true}, 0, 20)
+	if strings.Contains(got, "having") || !strings.Contains(got, "and duration >= 10000") {
+		t.Errorf("query %s does not filter the root span duration", got)
+	}
+}
@@ -0,0 +1,219 @@
+package sls_store
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultTenantHeader the default gRPC metadata key of the tenant, the same as the jaeger tenancy header
+const DefaultTenantHeader = "x-tenant"
+
+// TenantConfig the SLS project, instance and credentials of one tenant. Empty endpoint and credentials fall back to
+// the default ones.
+type TenantConfig struct {
+	Endpoint        string `mapstructure:"endpoint"`
+	AccessKeyID     string `mapstructure:"accessKeyId"`
+	AccessKeySecret string `mapstructure:"accessKeySecret"`
+	Project         string `mapstructure:"project"`
+	Instance        string `mapstructure:"instance"`
+	// LogTimestampUnit the unit of span log timestamps in the project of the tenant, empty uses the one of the plugin
+	LogTimestampUnit string `mapstructure:"logTimestampUnit"`
+}
+
+// TenancyConfig the configuration of multi-tenant routing
+type TenancyConfig struct {
+	Enabled bool
+	// Header the gRPC metadata key carrying the tenant
+	Header  string
+	Tenants map[string]TenantConfig
+}
+
+// tenancy resolves the tenant of every call and routes it to the plugin of the tenant. The plugins of tenants are
+// built on first use and own their clients, caches and write pipelines.
+type tenancy struct {
+	lock    sync.Mutex
+	header  string
+	tenants map[string]TenantConfig
+	plugins map[string]*SlsJaegerStoragePlugin
+	base    SlsJaegerStoragePlugin
+}
+
+func newTenancy(config TenancyConfig, base SlsJaegerStoragePlugin) *tenancy {
+	header := config.Header
+	if header == "" {
+		header = DefaultTenantHeader
+	}
+
+	return &tenancy{
+		header:  header,
+		tenants: config.Tenants,
+		plugins: make(map[string]*SlsJaegerStoragePlugin),
+		base:    base,
+	}
+}
+
+// tenantFromContext reads the tenant from the incoming gRPC metadata.
+func (t *tenancy) tenantFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md.Get(t.header); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// resolve returns the plugin of the tenant of the call, an error for missing or unknown tenants.
+func (t *tenancy) resolve(ctx context.Context) (*SlsJaegerStoragePlugin, error) {
+	tenant := t.tenantFromContext(ctx)
+	if tenant == "" {
+		return nil, status.Errorf(codes.PermissionDenied, "missing tenant header %s", t.header)
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if plugin, ok := t.plugins[tenant]; ok {
+		return plugin, nil
+	}
+
+	config, ok := t.tenants[tenant]
+	if !ok {
+		incCounter("tenant_unknown_requests", 1)
+		return nil, status.Errorf(codes.PermissionDenied, "unknown tenant %q", tenant)
+	}
+
+	plugin := t.base.retarget(FederationTarget{
+		Endpoint:         config.Endpoint,
+		AccessKeyID:      config.AccessKeyID,
+		AccessKeySecret:  config.AccessKeySecret,
+		Project:          config.Project,
+		Instance:         config.Instance,
+		LogTimestampUnit: config.LogTimestampUnit,
+	})
+	if plugin.spoolConfig.Dir != "" {
+		plugin.spoolConfig.Dir = filepath.Join(plugin.spoolConfig.Dir, tenant)
+	}
+	plugin.logger = t.base.logger.With("Tenant", tenant)
+	plugin.start()
+
+	t.base.logger.Info("Initialized tenant", "Tenant", tenant, "Project", config.Project, "Instance", config.Instance)
+	t.plugins[tenant] = &plugin
+	return &plugin, nil
+}
+
+// close closes the plugins of the tenants built so far.
+func (t *tenancy) close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var err error
+	for tenant, plugin := range t.plugins {
+		// the self tracer is shared with the base plugin, which closes it last
+		plugin.tracer = nil
+		if e := plugin.Close(); e != nil {
+			t.base.logger.Error("Failed to close tenant", "Tenant", tenant, "Exception", e)
+			err = e
+		}
+	}
+	return err
+}
+
+// tenantSpanReader routes every read to the span reader of the tenant.
+type tenantSpanReader struct {
+	tenancy *tenancy
+	archive bool
+}
+
+func (r tenantSpanReader) reader(ctx context.Context) (spanstore.Reader, error) {
+	plugin, err := r.tenancy.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.archive {
+		return plugin.ArchiveSpanReader(), nil
+	}
+	return plugin.SpanReader(), nil
+}
+
+func (r tenantSpanReader) GetTrace(ctx context.Context, traceID model.TraceID) (*model.Trace, error) {
+	reader, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return reader.GetTrace(ctx, traceID)
+}
+
+func (r tenantSpanReader) GetServices(ctx context.Context) ([]string, error) {
+	reader, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return reader.GetServices(ctx)
+}
+
+func (r tenantSpanReader) GetOperations(ctx context.Context, query spanstore.OperationQueryParameters) ([]spanstore.Operation, error) {
+	reader, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return reader.GetOperations(ctx, query)
+}
+
+func (r tenantSpanReader) FindTraces(ctx context.Context, query *spanstore.TraceQueryParameters) ([]*model.Trace, error) {
+	reader, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return reader.FindTraces(ctx, query)
+}
+
+func (r tenantSpanReader) FindTraceIDs(ctx context.Context, query *spanstore.TraceQueryParameters) ([]model.TraceID, error) {
+	reader, err := r.reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return reader.FindTraceIDs(ctx, query)
+}
+
+// tenantSpanWriter routes every write to the span writer of the tenant.
+type tenantSpanWriter struct {
+	tenancy *tenancy
+	archive bool
+}
+
+func (w tenantSpanWriter) WriteSpan(ctx context.Context, span *model.Span) error {
+	plugin, err := w.tenancy.resolve(ctx)
+	if err != nil {
+		return err
+	}
+
+	if w.archive {
+		return plugin.ArchiveSpanWriter().WriteSpan(ctx, span)
+	}
+	return plugin.SpanWriter().WriteSpan(ctx, span)
+}
+
+// tenantDependencyReader routes every read to the dependency reader of the tenant.
+type tenantDependencyReader struct {
+	tenancy *tenancy
+}
+
+func (r tenantDependencyReader) GetDependencies(ctx context.Context, endTs time.Time, lookback time.Duration) ([]model.DependencyLink, error) {
+	plugin, err := r.tenancy.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.DependencyReader().GetDependencies(ctx, endTs, lookback)
+}
@@ -0,0 +1,139 @@
+package sls_store
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func tenantContext(tenant string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(DefaultTenantHeader, tenant))
+}
+
+func TestTenancyResolve(t *testing.T) {
+	spoolDir := t.TempDir()
+	base := SlsJaegerStoragePlugin{
+		endpoint:     "default-endpoint",
+		accessKeyID:  "default-id",
+		accessSecret: "default-secret",
+		spoolConfig:  SpoolConfig{Dir: spoolDir},
+		logger:       logger,
+	}
+	tenancy := newTenancy(TenancyConfig{Tenants: map[string]TenantConfig{
+		"a": {Project: "project-a", Instance: "instance-a"},
+		"b": {Endpoint: "endpoint-b", AccessKeyID: "id-b", AccessKeySecret: "secret-b", Project: "project-b",
+			Instance: "instance-b", LogTimestampUnit: TimestampUnitMicroseconds},
+	}}, base)
+	defer tenancy.close()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		wantCode     codes.Code
+		wantEndpoint string
+		wantKeyID    string
+		wantUnit     string
+	}{
+		{"missing tenant", context.Background(), codes.PermissionDenied, "", "", ""},
+		{"empty tenant", tenantContext(""), codes.PermissionDenied, "", "", ""},
+		{"unknown tenant", tenantContext("c"), codes.PermissionDenied, "", "", ""},
+		{"default endpoint and credentials", tenantContext("a"), codes.OK, "default-endpoint", "default-id", ""},
+		{"own endpoint and credentials", tenantContext("b"), codes.OK, "endpoint-b", "id-b", TimestampUnitMicroseconds},
+	}
+
+	for _, test := range tests {
+		plugin, err := tenancy.resolve(test.ctx)
+		if code := status.Code(err); code != test.wantCode {
+			t.Errorf("%s: code %v, want %v", test.name, code, test.wantCode)
+		}
+		if err != nil {
+			continue
+		}
+
+		tenant := tenancy.tenantFromContext(test.ctx)
+		config := tenancy.tenants[tenant]
+		if plugin.instance.project() != config.Project || plugin.instance.traceLogStore() != config.Instance+"-traces" {
+			t.Errorf("%s: routed to %s/%s", test.name, plugin.instance.project(), plugin.instance.traceLogStore())
+		}
+		if plugin.endpoint != test.wantEndpoint || plugin.accessKeyID != test.wantKeyID {
+			t.Errorf("%s: endpoint %s and key %s, want %s and %s", test.name, plugin.endpoint, plugin.accessKeyID,
+				test.wantEndpoint, test.wantKeyID)
+		}
+		if plugin.logTimestampUnit != test.wantUnit {
+			t.Errorf("%s: timestamp unit %q, want %q", test.name, plugin.logTimestampUnit, test.wantUnit)
+		}
+		if plugin.spoolConfig.Dir != filepath.Join(spoolDir, tenant) {
+			t.Errorf("%s: spool dir %s, want a directory per tenant", test.name, plugin.spoolConfig.Dir)
+		}
+		if again, _ := tenancy.resolve(test.ctx); again != plugin {
+			t.Errorf("%s: the plugin of the tenant should be built once", test.name)
+		}
+	}
+}
+
+func TestTenancyCustomHeader(t *testing.T) {
+	tenancy := newTenancy(TenancyConfig{Header: "x-team"}, SlsJaegerStoragePlugin{logger: logger})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-team", "a", DefaultTenantHeader, "b"))
+	if tenant := tenancy.tenantFromContext(ctx); tenant != "a" {
+		t.Errorf("tenant = %q, want the one of the configured header", tenant)
+	}
+}
+
+func TestTenancyRoutesReadsAndWrites(t *testing.T) {
+	fake := newFakeSLS(t)
+	var lock sync.Mutex
+	var read []string
+	fake.getLogs = func(logstore string, query url.Values) ([]map[string]string, string, error) {
+		lock.Lock()
+		read = append(read, logstore)
+		lock.Unlock()
+		return nil, "", nil
+	}
+	plugin := fake.plugin(WithTenancy(TenancyConfig{Enabled: true, Tenants: map[string]TenantConfig{
+		"a": {Project: "project", Instance: "instance-a"},
+		"b": {Project: "project", Instance: "instance-b"},
+	}}))
+	defer plugin.Close()
+
+	span := &model.Span{
+		TraceID:   model.NewTraceID(0, 1),
+		SpanID:    model.NewSpanID(1),
+		StartTime: time.Now(),
+		Process:   &model.Process{ServiceName: "service"},
+	}
+	if err := plugin.SpanWriter().WriteSpan(tenantContext("a"), span); err != nil {
+		t.Fatal(err)
+	}
+	if err := plugin.SpanWriter().WriteSpan(tenantContext("c"), span); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("the write of an unknown tenant returned %v, want PermissionDenied", err)
+	}
+	if logs := fake.logs("instance-a-traces"); len(logs) != 1 {
+		t.Errorf("wrote %d spans to the logstore of the tenant, want 1", len(logs))
+	}
+	if logs := fake.logs("instance-traces"); len(logs) != 0 {
+		t.Errorf("wrote %d spans to the default logstore", len(logs))
+	}
+
+	if trace, err := plugin.SpanReader().GetTrace(tenantContext("b"), span.TraceID); err == nil && len(trace.Spans) > 0 {
+		t.Error("the trace should not be found in the logstore of another tenant")
+	}
+	if _, err := plugin.SpanReader().GetServices(context.Background()); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("the read without a tenant returned %v, want PermissionDenied", err)
+	}
+	for _, logstore := range read {
+		if logstore != "instance-b-traces" {
+			t.Errorf("read %s, want only the logstore of the tenant", logstore)
+		}
+	}
+	if len(read) == 0 {
+		t.Error("the read of the tenant was not sent")
+	}
+}
@@ -0,0 +1,62 @@
+package sls_store
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// TimestampUnitAuto detects the unit of each timestamp from its magnitude, assuming it is after March 1973
+	TimestampUnitAuto = "auto"
+	// TimestampUnitSeconds timestamps in seconds since the epoch
+	TimestampUnitSeconds = "s"
+	// TimestampUnitMilliseconds timestamps in milliseconds since the epoch
+	TimestampUnitMilliseconds = "ms"
+	// TimestampUnitMicroseconds timestamps in microseconds since the epoch
+	TimestampUnitMicroseconds = "us"
+	// TimestampUnitNanoseconds timestamps in nanoseconds since the epoch, the unit the plugin writes
+	TimestampUnitNanoseconds = "ns"
+)
+
+// ValidateTimestampUnit returns an error for unknown timestamp units.
+func ValidateTimestampUnit(unit string) error {
+	switch unit {
+	case TimestampUnitAuto, TimestampUnitSeconds, TimestampUnitMilliseconds, TimestampUnitMicroseconds, TimestampUnitNanoseconds:
+		return nil
+	}
+	return fmt.Errorf("unknown timestamp unit %q, use auto, s, ms, us or ns", unit)
+}
+
+// detectTimestampUnit the unit of the timestamp, each unit covers the timestamps from March 1973 to the year 5138.
+func detectTimestampUnit(v int64) string {
+	switch {
+	case v <= 0:
+		return TimestampUnitNanoseconds
+	case v < 1e11:
+		return TimestampUnitSeconds
+	case v < 1e14:
+		return TimestampUnitMilliseconds
+	case v < 1e17:
+		return TimestampUnitMicroseconds
+	default:
+		return TimestampUnitNanoseconds
+	}
+}
+
+// timestampToTime converts the timestamp in the unit to a time with the full precision of the unit.
+func timestampToTime(v int64, unit string) time.Time {
+	if unit == TimestampUnitAuto {
+		unit = detectTimestampUnit(v)
+	}
+
+	switch unit {
+	case TimestampUnitSeconds:
+		return time.Unix(v, 0)
+	case TimestampUnitMilliseconds:
+		return time.Unix(v/1e3, v%1e3*1e6)
+	case TimestampUnitMicroseconds:
+		return time.Unix(v/1e6, v%1e6*1e3)
+	default:
+		return time.Unix(v/1e9, v%1e9)
+	}
+}
@@ -0,0 +1,122 @@
+package sls_store
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jaegertracing/jaeger/model"
+)
+
+// eventTime has a non zero digit in every sub second position, so that truncation to any unit is visible.
+var eventTime = time.Unix(1700000000, 123456789)
+
+func toTimestamp(t time.Time, unit string) int64 {
+	switch unit {
+	case TimestampUnitSeconds:
+		return t.Unix()
+	case TimestampUnitMilliseconds:
+		return t.UnixNano() / 1e6
+	case TimestampUnitMicroseconds:
+		return t.UnixNano() / 1e3
+	default:
+		return t.UnixNano()
+	}
+}
+
+func TestUnmarshalLogsTimestampUnits(t *testing.T) {
+	tests := []struct {
+		unit string
+		want time.Time
+	}{
+		{TimestampUnitSeconds, time.Unix(1700000000, 0)},
+		{TimestampUnitMilliseconds, time.Unix(1700000000, 123000000)},
+		{TimestampUnitMicroseconds, time.Unix(1700000000, 123456000)},
+		{TimestampUnitNanoseconds, eventTime},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal([]SpanLog{{
+			Time:      toTimestamp(eventTime, test.unit),
+			Attribute: map[string]string{"event": "retry"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, unit := range []string{test.unit, TimestampUnitAuto} {
+			logs, err := unmarshalLogs(string(data), unit)
+			if err != nil {
+				t.Fatalf("unit %s read as %s: %v", test.unit, unit, err)
+			}
+			if len(logs) != 1 || !logs[0].Timestamp.Equal(test.want) {
+				t.Errorf("unit %s read as %s: got %v, want %v", test.unit, unit, logs, test.want)
+			}
+		}
+	}
+}
+
+func TestMarshalLogsKeepsNanoseconds(t *testing.T) {
+	data, err := marshalLogs([]model.Log{{Timestamp: eventTime, Fields: []model.KeyValue{model.String("event", "retry")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := unmarshalLogs(data, TimestampUnitAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || !logs[0].Timestamp.Equal(eventTime) {
+		t.Errorf("got %v, want %v", logs, eventTime)
+	}
+}
+
+func TestDetectTimestampUnit(t *testing.T) {
+	tests := []struct {
+		value int64
+		want  string
+	}{
+		{0, TimestampUnitNanoseconds},
+		{1700000000, TimestampUnitSeconds},
+		{1700000000123, TimestampUnitMilliseconds},
+		{1700000000123456, TimestampUnitMicroseconds},
+		{1700000000123456789, TimestampUnitNanoseconds},
+		{100000000000, TimestampUnitMilliseconds},
+	}
+
+	for _, test := range tests {
+		if got := detectTimestampUnit(test.value); got != test.want {
+			t.Errorf("detectTimestampUnit(%d) = %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+func TestWithLogTimestampUnit(t *testing.T) {
+	plugin := SlsJaegerStoragePlugin{}
+	WithLogTimestampUnit(TimestampUnitMicroseconds)(&plugin)
+	target := plugin.retarget(FederationTarget{Project: "project", Instance: "instance", LogTimestampUnit: TimestampUnitMilliseconds})
+	other := plugin.retarget(FederationTarget{Project: "project", Instance: "instance"})
+
+	logs := []map[string]string{{Logs: `[{"attribute":{"event":"retry"},"time":1700000000123456}]`}}
+	tests := []struct {
+		name   string
+		plugin SlsJaegerStoragePlugin
+		want   time.Time
+	}{
+		{"plugin", plugin, time.Unix(1700000000, 123456000)},
+		{"target with its own unit", target, time.Unix(1700000000123, 456000000)},
+		{"target without a unit", other, time.Unix(1700000000, 123456000)},
+		{"no option", SlsJaegerStoragePlugin{}, time.Unix(1700000000, 123456000)},
+	}
+
+	for _, test := range tests {
+		trace, err := mappingTraceData(test.plugin.buildQueryOptions().attach(context.Background()), logs)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(trace.Spans) != 1 || len(trace.Spans[0].Logs) != 1 || !trace.Spans[0].Logs[0].Timestamp.Equal(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, trace.Spans, test.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package sls_store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket a token bucket rate limiter, rate tokens are added per second up to burst.
+type tokenBucket struct {
+	lock     sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	lastTime time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:     rate,
+		burst:    burst,
+		tokens:   burst,
+		lastTime: time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.lastTime).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.lastTime = now
+}
+
+// allow takes n tokens if there are enough.
+func (b *tokenBucket) allow(n float64) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+// wait takes n tokens, waiting until there are enough or the context is done. It returns how long it waited.
+func (b *tokenBucket) wait(ctx context.Context, n float64) (time.Duration, error) {
+	var waited time.Duration
+	for {
+		b.lock.Lock()
+		b.refill(time.Now())
+		if b.tokens >= n {
+			b.tokens -= n
+			b.lock.Unlock()
+			return waited, nil
+		}
+		delay := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+		b.lock.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return waited, ctx.Err()
+		case <-time.After(delay):
+			waited += delay
+		}
+	}
+}
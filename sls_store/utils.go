@@ -2,6 +2,8 @@ package sls_store
 
 import (
 	"time"
+
+	"github.com/jaegertracing/jaeger/model"
 )
 
 func buildSearchingData(lookback time.Duration) (int64, int64) {
@@ -10,3 +12,23 @@ func buildSearchingData(lookback time.Duration) (int64, int64) {
 	from := currentTime.Add(-1 * lookback).Unix()
 	return from, to
 }
+
+// addTraceWarnings adds the warnings to the first span of the trace, as the jaeger gRPC plugin only returns the spans
+// of a trace. They are kept in the trace warnings too, which tell the cache the trace is not complete. The span is
+// copied, as it may be shared with a cached trace.
+func addTraceWarnings(trace *model.Trace, warnings ...string) {
+	if len(warnings) == 0 {
+		return
+	}
+
+	trace.Warnings = append(trace.Warnings, warnings...)
+	if len(trace.Spans) == 0 {
+		return
+	}
+
+	first := *trace.Spans[0]
+	first.Warnings = append(append([]string(nil), first.Warnings...), warnings...)
+	spans := append([]*model.Span(nil), trace.Spans...)
+	spans[0] = &first
+	trace.Spans = spans
+}
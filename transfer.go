@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aliyun/aliyun-log-jaeger/sls_store"
+	protoio "github.com/gogo/protobuf/io"
+	"github.com/jaegertracing/jaeger/model"
+	uiconv "github.com/jaegertracing/jaeger/model/converter/json"
+	uimodel "github.com/jaegertracing/jaeger/model/json"
+	"github.com/jaegertracing/jaeger/storage/spanstore"
+	collectorV1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	traceV1 "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+// trace file formats
+const (
+	// JaegerFormat the json format of jaeger ui, the same as the "Download JSON" result
+	JaegerFormat = "jaeger"
+	// OTLPFormat the json encoding of OTLP ExportTraceServiceRequest
+	OTLPFormat = "otlp"
+	// ProtoFormat length delimited protobuf encoded model.Trace
+	ProtoFormat = "proto"
+	// MaxTraceFileSize the max size of a trace file
+	MaxTraceFileSize = 256 * 1024 * 1024
+)
+
+type uiTraces struct {
+	Data []*uimodel.Trace `json:"data"`
+}
+
+// runExport exports traces by id or by query from SLS to a file.
+func runExport(args []string) error {
+	var traceIDs, service, operation, tags, format, output string
+	var lookBack, minDuration, maxDuration time.Duration
+	var limit int
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&configPath, "config", "", "Path to the alibaba log jaeger plugin's configuration file")
+	fs.StringVar(&traceIDs, "trace-id", "", "Comma separated trace ids to export")
+	fs.StringVar(&service, "service", "", "Service name of the traces to export")
+	fs.StringVar(&operation, "operation", "", "Operation name of the traces to export")
+	fs.StringVar(&tags, "tags", "", "Tags of the traces to export, in key=value,key=value form")
+	fs.DurationVar(&lookBack, "lookback", time.Hour, "Time range to search traces")
+	fs.DurationVar(&minDuration, "min-duration", 0, "Min duration of the traces to export")
+	fs.DurationVar(&maxDuration, "max-duration", 0, "Max duration of the traces to export")
+	fs.IntVar(&limit, "limit", 20, "Max number of traces to export")
+	fs.StringVar(&format, "format", JaegerFormat, "Output format, jaeger, otlp or proto")
+	fs.StringVar(&output, "output", "", "Output file, stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	configuration, err := initialParameters(configPath, logger)
+	if err != nil {
+		return err
+	}
+	reader := newPlugin(configuration).SpanReader()
+
+	var traces []*model.Trace
+	ctx := context.Background()
+	if traceIDs != "" {
+		for _, id := range strings.Split(traceIDs, ",") {
+			traceID, err := model.TraceIDFromString(strings.TrimSpace(id))
+			if err != nil {
+				return err
+			}
+
+			trace, err := reader.GetTrace(ctx, traceID)
+			if err != nil {
+				return err
+			}
+			traces = append(traces, trace)
+		}
+	} else {
+		if service == "" {
+			return errors.New("either trace-id or service must be set")
+		}
+
+		now := time.Now()
+		traces, err = reader.FindTraces(ctx, &spanstore.TraceQueryParameters{
+			ServiceName:   service,
+			OperationName: operation,
+			Tags:          parseTags(tags),
+			StartTimeMin:  now.Add(-1 * lookBack),
+			StartTimeMax:  now,
+			DurationMin:   minDuration,
+			DurationMax:   maxDuration,
+			NumTraces:     limit,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer := io.Writer(os.Stdout)
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	buffered := bufio.NewWriter(writer)
+	if err := writeTraces(buffered, traces, format); err != nil {
+		return err
+	}
+	logger.Info("Exported traces", "count", len(traces), "format", format)
+	return buffered.Flush()
+}
+
+func writeTraces(w io.Writer, traces []*model.Trace, format string) error {
+	switch format {
+	case JaegerFormat:
+		result := uiTraces{Data: make([]*uimodel.Trace, len(traces))}
+		for i, trace := range traces {
+			result.Data[i] = uiconv.FromDomain(trace)
+		}
+		return json.NewEncoder(w).Encode(result)
+	case OTLPFormat:
+		request := &collectorV1.ExportTraceServiceRequest{}
+		for _, trace := range traces {
+			request.ResourceSpans = append(request.ResourceSpans, sls_store.JaegerTraceToOTLP(trace)...)
+		}
+		data, err := sls_store.MarshalOTLPJSON(request)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case ProtoFormat:
+		delimitedWriter := protoio.NewDelimitedWriter(w)
+		for _, trace := range traces {
+			if err := delimitedWriter.WriteMsg(trace); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// runImport writes traces from a file back to SLS.
+func runImport(args []string) error {
+	var format, input string
+	var rewriteTraceIDs, shiftToNow bool
+	var timeShift time.Duration
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	fs.StringVar(&configPath, "config", "", "Path to the alibaba log jaeger plugin's configuration file")
+	fs.StringVar(&format, "format", JaegerFormat, "Input format, jaeger, otlp or proto")
+	fs.StringVar(&input, "input", "", "Input file, stdin if empty")
+	fs.BoolVar(&rewriteTraceIDs, "rewrite-trace-ids", false, "Replace trace ids with new random ones")
+	fs.DurationVar(&timeShift, "time-shift", 0, "Shift all timestamps by the duration")
+	fs.BoolVar(&shiftToNow, "shift-to-now", false, "Shift all timestamps so that the latest span ends now")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	configuration, err := initialParameters(configPath, logger)
+	if err != nil {
+		return err
+	}
+	plugin := newPlugin(configuration)
+
+	reader := io.Reader(os.Stdin)
+	if input != "" {
+		file, err := os.Open(input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	ctx := context.Background()
+	rewriter := &traceRewriter{
+		rewriteTraceIDs: rewriteTraceIDs,
+		timeShift:       timeShift,
+		shiftToNow:      shiftToNow,
+		traceIDs:        make(map[model.TraceID]model.TraceID),
+		random:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if format == OTLPFormat {
+		data, err := ioutil.ReadAll(io.LimitReader(reader, MaxTraceFileSize))
+		if err != nil {
+			return err
+		}
+
+		request := &collectorV1.ExportTraceServiceRequest{}
+		if err := sls_store.UnmarshalOTLPJSON(data, request); err != nil {
+			return err
+		}
+
+		rewriter.rewriteOTLP(request.ResourceSpans)
+		if _, err := plugin.OTLPReceiver().Export(ctx, request); err != nil {
+			return err
+		}
+		logger.Info("Imported traces", "resourceSpans", len(request.ResourceSpans), "format", format)
+		return nil
+	}
+
+	traces, err := readTraces(reader, format)
+	if err != nil {
+		return err
+	}
+
+	rewriter.rewriteTraces(traces)
+	writer := plugin.SpanWriter()
+	spans := 0
+	for _, trace := range traces {
+		for _, span := range trace.Spans {
+			if err := writer.WriteSpan(ctx, span); err != nil {
+				return err
+			}
+			spans++
+		}
+	}
+
+	logger.Info("Imported traces", "count", len(traces), "spans", spans, "format", format)
+	return nil
+}
+
+func readTraces(r io.Reader, format string) ([]*model.Trace, error) {
+	switch format {
+	case JaegerFormat:
+		decoder := json.NewDecoder(io.LimitReader(r, MaxTraceFileSize))
+		decoder.UseNumber()
+		data := uiTraces{}
+		if err := decoder.Decode(&data); err != nil {
+			return nil, err
+		}
+
+		traces := make([]*model.Trace, 0, len(data.Data))
+		for _, t := range data.Data {
+			trace, err := uiTraceToDomain(t)
+			if err != nil {
+				return nil, err
+			}
+			traces = append(traces, trace)
+		}
+		return traces, nil
+	case ProtoFormat:
+		delimitedReader := protoio.NewDelimitedReader(r, MaxTraceFileSize)
+		traces := make([]*model.Trace, 0)
+		for {
+			trace := &model.Trace{}
+			if err := delimitedReader.ReadMsg(trace); err == io.EOF {
+				return traces, nil
+			} else if err != nil {
+				return nil, err
+			}
+			traces = append(traces, trace)
+		}
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func uiTraceToDomain(trace *uimodel.Trace) (*model.Trace, error) {
+	result := &model.Trace{Warnings: trace.Warnings}
+	for _, s := range trace.Spans {
+		traceID, err := model.TraceIDFromString(string(s.TraceID))
+		if err != nil {
+			return nil, err
+		}
+		spanID, err := model.SpanIDFromString(string(s.SpanID))
+		if err != nil {
+			return nil, err
+		}
+
+		span := &model.Span{
+			TraceID:       traceID,
+			SpanID:        spanID,
+			OperationName: s.OperationName,
+			Flags:         model.Flags(s.Flags),
+			StartTime:     model.EpochMicrosecondsAsTime(s.StartTime),
+			Duration:      model.MicrosecondsAsDuration(s.Duration),
+			ProcessID:     string(s.ProcessID),
+			Warnings:      s.Warnings,
+		}
+
+		for _, ref := range s.References {
+			refTraceID, err := model.TraceIDFromString(string(ref.TraceID))
+			if err != nil {
+				return nil, err
+			}
+			refSpanID, err := model.SpanIDFromString(string(ref.SpanID))
+			if err != nil {
+				return nil, err
+			}
+			refType := model.SpanRefType_CHILD_OF
+			if ref.RefType == uimodel.FollowsFrom {
+				refType = model.SpanRefType_FOLLOWS_FROM
+			}
+			span.References = append(span.References, model.SpanRef{TraceID: refTraceID, SpanID: refSpanID, RefType: refType})
+		}
+
+		if span.Tags, err = uiKeyValuesToDomain(s.Tags); err != nil {
+			return nil, err
+		}
+
+		for _, l := range s.Logs {
+			fields, err := uiKeyValuesToDomain(l.Fields)
+			if err != nil {
+				return nil, err
+			}
+			span.Logs = append(span.Logs, model.Log{Timestamp: model.EpochMicrosecondsAsTime(l.Timestamp), Fields: fields})
+		}
+
+		process := s.Process
+		if process == nil {
+			if p, ok := trace.Processes[s.ProcessID]; ok {
+				process = &p
+			}
+		}
+		span.Process = &model.Process{}
+		if process != nil {
+			span.Process.ServiceName = process.ServiceName
+			if span.Process.Tags, err = uiKeyValuesToDomain(process.Tags); err != nil {
+				return nil, err
+			}
+		}
+
+		result.Spans = append(result.Spans, span)
+	}
+
+	return result, nil
+}
+
+func uiKeyValuesToDomain(kvs []uimodel.KeyValue) ([]model.KeyValue, error) {
+	result := make([]model.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		value := fmt.Sprintf("%v", kv.Value)
+		switch kv.Type {
+		case uimodel.BoolType:
+			result = append(result, model.Bool(kv.Key, value == "true"))
+		case uimodel.Int64Type:
+			number, err := json.Number(value).Int64()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, model.Int64(kv.Key, number))
+		case uimodel.Float64Type:
+			number, err := json.Number(value).Float64()
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, model.Float64(kv.Key, number))
+		case uimodel.BinaryType:
+			data, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, model.Binary(kv.Key, data))
+		default:
+			result = append(result, model.String(kv.Key, value))
+		}
+	}
+	return result, nil
+}
+
+// traceRewriter rewrites trace ids and shifts timestamps of imported traces.
+type traceRewriter struct {
+	rewriteTraceIDs bool
+	timeShift       time.Duration
+	shiftToNow      bool
+	traceIDs        map[model.TraceID]model.TraceID
+	random          *rand.Rand
+}
+
+func (r *traceRewriter) traceID(id model.TraceID) model.TraceID {
+	if !r.rewriteTraceIDs {
+		return id
+	}
+
+	if newID, ok := r.traceIDs[id]; ok {
+		return newID
+	}
+	newID := model.NewTraceID(r.random.Uint64(), r.random.Uint64())
+	r.traceIDs[id] = newID
+	return newID
+}
+
+func (r *traceRewriter) rewriteTraces(traces []*model.Trace) {
+	shift := r.timeShift
+	if r.shiftToNow {
+		var latest time.Time
+		for _, trace := range traces {
+			for _, span := range trace.Spans {
+				if end := span.StartTime.Add(span.Duration); end.After(latest) {
+					latest = end
+				}
+			}
+		}
+		shift = time.Since(latest)
+	}
+
+	for _, trace := range traces {
+		for _, span := range trace.Spans {
+			span.TraceID = r.traceID(span.TraceID)
+			for i := range span.References {
+				span.References[i].TraceID = r.traceID(span.References[i].TraceID)
+			}
+			span.StartTime = span.StartTime.Add(shift)
+			for i := range span.Logs {
+				span.Logs[i].Timestamp = span.Logs[i].Timestamp.Add(shift)
+			}
+		}
+	}
+}
+
+func (r *traceRewriter) rewriteOTLP(resourceSpans []*traceV1.ResourceSpans) {
+	var shift int64
+	if r.shiftToNow {
+		var latest uint64
+		r.forEachOTLPSpan(resourceSpans, func(span *traceV1.Span) {
+			if span.EndTimeUnixNano > latest {
+				latest = span.EndTimeUnixNano
+			}
+		})
+		shift = time.Now().UnixNano() - int64(latest)
+	} else {
+		shift = r.timeShift.Nanoseconds()
+	}
+
+	r.forEachOTLPSpan(resourceSpans, func(span *traceV1.Span) {
+		span.TraceId = r.otlpTraceID(span.TraceId)
+		for _, link := range span.Links {
+			link.TraceId = r.otlpTraceID(link.TraceId)
+		}
+		span.StartTimeUnixNano = uint64(int64(span.StartTimeUnixNano) + shift)
+		span.EndTimeUnixNano = uint64(int64(span.EndTimeUnixNano) + shift)
+		for _, event := range span.Events {
+			event.TimeUnixNano = uint64(int64(event.TimeUnixNano) + shift)
+		}
+	})
+}
+
+func (r *traceRewriter) otlpTraceID(id []byte) []byte {
+	traceID, err := model.TraceIDFromBytes(id)
+	if err != nil {
+		return id
+	}
+
+	data := make([]byte, 16)
+	newID := r.traceID(traceID)
+	_, _ = newID.MarshalTo(data)
+	return data
+}
+
+func (r *traceRewriter) forEachOTLPSpan(resourceSpans []*traceV1.ResourceSpans, fn func(span *traceV1.Span)) {
+	for _, rs := range resourceSpans {
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, span := range ils.Spans {
+				fn(span)
+			}
+		}
+	}
+}
+
+func parseTags(tags string) map[string]string {
+	result := make(map[string]string)
+	if tags == "" {
+		return result
+	}
+
+	for _, pair := range strings.Split(tags, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return result
+}